@@ -0,0 +1,291 @@
+package ali_mns
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gogap/errors"
+)
+
+const (
+	DefaultAckerFlushInterval = 100 * time.Millisecond
+	DefaultAckerMaxRetries    = 3
+	DefaultAckerBufferSize    = 1024
+)
+
+// AckerConfig configures an Acker.
+//
+// OnInvalid is called for handles that can never be deleted: the ones the
+// service answers with ReceiptHandleError or MessageNotExist and the ones whose
+// NextVisibleTime passed before they were sent, with
+// ERR_MNS_RECEIPT_HANDLE_EXPIRED. Handles failed with InternalError,
+// QpsLimitExceeded or a transport error are retried up to MaxRetries times,
+// OnFailure is called for the ones still failing and at once for any other
+// error. Both run on the acker goroutine.
+type AckerConfig struct {
+	FlushInterval time.Duration
+	MaxRetries    int
+	BufferSize    int
+
+	OnInvalid func(receiptHandle string, err error)
+	OnFailure func(receiptHandle string, err error)
+}
+
+func (p AckerConfig) normalize() AckerConfig {
+	if p.FlushInterval <= 0 {
+		p.FlushInterval = DefaultAckerFlushInterval
+	}
+	if p.MaxRetries < 0 {
+		p.MaxRetries = 0
+	} else if p.MaxRetries == 0 {
+		p.MaxRetries = DefaultAckerMaxRetries
+	}
+	if p.BufferSize <= 0 {
+		p.BufferSize = DefaultAckerBufferSize
+	}
+	return p
+}
+
+type ackEntry struct {
+	receiptHandle string
+	// nextVisibleTime in milliseconds, zero if unknown
+	nextVisibleTime int64
+	retries         int
+}
+
+type ackRequest struct {
+	entry   *ackEntry
+	flushed chan struct{}
+}
+
+// Acker deletes receipt handles in batches of up to 16, collected from any
+// number of goroutines and sent after FlushInterval at the latest.
+type Acker struct {
+	queue  AliMNSQueue
+	config AckerConfig
+
+	locker   sync.RWMutex
+	closed   bool
+	senders  sync.WaitGroup
+	done     chan struct{}
+	requests chan ackRequest
+	stopped  chan struct{}
+}
+
+// NewAcker creates an Acker, a negative config.MaxRetries disables retries.
+func NewAcker(queue AliMNSQueue, config AckerConfig) *Acker {
+	config = config.normalize()
+	acker := &Acker{
+		queue:    queue,
+		config:   config,
+		done:     make(chan struct{}),
+		requests: make(chan ackRequest, config.BufferSize),
+		stopped:  make(chan struct{}),
+	}
+	go acker.run()
+	return acker
+}
+
+// Ack schedules receiptHandle for deletion, nextVisibleTime is the
+// NextVisibleTime of the message in milliseconds, or zero if unknown.
+func (p *Acker) Ack(receiptHandle string, nextVisibleTime int64) error {
+	return p.enqueue(ackRequest{entry: &ackEntry{receiptHandle: receiptHandle, nextVisibleTime: nextVisibleTime}})
+}
+
+// AckMessage schedules a received message for deletion.
+func (p *Acker) AckMessage(message MessageReceiveResponse) error {
+	return p.Ack(message.ReceiptHandle, message.NextVisibleTime)
+}
+
+// Flush deletes all handles acked before the call, retries included, and
+// waits for them.
+func (p *Acker) Flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+	if err := p.enqueue(ackRequest{flushed: flushed}); err != nil {
+		return err
+	}
+
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting handles and waits until the pending ones are done.
+// An Ack blocked on a full buffer fails with ERR_MNS_ACKER_CLOSED.
+func (p *Acker) Close(ctx context.Context) error {
+	p.locker.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.done)
+		go func() {
+			p.senders.Wait()
+			close(p.requests)
+		}()
+	}
+	p.locker.Unlock()
+
+	select {
+	case <-p.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Acker) enqueue(request ackRequest) error {
+	p.locker.RLock()
+	if p.closed {
+		p.locker.RUnlock()
+		return ERR_MNS_ACKER_CLOSED.New(errors.Params{"queue": p.queue.Name()})
+	}
+	p.senders.Add(1)
+	p.locker.RUnlock()
+	defer p.senders.Done()
+
+	select {
+	case p.requests <- request:
+		return nil
+	case <-p.done:
+		return ERR_MNS_ACKER_CLOSED.New(errors.Params{"queue": p.queue.Name()})
+	}
+}
+
+func (p *Acker) run() {
+	defer close(p.stopped)
+
+	var pending []*ackEntry
+	var tick <-chan time.Time
+	var timer *time.Timer
+
+	flush := func() {
+		if timer != nil {
+			timer.Stop()
+			timer, tick = nil, nil
+		}
+		pending = p.deleteHandles(pending)
+		if len(pending) > 0 {
+			timer = time.NewTimer(p.config.FlushInterval)
+			tick = timer.C
+		}
+	}
+
+	drain := func() {
+		for flush(); len(pending) > 0; flush() {
+			time.Sleep(p.config.FlushInterval)
+		}
+	}
+
+	for {
+		select {
+		case request, ok := <-p.requests:
+			if !ok {
+				drain()
+				return
+			}
+
+			if request.flushed != nil {
+				drain()
+				close(request.flushed)
+				continue
+			}
+
+			pending = append(pending, request.entry)
+			if len(pending) == 1 {
+				timer = time.NewTimer(p.config.FlushInterval)
+				tick = timer.C
+			}
+
+			// don't let a handle expire while waiting for the batch to fill
+			entry := request.entry
+			if len(pending) >= batchMaxMessages || (entry.nextVisibleTime > 0 &&
+				time.Until(millisecondsTime(entry.nextVisibleTime)) < p.config.FlushInterval) {
+				flush()
+			}
+		case <-tick:
+			timer, tick = nil, nil
+			flush()
+		}
+	}
+}
+
+// deleteHandles sends pending in batches and returns the handles to retry.
+func (p *Acker) deleteHandles(pending []*ackEntry) (retry []*ackEntry) {
+	var batch []*ackEntry
+	seen := map[string]bool{}
+	for _, entry := range pending {
+		if seen[entry.receiptHandle] {
+			continue
+		}
+		seen[entry.receiptHandle] = true
+
+		if entry.nextVisibleTime > 0 && !time.Now().Before(millisecondsTime(entry.nextVisibleTime)) {
+			p.invalid(entry.receiptHandle, ERR_MNS_RECEIPT_HANDLE_EXPIRED.New(errors.Params{
+				"handle": entry.receiptHandle,
+				"time":   millisecondsTime(entry.nextVisibleTime),
+			}))
+			continue
+		}
+		batch = append(batch, entry)
+	}
+
+	for len(batch) > 0 {
+		n := len(batch)
+		if n > batchMaxMessages {
+			n = batchMaxMessages
+		}
+		retry = append(retry, p.deleteBatch(batch[:n])...)
+		batch = batch[n:]
+	}
+	return
+}
+
+func (p *Acker) deleteBatch(batch []*ackEntry) (retry []*ackEntry) {
+	receiptHandles := make([]string, len(batch))
+	for i, entry := range batch {
+		receiptHandles[i] = entry.receiptHandle
+	}
+
+	resp, batchErr := p.queue.BatchDeleteMessage(receiptHandles...)
+	for _, entry := range batch {
+		err := batchErr
+		if err == nil || IsMNSError(err, ERR_MNS_BATCH_OP_FAIL) {
+			err = resp.Err(entry.receiptHandle)
+		}
+
+		switch {
+		case err == nil:
+		case IsMNSError(err, ERR_MNS_RECEIPT_HANDLE_ERROR) || IsMNSError(err, ERR_MNS_MESSAGE_NOT_EXIST):
+			p.invalid(entry.receiptHandle, err)
+		case entry.retries < p.config.MaxRetries && isTransientError(err):
+			entry.retries++
+			retry = append(retry, entry)
+		default:
+			if p.config.OnFailure != nil {
+				p.config.OnFailure(entry.receiptHandle, err)
+			}
+		}
+	}
+	return
+}
+
+// isTransientError reports whether a request failed with err may succeed
+// when sent again.
+func isTransientError(err error) bool {
+	return retryableBatchErrorCodes[MNSErrorCode(err)] ||
+		IsMNSError(err, ERR_SEND_REQUEST_FAILED) ||
+		IsMNSError(err, ERR_READ_RESPONSE_BODY_FAILED)
+}
+
+func (p *Acker) invalid(receiptHandle string, err error) {
+	if p.config.OnInvalid != nil {
+		p.config.OnInvalid(receiptHandle, err)
+	}
+}
+
+func millisecondsTime(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
@@ -0,0 +1,173 @@
+package ali_mns
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+// flakyClient answers the first failures requests with InternalError.
+type flakyClient struct {
+	gatedClient
+	failures int
+}
+
+func (p *flakyClient) Send(method Method, headers map[string]string, message interface{}, resource string, opts ...Option) (*fasthttp.Response, error) {
+	p.locker.Lock()
+	p.sends++
+	failed := p.sends <= p.failures
+	p.locker.Unlock()
+
+	if failed {
+		return faultResponse(500, []byte(`<Error><Code>InternalError</Code><Message>busy</Message></Error>`)), nil
+	}
+	return p.MNSClient.Send(method, headers, message, resource, opts...)
+}
+
+func TestAckerBatches(t *testing.T) {
+	emulator := NewMNSEmulator("", "")
+	assert.Nil(t, NewMNSQueueManager(emulator).CreateSimpleQueue("test-queue"))
+	queue := NewMNSQueue("test-queue", emulator)
+
+	var messages []MessageReceiveResponse
+	for i := 0; i < 20; i++ {
+		_, err := queue.SendMessage(MessageSendRequest{MessageBody: fmt.Sprintf("message-%d", i)})
+		assert.Nil(t, err)
+		message, err := receiveOne(queue)
+		assert.Nil(t, err)
+		messages = append(messages, message)
+	}
+
+	client := &gatedClient{MNSClient: emulator}
+	// shorter than the visibility timeout, or every handle flushes at once
+	acker := NewAcker(NewMNSQueue("test-queue", client), AckerConfig{FlushInterval: 10 * time.Second})
+
+	var wg sync.WaitGroup
+	for _, message := range messages {
+		wg.Add(1)
+		go func(message MessageReceiveResponse) {
+			defer wg.Done()
+			assert.Nil(t, acker.AckMessage(message))
+		}(message)
+	}
+	wg.Wait()
+
+	assert.Nil(t, acker.Close(context.Background()))
+	assert.Equal(t, 2, client.count())
+
+	attr, err := NewMNSQueueManager(emulator).GetQueueAttributes("test-queue")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), attr.ActiveMessages+attr.InactiveMessages)
+
+	assert.True(t, IsMNSError(acker.Ack("late", 0), ERR_MNS_ACKER_CLOSED))
+}
+
+func TestAckerInvalidHandles(t *testing.T) {
+	emulator := NewMNSEmulator("", "")
+	assert.Nil(t, NewMNSQueueManager(emulator).CreateSimpleQueue("test-queue"))
+	client := &gatedClient{MNSClient: emulator}
+
+	invalid := map[string]error{}
+	acker := NewAcker(NewMNSQueue("test-queue", client), AckerConfig{
+		OnInvalid: func(receiptHandle string, err error) {
+			invalid[receiptHandle] = err
+		},
+		OnFailure: func(receiptHandle string, err error) {
+			t.Errorf("unexpected failure of %s: %s", receiptHandle, err)
+		},
+	})
+
+	expiredAt := time.Now().Add(-time.Second).UnixNano() / int64(time.Millisecond)
+	assert.Nil(t, acker.Ack("expired-handle", expiredAt))
+	assert.Nil(t, acker.Flush(context.Background()))
+	assert.Equal(t, 0, client.count())
+	assert.True(t, IsMNSError(invalid["expired-handle"], ERR_MNS_RECEIPT_HANDLE_EXPIRED))
+
+	assert.Nil(t, acker.Ack("unknown-1234", 0))
+	assert.Nil(t, acker.Close(context.Background()))
+	assert.Equal(t, 1, client.count())
+	err := invalid["unknown-1234"]
+	assert.True(t, IsMNSError(err, ERR_MNS_RECEIPT_HANDLE_ERROR) || IsMNSError(err, ERR_MNS_MESSAGE_NOT_EXIST))
+}
+
+func TestAckerRetries(t *testing.T) {
+	emulator := NewMNSEmulator("", "")
+	assert.Nil(t, NewMNSQueueManager(emulator).CreateSimpleQueue("test-queue"))
+	queue := NewMNSQueue("test-queue", emulator)
+	_, err := queue.SendMessage(MessageSendRequest{MessageBody: "hello"})
+	assert.Nil(t, err)
+	message, err := receiveOne(queue)
+	assert.Nil(t, err)
+
+	client := &flakyClient{gatedClient: gatedClient{MNSClient: emulator}, failures: 2}
+	acker := NewAcker(NewMNSQueue("test-queue", client), AckerConfig{FlushInterval: 5 * time.Millisecond})
+	assert.Nil(t, acker.AckMessage(message))
+	assert.Nil(t, acker.Close(context.Background()))
+	assert.Equal(t, 3, client.count())
+
+	var failed []string
+	client = &flakyClient{gatedClient: gatedClient{MNSClient: emulator}, failures: 10}
+	acker = NewAcker(NewMNSQueue("test-queue", client), AckerConfig{
+		FlushInterval: 5 * time.Millisecond,
+		MaxRetries:    1,
+		OnFailure: func(receiptHandle string, err error) {
+			assert.True(t, IsMNSError(err, ERR_MNS_INTERNAL_ERROR))
+			failed = append(failed, receiptHandle)
+		},
+	})
+	assert.Nil(t, acker.Ack("handle", 0))
+	assert.Nil(t, acker.Close(context.Background()))
+	assert.Equal(t, 2, client.count())
+	assert.Equal(t, []string{"handle"}, failed)
+
+	// other errors are not retried
+	failed = nil
+	client = &flakyClient{gatedClient: gatedClient{MNSClient: emulator}}
+	acker = NewAcker(NewMNSQueue("no-queue", client), AckerConfig{
+		FlushInterval: 5 * time.Millisecond,
+		OnFailure: func(receiptHandle string, err error) {
+			assert.True(t, IsMNSError(err, ERR_MNS_QUEUE_NOT_EXIST))
+			failed = append(failed, receiptHandle)
+		},
+	})
+	assert.Nil(t, acker.Ack("handle", 0))
+	assert.Nil(t, acker.Close(context.Background()))
+	assert.Equal(t, 1, client.count())
+	assert.Equal(t, []string{"handle"}, failed)
+}
+
+func TestAckerCloseWhileBlocked(t *testing.T) {
+	emulator := NewMNSEmulator("", "")
+	assert.Nil(t, NewMNSQueueManager(emulator).CreateSimpleQueue("test-queue"))
+	client := &gatedClient{MNSClient: emulator, gate: make(chan struct{})}
+
+	acker := NewAcker(NewMNSQueue("test-queue", client), AckerConfig{
+		FlushInterval: time.Millisecond,
+		BufferSize:    1,
+	})
+
+	assert.Nil(t, acker.Ack("first", 0))
+	time.Sleep(50 * time.Millisecond)
+	assert.Nil(t, acker.Ack("second", 0))
+
+	blocked := make(chan error, 1)
+	go func() {
+		blocked <- acker.Ack("third", 0)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// Close honours its ctx and releases the blocked Ack
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	assert.Equal(t, context.DeadlineExceeded, acker.Close(ctx))
+	cancel()
+	assert.True(t, IsMNSError(<-blocked, ERR_MNS_ACKER_CLOSED))
+
+	close(client.gate)
+	assert.Nil(t, acker.Close(context.Background()))
+	assert.Equal(t, 2, client.count())
+}
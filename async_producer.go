@@ -0,0 +1,263 @@
+package ali_mns
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gogap/errors"
+)
+
+const (
+	DefaultProducerLinger     = 20 * time.Millisecond
+	DefaultProducerBufferSize = 1024
+)
+
+type BufferFullPolicy int
+
+const (
+	// BufferFullBlock makes Send wait for room in the buffer.
+	BufferFullBlock BufferFullPolicy = iota
+	// BufferFullFail makes Send fail with ERR_MNS_PRODUCER_BUFFER_FULL.
+	BufferFullFail
+)
+
+// AsyncProducerConfig configures an AsyncProducer, a batch is sent when it
+// holds 16 messages, reaches 64KB or its first message waited Linger.
+type AsyncProducerConfig struct {
+	Linger     time.Duration
+	BufferSize int
+	OnFull     BufferFullPolicy
+}
+
+func (p AsyncProducerConfig) normalize() AsyncProducerConfig {
+	if p.Linger <= 0 {
+		p.Linger = DefaultProducerLinger
+	}
+	if p.BufferSize <= 0 {
+		p.BufferSize = DefaultProducerBufferSize
+	}
+	return p
+}
+
+// SendFuture is the pending result of a message sent by an AsyncProducer.
+type SendFuture struct {
+	done chan struct{}
+	resp MessageSendResponse
+	err  error
+}
+
+// Done is closed once the message was sent or failed.
+func (f *SendFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait blocks until the message was sent or failed.
+func (f *SendFuture) Wait() (MessageSendResponse, error) {
+	<-f.done
+	return f.resp, f.err
+}
+
+type pendingMessage struct {
+	message  MessageSendRequest
+	future   *SendFuture
+	callback func(resp MessageSendResponse, err error)
+
+	// flushed is set for the marker of a Flush, closed when all messages
+	// before it are sent
+	flushed chan struct{}
+}
+
+// AsyncProducer buffers messages and sends them through BatchSendMessage,
+// one batch at a time in the order they were given.
+type AsyncProducer struct {
+	queue  AliMNSQueue
+	config AsyncProducerConfig
+
+	locker  sync.RWMutex
+	closed  bool
+	senders sync.WaitGroup
+	done    chan struct{}
+	pending chan *pendingMessage
+	stopped chan struct{}
+}
+
+func NewAsyncProducer(queue AliMNSQueue, config AsyncProducerConfig) *AsyncProducer {
+	config = config.normalize()
+	producer := &AsyncProducer{
+		queue:   queue,
+		config:  config,
+		done:    make(chan struct{}),
+		pending: make(chan *pendingMessage, config.BufferSize),
+		stopped: make(chan struct{}),
+	}
+	go producer.run()
+	return producer
+}
+
+// Send buffers message, the future tells its MessageId once it was sent.
+func (p *AsyncProducer) Send(message MessageSendRequest) (*SendFuture, error) {
+	future := &SendFuture{done: make(chan struct{})}
+	if err := p.enqueue(&pendingMessage{message: message, future: future}, p.config.OnFull); err != nil {
+		return nil, err
+	}
+	return future, nil
+}
+
+// SendWithCallback buffers message and calls callback once it was sent or
+// failed. Callbacks run on the producer goroutine and must not block.
+func (p *AsyncProducer) SendWithCallback(message MessageSendRequest, callback func(resp MessageSendResponse, err error)) error {
+	return p.enqueue(&pendingMessage{message: message, callback: callback}, p.config.OnFull)
+}
+
+// Flush sends all messages buffered before the call and waits for them.
+func (p *AsyncProducer) Flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+	if err := p.enqueue(&pendingMessage{flushed: flushed}, BufferFullBlock); err != nil {
+		return err
+	}
+
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting messages and waits until the buffered ones are sent.
+// A Send blocked on a full buffer fails with ERR_MNS_PRODUCER_CLOSED. If ctx
+// is done first the buffered messages keep being sent in the background.
+func (p *AsyncProducer) Close(ctx context.Context) error {
+	p.locker.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.done)
+		// pending is closed once no enqueue can send to it anymore
+		go func() {
+			p.senders.Wait()
+			close(p.pending)
+		}()
+	}
+	p.locker.Unlock()
+
+	select {
+	case <-p.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *AsyncProducer) enqueue(item *pendingMessage, policy BufferFullPolicy) error {
+	p.locker.RLock()
+	if p.closed {
+		p.locker.RUnlock()
+		return ERR_MNS_PRODUCER_CLOSED.New(errors.Params{"queue": p.queue.Name()})
+	}
+	p.senders.Add(1)
+	p.locker.RUnlock()
+	defer p.senders.Done()
+
+	if policy == BufferFullFail {
+		select {
+		case p.pending <- item:
+			return nil
+		default:
+			return ERR_MNS_PRODUCER_BUFFER_FULL.New(errors.Params{"queue": p.queue.Name(), "size": p.config.BufferSize})
+		}
+	}
+
+	select {
+	case p.pending <- item:
+		return nil
+	case <-p.done:
+		return ERR_MNS_PRODUCER_CLOSED.New(errors.Params{"queue": p.queue.Name()})
+	}
+}
+
+func (p *AsyncProducer) run() {
+	defer close(p.stopped)
+
+	var batch []*pendingMessage
+	var linger <-chan time.Time
+	var timer *time.Timer
+	size := 0
+
+	flush := func() {
+		if timer != nil {
+			timer.Stop()
+			timer, linger = nil, nil
+		}
+		p.sendBatch(batch)
+		batch, size = nil, 0
+	}
+
+	for {
+		select {
+		case item, ok := <-p.pending:
+			if !ok {
+				flush()
+				return
+			}
+
+			if item.flushed != nil {
+				flush()
+				close(item.flushed)
+				continue
+			}
+
+			bodySize := xmlEscapedLen(item.message.MessageBody)
+			if len(batch) > 0 && size+bodySize > batchMaxBytes {
+				flush()
+			}
+
+			batch = append(batch, item)
+			size += bodySize
+			if len(batch) == 1 {
+				timer = time.NewTimer(p.config.Linger)
+				linger = timer.C
+			}
+			if len(batch) == batchMaxMessages || size >= batchMaxBytes {
+				flush()
+			}
+		case <-linger:
+			timer, linger = nil, nil
+			flush()
+		}
+	}
+}
+
+func (p *AsyncProducer) sendBatch(batch []*pendingMessage) {
+	if len(batch) == 0 {
+		return
+	}
+
+	messages := make([]MessageSendRequest, len(batch))
+	for i, item := range batch {
+		messages[i] = item.message
+	}
+
+	resp, batchErr := p.queue.BatchSendMessage(messages...)
+	for i, item := range batch {
+		var sendResp MessageSendResponse
+		err := resp.Err(i)
+		if IsMNSError(err, ERR_MNS_BATCH_ENTRY_NOT_SENT) && batchErr != nil &&
+			!IsMNSError(batchErr, ERR_MNS_BATCH_OP_FAIL) {
+			err = batchErr
+		}
+		if err == nil {
+			sendResp.RequestID = resp.RequestID
+			sendResp.MessageId = resp.Messages[i].MessageId
+			sendResp.MessageBodyMD5 = resp.Messages[i].MessageBodyMD5
+		}
+
+		if item.future != nil {
+			item.future.resp, item.future.err = sendResp, err
+			close(item.future.done)
+		}
+		if item.callback != nil {
+			item.callback(sendResp, err)
+		}
+	}
+}
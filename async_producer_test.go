@@ -0,0 +1,158 @@
+package ali_mns
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+// gatedClient counts requests and holds them until gate is closed, if set.
+type gatedClient struct {
+	MNSClient
+	gate chan struct{}
+
+	locker sync.Mutex
+	sends  int
+}
+
+func (p *gatedClient) Send(method Method, headers map[string]string, message interface{}, resource string, opts ...Option) (*fasthttp.Response, error) {
+	if p.gate != nil {
+		<-p.gate
+	}
+	p.locker.Lock()
+	p.sends++
+	p.locker.Unlock()
+	return p.MNSClient.Send(method, headers, message, resource, opts...)
+}
+
+func (p *gatedClient) count() int {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	return p.sends
+}
+
+func TestAsyncProducerBatches(t *testing.T) {
+	emulator := NewMNSEmulator("", "")
+	assert.Nil(t, NewMNSQueueManager(emulator).CreateSimpleQueue("test-queue"))
+	client := &gatedClient{MNSClient: emulator}
+
+	producer := NewAsyncProducer(NewMNSQueue("test-queue", client), AsyncProducerConfig{Linger: time.Minute})
+
+	var futures []*SendFuture
+	for i := 0; i < 40; i++ {
+		future, err := producer.Send(MessageSendRequest{MessageBody: fmt.Sprintf("message-%d", i)})
+		assert.Nil(t, err)
+		futures = append(futures, future)
+	}
+
+	var callbackResp MessageSendResponse
+	assert.Nil(t, producer.SendWithCallback(MessageSendRequest{MessageBody: "callback"}, func(resp MessageSendResponse, err error) {
+		callbackResp = resp
+	}))
+
+	assert.Nil(t, producer.Flush(context.Background()))
+	assert.Equal(t, 3, client.count())
+	assert.NotEmpty(t, callbackResp.MessageId)
+
+	ids := map[string]bool{}
+	for _, future := range futures {
+		resp, err := future.Wait()
+		assert.Nil(t, err)
+		ids[resp.MessageId] = true
+	}
+	assert.Equal(t, 40, len(ids))
+
+	assert.Nil(t, producer.Close(context.Background()))
+	_, err := producer.Send(MessageSendRequest{MessageBody: "late"})
+	assert.True(t, IsMNSError(err, ERR_MNS_PRODUCER_CLOSED))
+}
+
+func TestAsyncProducerLingerAndErrors(t *testing.T) {
+	emulator := NewMNSEmulator("", "")
+	producer := NewAsyncProducer(NewMNSQueue("no-queue", emulator), AsyncProducerConfig{Linger: 10 * time.Millisecond})
+	defer producer.Close(context.Background())
+
+	future, err := producer.Send(MessageSendRequest{MessageBody: "hello"})
+	assert.Nil(t, err)
+
+	select {
+	case <-future.Done():
+	case <-time.After(time.Second):
+		t.Fatal("linger did not flush the batch")
+	}
+	_, err = future.Wait()
+	assert.True(t, IsMNSError(err, ERR_MNS_QUEUE_NOT_EXIST))
+}
+
+func TestAsyncProducerBufferFull(t *testing.T) {
+	emulator := NewMNSEmulator("", "")
+	assert.Nil(t, NewMNSQueueManager(emulator).CreateSimpleQueue("test-queue"))
+	client := &gatedClient{MNSClient: emulator, gate: make(chan struct{})}
+
+	producer := NewAsyncProducer(NewMNSQueue("test-queue", client), AsyncProducerConfig{
+		Linger:     time.Millisecond,
+		BufferSize: 1,
+		OnFull:     BufferFullFail,
+	})
+
+	first, err := producer.Send(MessageSendRequest{MessageBody: "first"})
+	assert.Nil(t, err)
+	// let the first batch get stuck sending
+	time.Sleep(50 * time.Millisecond)
+
+	second, err := producer.Send(MessageSendRequest{MessageBody: "second"})
+	assert.Nil(t, err)
+	_, err = producer.Send(MessageSendRequest{MessageBody: "third"})
+	assert.True(t, IsMNSError(err, ERR_MNS_PRODUCER_BUFFER_FULL))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	assert.Equal(t, context.DeadlineExceeded, producer.Close(ctx))
+	cancel()
+
+	close(client.gate)
+	assert.Nil(t, producer.Close(context.Background()))
+
+	_, err = first.Wait()
+	assert.Nil(t, err)
+	_, err = second.Wait()
+	assert.Nil(t, err)
+}
+
+func TestAsyncProducerCloseWhileBlocked(t *testing.T) {
+	emulator := NewMNSEmulator("", "")
+	assert.Nil(t, NewMNSQueueManager(emulator).CreateSimpleQueue("test-queue"))
+	client := &gatedClient{MNSClient: emulator, gate: make(chan struct{})}
+
+	producer := NewAsyncProducer(NewMNSQueue("test-queue", client), AsyncProducerConfig{
+		Linger:     time.Millisecond,
+		BufferSize: 1,
+	})
+
+	_, err := producer.Send(MessageSendRequest{MessageBody: "first"})
+	assert.Nil(t, err)
+	time.Sleep(50 * time.Millisecond)
+	_, err = producer.Send(MessageSendRequest{MessageBody: "second"})
+	assert.Nil(t, err)
+
+	blocked := make(chan error, 1)
+	go func() {
+		_, err := producer.Send(MessageSendRequest{MessageBody: "third"})
+		blocked <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// Close honours its ctx and releases the blocked Send
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	assert.Equal(t, context.DeadlineExceeded, producer.Close(ctx))
+	cancel()
+	assert.True(t, IsMNSError(<-blocked, ERR_MNS_PRODUCER_CLOSED))
+
+	close(client.gate)
+	assert.Nil(t, producer.Close(context.Background()))
+	assert.Equal(t, 2, client.count())
+}
@@ -0,0 +1,159 @@
+package ali_mns
+
+import (
+	"time"
+
+	"github.com/gogap/errors"
+)
+
+const (
+	batchMaxMessages = 16
+	batchMaxBytes    = 64 * 1024
+
+	batchSendRetries       = 2
+	batchSendRetryInterval = 100 * time.Millisecond
+)
+
+// retryableBatchErrorCodes are the entry error codes worth sending again.
+var retryableBatchErrorCodes = map[string]bool{
+	"InternalError":    true,
+	"QpsLimitExceeded": true,
+}
+
+// batchChunks splits the messages at indexes into chunks within the count and
+// size limits of one batch request, a body counts with its XML escaping. A
+// message larger than the size limit is sent alone and left for the service
+// to reject.
+func batchChunks(messages []MessageSendRequest, indexes []int) (chunks [][]int) {
+	var chunk []int
+	size := 0
+	for _, index := range indexes {
+		bodySize := xmlEscapedLen(messages[index].MessageBody)
+		if len(chunk) > 0 && (len(chunk) == batchMaxMessages || size+bodySize > batchMaxBytes) {
+			chunks = append(chunks, chunk)
+			chunk, size = nil, 0
+		}
+		chunk = append(chunk, index)
+		size += bodySize
+	}
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+	return
+}
+
+// xmlEscapedLen returns the length of s once escaped by encoding/xml.
+func xmlEscapedLen(s string) int {
+	n := len(s)
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '&', '"', '\'':
+			n += 4
+		case '<', '>':
+			n += 3
+		case '\t', '\n', '\r':
+			n += 4
+		}
+	}
+	return n
+}
+
+// batchEntryError returns the error of a failed batch entry as the client
+// reports it for a single request, e.g. ERR_MNS_RECEIPT_HANDLE_ERROR.
+func batchEntryError(code, message, requestID string) error {
+	return ParseError(ErrorResponse{Code: code, Message: message, RequestId: requestID}, "")
+}
+
+// Err returns the error of the i-th message of the batch, nil if it was sent.
+func (r *BatchMessageSendResponse) Err(i int) error {
+	if i < 0 || i >= len(r.Messages) {
+		return ERR_MNS_BATCH_ENTRY_NOT_SENT.New(errors.Params{"index": i})
+	}
+
+	requestID := r.RequestID
+	if i < len(r.requestIDs) {
+		requestID = r.requestIDs[i]
+	}
+
+	entry := r.Messages[i]
+	switch {
+	case entry.ErrorCode != "":
+		return batchEntryError(entry.ErrorCode, entry.ErrorMessage, requestID)
+	case entry.MessageId == "" && r.requestErr != nil:
+		return r.requestErr
+	case entry.MessageId == "":
+		return ERR_MNS_BATCH_ENTRY_NOT_SENT.New(errors.Params{"index": i})
+	}
+	return nil
+}
+
+// Errors returns the error of every message of the batch by index.
+func (r *BatchMessageSendResponse) Errors() []error {
+	errs := make([]error, len(r.Messages))
+	for i := range r.Messages {
+		errs[i] = r.Err(i)
+	}
+	return errs
+}
+
+// Succeeded returns the indexes of the messages sent.
+func (r *BatchMessageSendResponse) Succeeded() (indexes []int) {
+	for i := range r.Messages {
+		if r.Err(i) == nil {
+			indexes = append(indexes, i)
+		}
+	}
+	return
+}
+
+// Failed returns the indexes of the messages not sent.
+func (r *BatchMessageSendResponse) Failed() (indexes []int) {
+	for i := range r.Messages {
+		if r.Err(i) != nil {
+			indexes = append(indexes, i)
+		}
+	}
+	return
+}
+
+// Err returns the error of deleting receiptHandle, nil if it was deleted. If
+// the whole request failed that is the error of every handle.
+func (r *BatchMessageDeleteErrorResponse) Err(receiptHandle string) error {
+	for _, entry := range r.FailedMessages {
+		if entry.ReceiptHandle == receiptHandle {
+			return batchEntryError(entry.ErrorCode, entry.ErrorMessage, r.RequestID)
+		}
+	}
+	return r.requestErr
+}
+
+// Errors returns the error of every handle in ReceiptHandles by index.
+func (r *BatchMessageDeleteErrorResponse) Errors() []error {
+	errs := make([]error, len(r.ReceiptHandles))
+	for i, receiptHandle := range r.ReceiptHandles {
+		errs[i] = r.Err(receiptHandle)
+	}
+	return errs
+}
+
+// Succeeded returns the receipt handles deleted.
+func (r *BatchMessageDeleteErrorResponse) Succeeded() (receiptHandles []string) {
+	for _, receiptHandle := range r.ReceiptHandles {
+		if r.Err(receiptHandle) == nil {
+			receiptHandles = append(receiptHandles, receiptHandle)
+		}
+	}
+	return
+}
+
+// Failed returns the receipt handles not deleted, including the ones the
+// service reported which were not in ReceiptHandles.
+func (r *BatchMessageDeleteErrorResponse) Failed() (receiptHandles []string) {
+	if r.requestErr != nil {
+		return append(receiptHandles, r.ReceiptHandles...)
+	}
+	for _, entry := range r.FailedMessages {
+		receiptHandles = append(receiptHandles, entry.ReceiptHandle)
+	}
+	return
+}
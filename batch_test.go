@@ -0,0 +1,220 @@
+package ali_mns
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/valyala/fasthttp"
+)
+
+func TestBatchChunks(t *testing.T) {
+	messages := make([]MessageSendRequest, 20)
+	messages[3].MessageBody = strings.Repeat("a", 40*1024)
+	messages[4].MessageBody = strings.Repeat("b", 40*1024)
+	messages[5].MessageBody = strings.Repeat("c", 70*1024)
+
+	indexes := make([]int, len(messages))
+	for i := range indexes {
+		indexes[i] = i
+	}
+
+	assert.Equal(t, [][]int{
+		{0, 1, 2, 3},
+		{4},
+		{5},
+		{6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19},
+	}, batchChunks(messages, indexes))
+	assert.Equal(t, [][]int{{1, 19}}, batchChunks(messages, []int{1, 19}))
+
+	// 20KB bodies of "<" take 80KB escaped
+	messages[0].MessageBody = strings.Repeat("<", 20*1024)
+	messages[1].MessageBody = strings.Repeat("<", 20*1024)
+	assert.Equal(t, [][]int{{0}, {1}}, batchChunks(messages, []int{0, 1}))
+}
+
+func TestXMLEscapedLen(t *testing.T) {
+	for _, body := range []string{"", "hello", `a&b<c>d"e'f`, "tab\tline\nreturn\r", "中文"} {
+		escaped := bytes.Buffer{}
+		assert.Nil(t, xml.EscapeText(&escaped, []byte(body)))
+		assert.Equal(t, escaped.Len(), xmlEscapedLen(body), body)
+	}
+}
+
+func TestBatchSendMessageChunking(t *testing.T) {
+	emulator := NewMNSEmulator("", "")
+	assert.Nil(t, NewMNSQueueManager(emulator).CreateSimpleQueue("test-queue"))
+	queue := NewMNSQueue("test-queue", emulator)
+
+	var messages []MessageSendRequest
+	for i := 0; i < 40; i++ {
+		body := fmt.Sprintf("message-%d", i)
+		if i%10 == 0 {
+			body += strings.Repeat("x", 30*1024)
+		}
+		messages = append(messages, MessageSendRequest{MessageBody: body})
+	}
+
+	resp, err := queue.BatchSendMessage(messages...)
+	assert.Nil(t, err)
+	assert.Equal(t, len(messages), len(resp.Messages))
+	for i, entry := range resp.Messages {
+		assert.Equal(t, fmt.Sprintf("%X", md5.Sum([]byte(messages[i].MessageBody))), entry.MessageBodyMD5)
+	}
+
+	attr, err := NewMNSQueueManager(emulator).GetQueueAttributes("test-queue")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(40), attr.ActiveMessages)
+}
+
+func batchResponse(statusCode int, body string) *fasthttp.Response {
+	resp := &fasthttp.Response{}
+	resp.SetStatusCode(statusCode)
+	resp.SetBody([]byte(body))
+	return resp
+}
+
+func TestBatchSendMessageRetry(t *testing.T) {
+	mMNSClient := &mockMNSClient{}
+	mMNSClient.On("Send", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(batchResponse(500, `<?xml version="1.0" encoding="UTF-8"?>
+<Messages xmlns="http://mns.aliyuncs.com/doc/v1/">
+  <Message><MessageId>id-0</MessageId><MessageBodyMD5>md5-0</MessageBodyMD5></Message>
+  <Message><ErrorCode>InternalError</ErrorCode><ErrorMessage>retry me</ErrorMessage></Message>
+  <Message><ErrorCode>InvalidArgument</ErrorCode><ErrorMessage>bad message</ErrorMessage></Message>
+</Messages>`), nil).Once()
+	mMNSClient.On("Send", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(batchResponse(201, `<?xml version="1.0" encoding="UTF-8"?>
+<Messages xmlns="http://mns.aliyuncs.com/doc/v1/">
+  <Message><MessageId>id-1</MessageId><MessageBodyMD5>md5-1</MessageBodyMD5></Message>
+</Messages>`), nil).Once()
+
+	queue := NewMNSQueue("test-queue", mMNSClient)
+	resp, err := queue.BatchSendMessage(
+		MessageSendRequest{MessageBody: "0"},
+		MessageSendRequest{MessageBody: "1"},
+		MessageSendRequest{MessageBody: "2"},
+	)
+	assert.True(t, IsMNSError(err, ERR_MNS_BATCH_OP_FAIL))
+	assert.Equal(t, "id-0", resp.Messages[0].MessageId)
+	assert.Equal(t, "id-1", resp.Messages[1].MessageId)
+	assert.Equal(t, "", resp.Messages[1].ErrorCode)
+	assert.Equal(t, "InvalidArgument", resp.Messages[2].ErrorCode)
+
+	mMNSClient.AssertNumberOfCalls(t, "Send", 2)
+	retried := mMNSClient.Calls[1].Arguments.Get(2).(BatchMessageSendRequest)
+	assert.Equal(t, []MessageSendRequest{{MessageBody: "1"}}, retried.Messages)
+}
+
+// partial failure samples as returned by the service
+const (
+	batchSendPartialFailureXML = `<?xml version="1.0" encoding="UTF-8"?>
+<Messages xmlns="http://mns.aliyuncs.com/doc/v1/">
+  <Message>
+    <ErrorCode>MalformedXML</ErrorCode>
+    <ErrorMessage>The XML you provided was not well-formed.</ErrorMessage>
+  </Message>
+  <Message>
+    <MessageId>5F290C926D472878-2-14D9529A8FA-200000001</MessageId>
+    <MessageBodyMD5>C5DD56A39F5F7BB8B3337C6D11B6D8C7</MessageBodyMD5>
+  </Message>
+</Messages>`
+
+	batchDeletePartialFailureXML = `<?xml version="1.0" encoding="UTF-8"?>
+<Errors xmlns="http://mns.aliyuncs.com/doc/v1/">
+  <Error>
+    <ErrorCode>ReceiptHandleError</ErrorCode>
+    <ErrorMessage>The receipt handle you provide is not valid.</ErrorMessage>
+    <ReceiptHandle>1-ODU4OTkzNDU5My0xNDM1MTk3NjAwLTItNg==</ReceiptHandle>
+  </Error>
+</Errors>`
+
+	queueNotExistXML = `<?xml version="1.0" encoding="UTF-8"?>
+<Error xmlns="http://mns.aliyuncs.com/doc/v1/">
+  <Code>QueueNotExist</Code>
+  <Message>The queue name you provided is not exist.</Message>
+  <RequestId>5F290C926D472878-2-14D9529A8FA-200000001</RequestId>
+  <HostId>http://1234567890.mns.cn-hangzhou.aliyuncs.com</HostId>
+</Error>`
+)
+
+func TestBatchOpDecoderDecodeError(t *testing.T) {
+	resp := BatchMessageSendResponse{}
+	decodedError, err := NewBatchOpDecoder(&resp).DecodeError([]byte(batchSendPartialFailureXML), "queues/q/messages")
+	assert.Nil(t, err)
+	assert.True(t, IsMNSError(decodedError, ERR_MNS_BATCH_OP_FAIL))
+	assert.Equal(t, 2, len(resp.Messages))
+
+	decodedError, err = NewBatchOpDecoder(&BatchMessageSendResponse{}).DecodeError([]byte(queueNotExistXML), "queues/q/messages")
+	assert.Nil(t, err)
+	assert.True(t, IsMNSError(decodedError, ERR_MNS_QUEUE_NOT_EXIST))
+
+	decodedError, err = NewBatchOpDecoderErrResp(&BatchMessageDeleteErrorResponse{}).DecodeError([]byte(queueNotExistXML), "queues/q/messages")
+	assert.Nil(t, err)
+	assert.Equal(t, "QueueNotExist", decodedError.(ErrorResponse).Code)
+}
+
+func TestBatchSendMessagePartialFailure(t *testing.T) {
+	mMNSClient := &mockMNSClient{}
+	mMNSClient.On("Send", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(batchResponse(500, batchSendPartialFailureXML), nil)
+
+	resp, err := NewMNSQueue("q", mMNSClient).BatchSendMessage(MessageSendRequest{MessageBody: "bad"}, MessageSendRequest{MessageBody: "good"})
+	assert.True(t, IsMNSError(err, ERR_MNS_BATCH_OP_FAIL))
+	mMNSClient.AssertNumberOfCalls(t, "Send", 1)
+
+	assert.True(t, IsMNSError(resp.Err(0), ERR_MNS_MALFORMED_XML))
+	assert.Nil(t, resp.Err(1))
+	assert.True(t, IsMNSError(resp.Err(2), ERR_MNS_BATCH_ENTRY_NOT_SENT))
+	assert.Equal(t, []int{1}, resp.Succeeded())
+	assert.Equal(t, []int{0}, resp.Failed())
+	assert.Equal(t, 2, len(resp.Errors()))
+}
+
+func TestBatchSendMessageRequestIDs(t *testing.T) {
+	failure := `<?xml version="1.0" encoding="UTF-8"?>
+<Messages xmlns="http://mns.aliyuncs.com/doc/v1/">
+  <Message><ErrorCode>InvalidArgument</ErrorCode><ErrorMessage>bad message</ErrorMessage></Message>
+` + strings.Repeat("  <Message><MessageId>id</MessageId><MessageBodyMD5>md5</MessageBodyMD5></Message>\n", 15) + `</Messages>`
+	success := batchResponse(201, `<?xml version="1.0" encoding="UTF-8"?>
+<Messages xmlns="http://mns.aliyuncs.com/doc/v1/">
+  <Message><MessageId>id-16</MessageId><MessageBodyMD5>md5-16</MessageBodyMD5></Message>
+</Messages>`)
+	success.Header.Set(headerKeyRequestID, "request-2")
+
+	mMNSClient := &mockMNSClient{}
+	mMNSClient.On("Send", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(batchResponse(500, failure), nil).Once()
+	mMNSClient.On("Send", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(success, nil).Once()
+
+	resp, err := NewMNSQueue("q", mMNSClient).BatchSendMessage(make([]MessageSendRequest, 17)...)
+	assert.True(t, IsMNSError(err, ERR_MNS_BATCH_OP_FAIL))
+	assert.Equal(t, "request-2", resp.RequestID)
+	assert.Nil(t, resp.Err(16))
+
+	// the failed entry was sent in the first request, not the last
+	assert.True(t, IsMNSError(resp.Err(0), ERR_MNS_INVALID_ARGUMENT))
+	assert.False(t, strings.Contains(resp.Err(0).Error(), "request-2"))
+}
+
+func TestBatchDeleteMessagePartialFailure(t *testing.T) {
+	mMNSClient := &mockMNSClient{}
+	mMNSClient.On("Send", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(batchResponse(404, batchDeletePartialFailureXML), nil).Once()
+	mMNSClient.On("Send", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(batchResponse(404, queueNotExistXML), nil).Once()
+	queue := NewMNSQueue("q", mMNSClient)
+
+	expired := "1-ODU4OTkzNDU5My0xNDM1MTk3NjAwLTItNg=="
+	resp, err := queue.BatchDeleteMessage("valid", expired)
+	assert.True(t, IsMNSError(err, ERR_MNS_BATCH_OP_FAIL))
+	assert.Nil(t, resp.Err("valid"))
+	assert.True(t, IsMNSError(resp.Err(expired), ERR_MNS_RECEIPT_HANDLE_ERROR))
+	assert.Equal(t, []string{"valid"}, resp.Succeeded())
+	assert.Equal(t, []string{expired}, resp.Failed())
+
+	resp, err = queue.BatchDeleteMessage("a", "b")
+	assert.True(t, IsMNSError(err, ERR_MNS_QUEUE_NOT_EXIST))
+	assert.True(t, IsMNSError(resp.Err("a"), ERR_MNS_QUEUE_NOT_EXIST))
+	assert.Empty(t, resp.Succeeded())
+	assert.Equal(t, []string{"a", "b"}, resp.Failed())
+}
@@ -0,0 +1,266 @@
+package ali_mns
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogap/errors"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	defaultCircuitWindowSize         = 20
+	defaultCircuitMinRequests        = 10
+	defaultCircuitErrorRateThreshold = 0.5
+	defaultCircuitOpenTimeout        = 10 * time.Second
+	defaultCircuitHalfOpenProbes     = 1
+)
+
+type CircuitState int32
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitBreakerConfig configures when a CircuitBreaker trips and how it recovers.
+//
+// A call counts as failed when it returns a transport error, a 5xx status, or,
+// if LatencyThreshold is set, takes longer than it. Long polling receives
+// (with waitseconds) are never counted as slow.
+type CircuitBreakerConfig struct {
+	WindowSize         int
+	MinRequests        int
+	ErrorRateThreshold float64
+	LatencyThreshold   time.Duration
+	OpenTimeout        time.Duration
+	HalfOpenProbes     int
+
+	OnStateChange func(from, to CircuitState)
+}
+
+func (p CircuitBreakerConfig) normalize() CircuitBreakerConfig {
+	if p.WindowSize <= 0 {
+		p.WindowSize = defaultCircuitWindowSize
+	}
+	if p.MinRequests <= 0 {
+		p.MinRequests = defaultCircuitMinRequests
+	}
+	if p.MinRequests > p.WindowSize {
+		p.MinRequests = p.WindowSize
+	}
+	if p.ErrorRateThreshold <= 0 || p.ErrorRateThreshold > 1 {
+		p.ErrorRateThreshold = defaultCircuitErrorRateThreshold
+	}
+	if p.OpenTimeout <= 0 {
+		p.OpenTimeout = defaultCircuitOpenTimeout
+	}
+	if p.HalfOpenProbes <= 0 {
+		p.HalfOpenProbes = defaultCircuitHalfOpenProbes
+	}
+	return p
+}
+
+type CircuitBreaker struct {
+	config CircuitBreakerConfig
+
+	locker     sync.Mutex
+	state      CircuitState
+	generation uint64
+	openedAt   time.Time
+
+	outcomes []bool
+	next     int
+	count    int
+	failures int
+
+	probing   int
+	successes int
+}
+
+func NewCircuitBreaker(config CircuitBreakerConfig) *CircuitBreaker {
+	config = config.normalize()
+	return &CircuitBreaker{
+		config:   config,
+		state:    CircuitClosed,
+		outcomes: make([]bool, config.WindowSize),
+	}
+}
+
+func (p *CircuitBreaker) State() CircuitState {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if p.state == CircuitOpen && time.Since(p.openedAt) >= p.config.OpenTimeout {
+		return CircuitHalfOpen
+	}
+	return p.state
+}
+
+// allow reserves a slot for a call, it returns ERR_MNS_CIRCUIT_BREAKER_OPEN
+// while the circuit is open or all half-open probes are in flight. The
+// generation returned must be passed to done.
+func (p *CircuitBreaker) allow(resource string) (generation uint64, err error) {
+	p.locker.Lock()
+	from := p.state
+
+	if p.state == CircuitOpen && time.Since(p.openedAt) >= p.config.OpenTimeout {
+		p.setState(CircuitHalfOpen)
+	}
+
+	switch p.state {
+	case CircuitOpen:
+		err = ERR_MNS_CIRCUIT_BREAKER_OPEN.New(errors.Params{"resource": resource, "state": p.state})
+	case CircuitHalfOpen:
+		if p.probing >= p.config.HalfOpenProbes {
+			err = ERR_MNS_CIRCUIT_BREAKER_OPEN.New(errors.Params{"resource": resource, "state": p.state})
+		} else {
+			p.probing++
+		}
+	}
+
+	generation = p.generation
+	to := p.state
+	p.locker.Unlock()
+
+	p.notify(from, to)
+	return
+}
+
+// done records the outcome of a call admitted by allow. Calls admitted
+// before the last state change are ignored, so a slow call started while
+// closed is not taken for a half-open probe.
+func (p *CircuitBreaker) done(generation uint64, failed bool) {
+	p.locker.Lock()
+	from := p.state
+
+	if generation != p.generation {
+		p.locker.Unlock()
+		return
+	}
+
+	switch p.state {
+	case CircuitClosed:
+		p.record(failed)
+		if p.count >= p.config.MinRequests &&
+			float64(p.failures)/float64(p.count) >= p.config.ErrorRateThreshold {
+			p.trip()
+		}
+	case CircuitHalfOpen:
+		if p.probing > 0 {
+			p.probing--
+		}
+		if failed {
+			p.trip()
+		} else {
+			p.successes++
+			if p.successes >= p.config.HalfOpenProbes {
+				p.reset()
+			}
+		}
+	}
+
+	to := p.state
+	p.locker.Unlock()
+
+	p.notify(from, to)
+}
+
+func (p *CircuitBreaker) record(failed bool) {
+	if p.count == len(p.outcomes) {
+		if p.outcomes[p.next] {
+			p.failures--
+		}
+	} else {
+		p.count++
+	}
+
+	p.outcomes[p.next] = failed
+	if failed {
+		p.failures++
+	}
+	p.next = (p.next + 1) % len(p.outcomes)
+}
+
+func (p *CircuitBreaker) trip() {
+	p.setState(CircuitOpen)
+	p.openedAt = time.Now()
+}
+
+func (p *CircuitBreaker) reset() {
+	p.setState(CircuitClosed)
+	p.next, p.count, p.failures = 0, 0, 0
+}
+
+func (p *CircuitBreaker) setState(state CircuitState) {
+	p.state = state
+	p.generation++
+	p.probing = 0
+	p.successes = 0
+}
+
+func (p *CircuitBreaker) notify(from, to CircuitState) {
+	if from != to && p.config.OnStateChange != nil {
+		p.config.OnStateChange(from, to)
+	}
+}
+
+func (p *CircuitBreaker) isFailure(resource string, resp *fasthttp.Response, err error, latency time.Duration) bool {
+	if err != nil {
+		return true
+	}
+	if resp != nil && resp.Header.StatusCode() >= fasthttp.StatusInternalServerError {
+		return true
+	}
+	if p.config.LatencyThreshold > 0 && latency > p.config.LatencyThreshold &&
+		!strings.Contains(resource, "waitseconds=") {
+		return true
+	}
+	return false
+}
+
+type circuitBreakerClient struct {
+	MNSClient
+	breaker *CircuitBreaker
+}
+
+// NewCircuitBreakerClient guards every request sent through client with breaker,
+// so all queues, topics and managers created from the returned client fail fast
+// while the circuit is open. One breaker may be shared by several clients.
+func NewCircuitBreakerClient(client MNSClient, breaker *CircuitBreaker) MNSClient {
+	return &circuitBreakerClient{MNSClient: client, breaker: breaker}
+}
+
+func (p *circuitBreakerClient) Send(method Method, headers map[string]string, message interface{}, resource string, opts ...Option) (*fasthttp.Response, error) {
+	generation, err := p.breaker.allow(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	resp, err := p.MNSClient.Send(method, headers, message, resource, opts...)
+	p.breaker.done(generation, p.breaker.isFailure(resource, resp, err, time.Since(start)))
+
+	return resp, err
+}
+
+func (p *circuitBreakerClient) getAdaptiveQPSConfig() *AdaptiveQPSConfig {
+	if getter, ok := p.MNSClient.(adaptiveQPSConfigGetter); ok {
+		return getter.getAdaptiveQPSConfig()
+	}
+	return nil
+}
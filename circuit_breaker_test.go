@@ -0,0 +1,89 @@
+package ali_mns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gogap/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/valyala/fasthttp"
+)
+
+func TestCircuitBreakerClient(t *testing.T) {
+	mMNSClient := &mockMNSClient{}
+	fresp := &fasthttp.Response{}
+	fresp.SetStatusCode(500)
+	fresp.SetBody([]byte(`<Error xmlns="http://mns.aliyuncs.com/doc/v1"><Code>InternalError</Code><Message>test message</Message><RequestId>test-request-id</RequestId><HostId>http://{aid}.mns.cn-shanghai.aliyuncs.com</HostId></Error>`))
+	call := mMNSClient.On("Send", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(fresp, nil)
+
+	var transitions []CircuitState
+	breaker := NewCircuitBreaker(CircuitBreakerConfig{
+		WindowSize:  4,
+		MinRequests: 4,
+		OpenTimeout: 20 * time.Millisecond,
+		OnStateChange: func(from, to CircuitState) {
+			transitions = append(transitions, to)
+		},
+	})
+	mnsQueue := NewMNSQueue("test-name", NewCircuitBreakerClient(mMNSClient, breaker))
+
+	for i := 0; i < 4; i++ {
+		err := mnsQueue.DeleteMessage("rh")
+		assert.True(t, IsMNSError(err, ERR_MNS_INTERNAL_ERROR))
+	}
+	assert.Equal(t, CircuitOpen, breaker.State())
+
+	err := mnsQueue.DeleteMessage("rh")
+	assert.True(t, IsMNSError(err, ERR_MNS_CIRCUIT_BREAKER_OPEN))
+	mMNSClient.AssertNumberOfCalls(t, "Send", 4)
+
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, CircuitHalfOpen, breaker.State())
+
+	okResp := &fasthttp.Response{}
+	okResp.SetStatusCode(204)
+	call.Return(okResp, nil)
+
+	assert.Nil(t, mnsQueue.DeleteMessage("rh"))
+	assert.Equal(t, CircuitClosed, breaker.State())
+	assert.Equal(t, []CircuitState{CircuitOpen, CircuitHalfOpen, CircuitClosed}, transitions)
+}
+
+func TestCircuitBreakerHalfOpenFailure(t *testing.T) {
+	breaker := NewCircuitBreaker(CircuitBreakerConfig{
+		WindowSize:         10,
+		MinRequests:        2,
+		ErrorRateThreshold: 0.5,
+		LatencyThreshold:   time.Millisecond,
+		OpenTimeout:        10 * time.Millisecond,
+	})
+
+	assert.False(t, breaker.isFailure("queues/q/messages?waitseconds=10", &fasthttp.Response{}, nil, time.Second))
+	assert.True(t, breaker.isFailure("queues/q/messages", &fasthttp.Response{}, nil, time.Second))
+	assert.True(t, breaker.isFailure("queues/q/messages", nil, ERR_SEND_REQUEST_FAILED.New(errors.Params{"err": "reset"}), 0))
+
+	closed, err := breaker.allow("queues/q")
+	assert.Nil(t, err)
+	slow, err := breaker.allow("queues/q")
+	assert.Nil(t, err)
+	breaker.done(closed, false)
+	breaker.done(closed, true)
+	assert.Equal(t, CircuitOpen, breaker.State())
+
+	time.Sleep(15 * time.Millisecond)
+	probe, err := breaker.allow("queues/q")
+	assert.Nil(t, err)
+	// only one probe is let through while half-open
+	_, err = breaker.allow("queues/q")
+	assert.NotNil(t, err)
+
+	// a call admitted while closed is not taken for the probe
+	breaker.done(slow, false)
+	assert.Equal(t, CircuitHalfOpen, breaker.State())
+
+	breaker.done(probe, true)
+	assert.Equal(t, CircuitOpen, breaker.State())
+	_, err = breaker.allow("queues/q")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,484 @@
+package ali_mns
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogap/errors"
+)
+
+// DefaultClaimCheckThreshold is the default MaxMessageSize of CreateSimpleQueue.
+const DefaultClaimCheckThreshold = 64 * 1024
+
+// an offloaded body is replaced by an envelope with the key of its blob and
+// the claimCheckEnvelope in JSON, e.g. "mns:cc=9f86d0...;{"size":...}"
+const claimCheckEnvelopeName = "cc"
+
+// BlobStore keeps the bodies offloaded by a claim-check queue or topic.
+// Get returns ERR_MNS_BLOB_NOT_FOUND for unknown keys and Delete of an unknown
+// key succeeds.
+type BlobStore interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+	Delete(key string) error
+}
+
+// FileBlobStore is a BlobStore keeping one file per blob in a directory, to
+// be shared by producers and consumers it must be on a shared filesystem.
+type FileBlobStore struct {
+	dir    string
+	maxAge time.Duration
+
+	locker    sync.Mutex
+	lastSweep time.Time
+}
+
+func NewFileBlobStore(dir string) (*FileBlobStore, error) {
+	return NewFileBlobStoreWithMaxAge(dir, 0)
+}
+
+// NewFileBlobStoreWithMaxAge creates a FileBlobStore whose blobs expire maxAge
+// after they were put, zero never expires them. Expired blobs are not found
+// by Get, and Put removes them at most once every maxAge; Sweep removes them
+// at once.
+func NewFileBlobStoreWithMaxAge(dir string, maxAge time.Duration) (*FileBlobStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileBlobStore{dir: dir, maxAge: maxAge}, nil
+}
+
+func (p *FileBlobStore) Put(key string, data []byte) error {
+	path, err := p.path(key)
+	if err != nil {
+		return err
+	}
+
+	if p.maxAge > 0 {
+		p.locker.Lock()
+		sweep := time.Since(p.lastSweep) >= p.maxAge
+		if sweep {
+			p.lastSweep = time.Now()
+		}
+		p.locker.Unlock()
+		if sweep {
+			p.Sweep()
+		}
+	}
+
+	// write aside and rename so readers never see a partial blob
+	file, err := ioutil.TempFile(p.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(data); err == nil {
+		err = file.Close()
+	} else {
+		file.Close()
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+func (p *FileBlobStore) Get(key string) ([]byte, error) {
+	path, err := p.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.maxAge > 0 {
+		if info, e := os.Stat(path); e == nil && p.expired(info) {
+			return nil, ERR_MNS_BLOB_NOT_FOUND.New(errors.Params{"key": key})
+		}
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ERR_MNS_BLOB_NOT_FOUND.New(errors.Params{"key": key})
+	}
+	return data, err
+}
+
+func (p *FileBlobStore) Delete(key string) error {
+	path, err := p.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err = os.Remove(path); os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Sweep removes the blobs older than the max age of the store, and the
+// partial ones left by an interrupted Put, it returns how many were removed.
+func (p *FileBlobStore) Sweep() (removed int, err error) {
+	if p.maxAge <= 0 {
+		return
+	}
+
+	files, err := ioutil.ReadDir(p.dir)
+	if err != nil {
+		return
+	}
+	for _, info := range files {
+		if info.IsDir() || !p.expired(info) {
+			continue
+		}
+		if e := os.Remove(filepath.Join(p.dir, info.Name())); e == nil {
+			removed++
+		} else if !os.IsNotExist(e) && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+func (p *FileBlobStore) expired(info os.FileInfo) bool {
+	return time.Since(info.ModTime()) > p.maxAge
+}
+
+// path rejects keys escaping the directory, keys come from message bodies.
+func (p *FileBlobStore) path(key string) (string, error) {
+	if key == "" || key == "." || key == ".." || strings.ContainsAny(key, `/\`) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(p.dir, key), nil
+}
+
+// ClaimCheckConfig configures a claim-check queue or topic, bodies longer
+// than Threshold bytes are offloaded to Store.
+type ClaimCheckConfig struct {
+	Store     BlobStore
+	Threshold int
+}
+
+func (p ClaimCheckConfig) normalize() ClaimCheckConfig {
+	if p.Store == nil {
+		panic("ali_mns: claim check store could not be nil")
+	}
+	if p.Threshold <= 0 {
+		p.Threshold = DefaultClaimCheckThreshold
+	}
+	return p
+}
+
+// claimCheckEnvelope replaces an offloaded body, Keep marks blobs published
+// to a topic, which may reach several queues and are never deleted by
+// consumers.
+type claimCheckEnvelope struct {
+	Key  string `json:"-"`
+	Size int    `json:"size"`
+	MD5  string `json:"md5"`
+	Keep bool   `json:"keep,omitempty"`
+}
+
+type claimChecker struct {
+	config ClaimCheckConfig
+}
+
+// offload moves an oversized body to the store and returns its key, or ""
+// if body is small enough to be sent as is.
+func (p claimChecker) offload(body *string, keep bool) (key string, err error) {
+	if len(*body) <= p.config.Threshold {
+		*body = escapeEnvelope(*body, claimCheckEnvelopeName)
+		return "", nil
+	}
+
+	id := make([]byte, 16)
+	if _, err = rand.Read(id); err != nil {
+		return "", err
+	}
+	key = hex.EncodeToString(id)
+
+	if err = p.config.Store.Put(key, []byte(*body)); err != nil {
+		return "", ERR_MNS_CLAIM_CHECK_FAILED.New(errors.Params{"key": key, "err": err})
+	}
+
+	envelope, _ := json.Marshal(claimCheckEnvelope{
+		Size: len(*body),
+		MD5:  fmt.Sprintf("%X", md5.Sum([]byte(*body))),
+		Keep: keep,
+	})
+	*body = sealEnvelope(claimCheckEnvelopeName, key, string(envelope))
+	return key, nil
+}
+
+// resolve replaces an envelope by the body it refers to and returns the
+// envelope, or nil if body is not one.
+func (p claimChecker) resolve(body *string) (*claimCheckEnvelope, error) {
+	key, payload, ok := openEnvelope(*body, claimCheckEnvelopeName)
+	if !ok {
+		return nil, nil
+	}
+	if key == "" {
+		*body = payload
+		return nil, nil
+	}
+
+	envelope := &claimCheckEnvelope{Key: key}
+	if err := json.Unmarshal([]byte(payload), envelope); err != nil {
+		return nil, ERR_MNS_CLAIM_CHECK_FAILED.New(errors.Params{"key": key, "err": err})
+	}
+
+	data, err := p.config.Store.Get(envelope.Key)
+	if err != nil {
+		return nil, ERR_MNS_CLAIM_CHECK_FAILED.New(errors.Params{"key": envelope.Key, "err": err})
+	}
+	if len(data) != envelope.Size || fmt.Sprintf("%X", md5.Sum(data)) != envelope.MD5 {
+		return nil, ERR_MNS_CLAIM_CHECK_FAILED.New(errors.Params{"key": envelope.Key, "err": "blob does not match its envelope"})
+	}
+
+	*body = string(data)
+	return envelope, nil
+}
+
+func (p claimChecker) deleteBlob(key string) error {
+	if err := p.config.Store.Delete(key); err != nil {
+		return ERR_MNS_CLAIM_CHECK_FAILED.New(errors.Params{"key": key, "err": err})
+	}
+	return nil
+}
+
+type claimCheckBlob struct {
+	key             string
+	nextVisibleTime int64
+}
+
+type claimCheckQueue struct {
+	AliMNSQueue
+	claimChecker
+
+	locker sync.Mutex
+	// blobs of the received messages by receipt handle
+	blobs map[string]claimCheckBlob
+}
+
+// NewClaimCheckQueue wraps queue to offload bodies longer than
+// config.Threshold to config.Store, sending a small envelope instead. Received
+// envelopes are resolved back to their bodies, and the blob is deleted once
+// the message is deleted through the same wrapper that received it.
+func NewClaimCheckQueue(queue AliMNSQueue, config ClaimCheckConfig) AliMNSQueue {
+	return &claimCheckQueue{
+		AliMNSQueue:  queue,
+		claimChecker: claimChecker{config: config.normalize()},
+		blobs:        map[string]claimCheckBlob{},
+	}
+}
+
+func (p *claimCheckQueue) SendMessage(message MessageSendRequest, opts ...Option) (resp MessageSendResponse, err error) {
+	key, err := p.offload(&message.MessageBody, false)
+	if err != nil {
+		return
+	}
+
+	if resp, err = p.AliMNSQueue.SendMessage(message, opts...); err != nil && key != "" {
+		p.config.Store.Delete(key)
+	}
+	return
+}
+
+// BatchSendMessage offloads the oversized messages and sends them all, the
+// blobs of the entries that failed are deleted.
+func (p *claimCheckQueue) BatchSendMessage(messages ...MessageSendRequest) (resp BatchMessageSendResponse, err error) {
+	offloaded := make([]MessageSendRequest, len(messages))
+	keys := make([]string, len(messages))
+	for i, message := range messages {
+		if keys[i], err = p.offload(&message.MessageBody, false); err != nil {
+			for _, key := range keys[:i] {
+				if key != "" {
+					p.config.Store.Delete(key)
+				}
+			}
+			return
+		}
+		offloaded[i] = message
+	}
+
+	resp, err = p.AliMNSQueue.BatchSendMessage(offloaded...)
+	if err != nil {
+		for i, key := range keys {
+			if key != "" && resp.Err(i) != nil {
+				p.config.Store.Delete(key)
+			}
+		}
+	}
+	return
+}
+
+func (p *claimCheckQueue) ReceiveMessage(respChan chan MessageReceiveResponse, errChan chan error, waitseconds ...int64) {
+	relayReceive(func(innerChan chan MessageReceiveResponse) {
+		p.AliMNSQueue.ReceiveMessage(innerChan, errChan, waitseconds...)
+	}, respChan, errChan, func(message *MessageReceiveResponse) error {
+		return p.resolveMessage(message, true)
+	})
+}
+
+func (p *claimCheckQueue) PeekMessage(respChan chan MessageReceiveResponse, errChan chan error) {
+	relayReceive(func(innerChan chan MessageReceiveResponse) {
+		p.AliMNSQueue.PeekMessage(innerChan, errChan)
+	}, respChan, errChan, func(message *MessageReceiveResponse) error {
+		return p.resolveMessage(message, false)
+	})
+}
+
+// BatchReceiveMessage resolves every envelope of a batch, if one fails the
+// batch is answered with the error and its messages become visible again
+// after their visibility timeout.
+func (p *claimCheckQueue) BatchReceiveMessage(respChan chan BatchMessageReceiveResponse, errChan chan error, numOfMessages int32, waitseconds ...int64) {
+	relayBatchReceive(func(innerChan chan BatchMessageReceiveResponse) {
+		p.AliMNSQueue.BatchReceiveMessage(innerChan, errChan, numOfMessages, waitseconds...)
+	}, respChan, errChan, func(batch *BatchMessageReceiveResponse) error {
+		return p.resolveBatch(batch, true)
+	})
+}
+
+func (p *claimCheckQueue) BatchPeekMessage(respChan chan BatchMessageReceiveResponse, errChan chan error, numOfMessages int32) {
+	relayBatchReceive(func(innerChan chan BatchMessageReceiveResponse) {
+		p.AliMNSQueue.BatchPeekMessage(innerChan, errChan, numOfMessages)
+	}, respChan, errChan, func(batch *BatchMessageReceiveResponse) error {
+		return p.resolveBatch(batch, false)
+	})
+}
+
+// DeleteMessage deletes the message and then its blob, if deleting the blob
+// fails the message is gone anyway and err is ERR_MNS_CLAIM_CHECK_FAILED.
+func (p *claimCheckQueue) DeleteMessage(receiptHandle string) (err error) {
+	if err = p.AliMNSQueue.DeleteMessage(receiptHandle); err != nil {
+		return
+	}
+	return p.release(receiptHandle)
+}
+
+// BatchDeleteMessage deletes the blobs of the messages deleted, if the batch
+// succeeded but a blob could not be deleted err is ERR_MNS_CLAIM_CHECK_FAILED.
+func (p *claimCheckQueue) BatchDeleteMessage(receiptHandles ...string) (resp BatchMessageDeleteErrorResponse, err error) {
+	resp, err = p.AliMNSQueue.BatchDeleteMessage(receiptHandles...)
+	for _, receiptHandle := range resp.Succeeded() {
+		if e := p.release(receiptHandle); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+func (p *claimCheckQueue) ChangeMessageVisibility(receiptHandle string, visibilityTimeout int64) (resp MessageVisibilityChangeResponse, err error) {
+	if resp, err = p.AliMNSQueue.ChangeMessageVisibility(receiptHandle, visibilityTimeout); err != nil {
+		return
+	}
+
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if blob, ok := p.blobs[receiptHandle]; ok {
+		delete(p.blobs, receiptHandle)
+		blob.nextVisibleTime = resp.NextVisibleTime
+		p.blobs[resp.ReceiptHandle] = blob
+	}
+	return
+}
+
+func (p *claimCheckQueue) resolveMessage(message *MessageReceiveResponse, track bool) error {
+	envelope, err := p.resolve(&message.MessageBody)
+	if err != nil {
+		return err
+	}
+	if track {
+		p.track(*message, envelope)
+	}
+	return nil
+}
+
+func (p *claimCheckQueue) resolveBatch(batch *BatchMessageReceiveResponse, track bool) error {
+	envelopes := make([]*claimCheckEnvelope, len(batch.Messages))
+	for i := range batch.Messages {
+		envelope, err := p.resolve(&batch.Messages[i].MessageBody)
+		if err != nil {
+			return err
+		}
+		envelopes[i] = envelope
+	}
+
+	if track {
+		for i, message := range batch.Messages {
+			p.track(message, envelopes[i])
+		}
+	}
+	return nil
+}
+
+// track remembers the blob of a received message until it is deleted or its
+// receipt handle expires.
+func (p *claimCheckQueue) track(message MessageReceiveResponse, envelope *claimCheckEnvelope) {
+	if envelope == nil || envelope.Keep {
+		return
+	}
+
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	for receiptHandle, blob := range p.blobs {
+		if blob.nextVisibleTime > 0 && blob.nextVisibleTime < now {
+			delete(p.blobs, receiptHandle)
+		}
+	}
+	p.blobs[message.ReceiptHandle] = claimCheckBlob{key: envelope.Key, nextVisibleTime: message.NextVisibleTime}
+}
+
+func (p *claimCheckQueue) release(receiptHandle string) error {
+	p.locker.Lock()
+	blob, ok := p.blobs[receiptHandle]
+	delete(p.blobs, receiptHandle)
+	p.locker.Unlock()
+
+	if !ok {
+		return nil
+	}
+	return p.deleteBlob(blob.key)
+}
+
+type claimCheckTopic struct {
+	AliMNSTopic
+	claimChecker
+}
+
+// NewClaimCheckTopic wraps topic to offload bodies longer than
+// config.Threshold to config.Store. The subscribed queues must be read
+// through NewClaimCheckQueue with the same store and notify in SIMPLIFIED
+// format. A published blob may be delivered to several queues so it is never
+// deleted by consumers, the store has to expire it, e.g. a FileBlobStore
+// created by NewFileBlobStoreWithMaxAge with a max age longer than the
+// message retention period of the queues.
+func NewClaimCheckTopic(topic AliMNSTopic, config ClaimCheckConfig) AliMNSTopic {
+	return &claimCheckTopic{
+		AliMNSTopic:  topic,
+		claimChecker: claimChecker{config: config.normalize()},
+	}
+}
+
+func (p *claimCheckTopic) PublishMessage(message MessagePublishRequest) (resp MessageSendResponse, err error) {
+	key, err := p.offload(&message.MessageBody, true)
+	if err != nil {
+		return
+	}
+
+	if resp, err = p.AliMNSTopic.PublishMessage(message); err != nil && key != "" {
+		p.config.Store.Delete(key)
+	}
+	return
+}
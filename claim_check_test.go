@@ -0,0 +1,178 @@
+package ali_mns
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestBlobStore(t *testing.T) (*FileBlobStore, func()) {
+	dir, err := ioutil.TempDir("", "ali_mns-blobs")
+	assert.Nil(t, err)
+	store, err := NewFileBlobStore(dir)
+	assert.Nil(t, err)
+	return store, func() { os.RemoveAll(dir) }
+}
+
+func blobCount(t *testing.T, store *FileBlobStore) int {
+	files, err := ioutil.ReadDir(store.dir)
+	assert.Nil(t, err)
+	return len(files)
+}
+
+func TestFileBlobStore(t *testing.T) {
+	store, cleanup := newTestBlobStore(t)
+	defer cleanup()
+
+	assert.Nil(t, store.Put("key", []byte("data")))
+	data, err := store.Get("key")
+	assert.Nil(t, err)
+	assert.Equal(t, "data", string(data))
+
+	assert.Nil(t, store.Delete("key"))
+	assert.Nil(t, store.Delete("key"))
+	_, err = store.Get("key")
+	assert.True(t, IsMNSError(err, ERR_MNS_BLOB_NOT_FOUND))
+
+	_, err = store.Get("../etc/passwd")
+	assert.NotNil(t, err)
+	assert.NotNil(t, store.Put("..", nil))
+}
+
+func TestFileBlobStoreMaxAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ali_mns-blobs")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	store, err := NewFileBlobStoreWithMaxAge(dir, time.Minute)
+	assert.Nil(t, err)
+
+	assert.Nil(t, store.Put("old", []byte("data")))
+	assert.Nil(t, store.Put("new", []byte("data")))
+	hourAgo := time.Now().Add(-time.Hour)
+	assert.Nil(t, os.Chtimes(filepath.Join(dir, "old"), hourAgo, hourAgo))
+
+	_, err = store.Get("old")
+	assert.True(t, IsMNSError(err, ERR_MNS_BLOB_NOT_FOUND))
+	_, err = store.Get("new")
+	assert.Nil(t, err)
+
+	removed, err := store.Sweep()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, removed)
+	assert.Equal(t, 1, blobCount(t, store))
+}
+
+func TestClaimCheckQueue(t *testing.T) {
+	store, cleanup := newTestBlobStore(t)
+	defer cleanup()
+
+	emulator := NewMNSEmulator("", "")
+	assert.Nil(t, NewMNSQueueManager(emulator).CreateSimpleQueue("test-queue"))
+	queue := NewClaimCheckQueue(NewMNSQueue("test-queue", emulator), ClaimCheckConfig{Store: store})
+
+	large := strings.Repeat("x", 100*1024)
+	_, err := queue.SendMessage(MessageSendRequest{MessageBody: large})
+	assert.Nil(t, err)
+	_, err = queue.SendMessage(MessageSendRequest{MessageBody: "small"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, blobCount(t, store))
+
+	raw, err := receiveOne(NewMNSQueue("test-queue", emulator))
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(raw.MessageBody, envelopeMarker+claimCheckEnvelopeName+"="))
+	_, err = NewMNSQueue("test-queue", emulator).ChangeMessageVisibility(raw.ReceiptHandle, 0)
+	assert.Nil(t, err)
+
+	respChan := make(chan BatchMessageReceiveResponse, 1)
+	errChan := make(chan error, 1)
+	queue.BatchReceiveMessage(respChan, errChan, 16)
+	resp := <-respChan
+	assert.Equal(t, 2, len(resp.Messages))
+
+	var handles []string
+	bodies := map[string]bool{}
+	for _, message := range resp.Messages {
+		bodies[message.MessageBody] = true
+		handles = append(handles, message.ReceiptHandle)
+	}
+	assert.Equal(t, map[string]bool{large: true, "small": true}, bodies)
+
+	_, err = queue.BatchDeleteMessage(handles...)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, blobCount(t, store))
+
+	// a failed send leaves no blob behind
+	missing := NewClaimCheckQueue(NewMNSQueue("no-queue", emulator), ClaimCheckConfig{Store: store})
+	_, err = missing.SendMessage(MessageSendRequest{MessageBody: large})
+	assert.True(t, IsMNSError(err, ERR_MNS_QUEUE_NOT_EXIST))
+	assert.Equal(t, 0, blobCount(t, store))
+}
+
+func TestClaimCheckQueuePlainEnvelopeBody(t *testing.T) {
+	store, cleanup := newTestBlobStore(t)
+	defer cleanup()
+
+	emulator := NewMNSEmulator("", "")
+	assert.Nil(t, NewMNSQueueManager(emulator).CreateSimpleQueue("test-queue"))
+	queue := NewClaimCheckQueue(NewMNSQueue("test-queue", emulator), ClaimCheckConfig{Store: store})
+
+	plain := `mns:cc=not-a-key;{"size":1,"md5":"00"}`
+	_, err := queue.SendMessage(MessageSendRequest{MessageBody: plain})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, blobCount(t, store))
+
+	message, err := receiveOne(queue)
+	assert.Nil(t, err)
+	assert.Equal(t, plain, message.MessageBody)
+}
+
+func TestClaimCheckQueueMissingBlob(t *testing.T) {
+	store, cleanup := newTestBlobStore(t)
+	defer cleanup()
+
+	emulator := NewMNSEmulator("", "")
+	assert.Nil(t, NewMNSQueueManager(emulator).CreateSimpleQueue("test-queue"))
+	queue := NewClaimCheckQueue(NewMNSQueue("test-queue", emulator), ClaimCheckConfig{Store: store, Threshold: 10})
+
+	_, err := queue.SendMessage(MessageSendRequest{MessageBody: "more than ten bytes"})
+	assert.Nil(t, err)
+	files, err := ioutil.ReadDir(store.dir)
+	assert.Nil(t, err)
+	assert.Nil(t, store.Delete(files[0].Name()))
+
+	_, err = receiveOne(queue)
+	assert.True(t, IsMNSError(err, ERR_MNS_CLAIM_CHECK_FAILED))
+}
+
+func TestClaimCheckTopic(t *testing.T) {
+	store, cleanup := newTestBlobStore(t)
+	defer cleanup()
+
+	emulator := NewMNSEmulator("", "")
+	assert.Nil(t, NewMNSQueueManager(emulator).CreateSimpleQueue("test-queue"))
+	assert.Nil(t, NewMNSTopicManager(emulator).CreateSimpleTopic("test-topic"))
+
+	topic := NewClaimCheckTopic(NewMNSTopic("test-topic", emulator), ClaimCheckConfig{Store: store})
+	assert.Nil(t, topic.Subscribe("sub", MessageSubsribeRequest{
+		Endpoint:            topic.GenerateQueueEndpoint("test-queue"),
+		NotifyContentFormat: SIMPLIFIED,
+	}))
+
+	large := strings.Repeat("y", 80*1024)
+	_, err := topic.PublishMessage(MessagePublishRequest{MessageBody: large})
+	assert.Nil(t, err)
+
+	queue := NewClaimCheckQueue(NewMNSQueue("test-queue", emulator), ClaimCheckConfig{Store: store})
+	message, err := receiveOne(queue)
+	assert.Nil(t, err)
+	assert.Equal(t, large, message.MessageBody)
+
+	// other subscribers may still need the blob
+	assert.Nil(t, queue.DeleteMessage(message.ReceiptHandle))
+	assert.Equal(t, 1, blobCount(t, store))
+}
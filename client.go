@@ -69,6 +69,8 @@ type aliMNSClient struct {
 	client        *fasthttp.Client
 	proxyURL      string
 	maxConnsSize  int
+	adaptiveQPS   *AdaptiveQPSConfig
+	dial          fasthttp.DialFunc
 
 	accountId string
 	region    string
@@ -126,6 +128,10 @@ func (p aliMNSClient) getRegion() (region string) {
 	return p.region
 }
 
+func (p *aliMNSClient) getAdaptiveQPSConfig() *AdaptiveQPSConfig {
+	return p.adaptiveQPS
+}
+
 func (p *aliMNSClient) SetProxy(url string) {
 	if url == p.proxyURL {
 		return
@@ -146,7 +152,7 @@ func (p *aliMNSClient) initFastHttpClient() {
 
 	timeout := time.Second * time.Duration(timeoutInt)
 
-	p.client = &fasthttp.Client{ReadTimeout: timeout, WriteTimeout: timeout, MaxConnsPerHost: p.maxConnsSize}
+	p.client = &fasthttp.Client{ReadTimeout: timeout, WriteTimeout: timeout, MaxConnsPerHost: p.maxConnsSize, Dial: p.dial}
 }
 
 func (p *aliMNSClient) proxy(req *http.Request) (*neturl.URL, error) {
@@ -166,10 +172,7 @@ func (p *aliMNSClient) authorization(method Method, headers map[string]string, r
 	return
 }
 
-func (p *aliMNSClient) Send(method Method, headers map[string]string, message interface{}, resource string, opts ...Option) (*fasthttp.Response, error) {
-	var xmlContent []byte
-	var err error
-
+func marshalMessage(message interface{}) (xmlContent []byte, err error) {
 	if message == nil {
 		xmlContent = []byte{}
 	} else {
@@ -181,13 +184,22 @@ func (p *aliMNSClient) Send(method Method, headers map[string]string, message in
 		default:
 			if bXml, e := xml.Marshal(message); e != nil {
 				err = ERR_MARSHAL_MESSAGE_FAILED.New(errors.Params{"err": e})
-				return nil, err
+				return
 			} else {
 				xmlContent = bXml
 			}
 		}
 	}
 
+	return
+}
+
+func (p *aliMNSClient) Send(method Method, headers map[string]string, message interface{}, resource string, opts ...Option) (*fasthttp.Response, error) {
+	xmlContent, err := marshalMessage(message)
+	if err != nil {
+		return nil, err
+	}
+
 	xmlMD5 := md5.Sum(xmlContent)
 	strMd5 := fmt.Sprintf("%x", xmlMD5)
 
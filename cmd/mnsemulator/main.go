@@ -0,0 +1,29 @@
+// Command mnsemulator runs a local MNS server backed by the in-memory emulator.
+//
+// Clients keep using the {accountId}.mns.{region}.aliyuncs.com url printed on
+// start, and connect to the listen address with the ali_mns.Dial option or by
+// resolving that host to it.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/aliyun-fc/ali_mns"
+	"github.com/aliyun-fc/ali_mns/mnstest"
+)
+
+func main() {
+	listen := flag.String("listen", "127.0.0.1:8090", "address to listen on")
+	accessKeyId := flag.String("access-key-id", "test-id", "access key id clients sign with")
+	accessKeySecret := flag.String("access-key-secret", "test-secret", "access key secret clients sign with")
+	accountId := flag.String("account-id", "1234567890", "emulated account id")
+	region := flag.String("region", "cn-hangzhou", "emulated region")
+	flag.Parse()
+
+	emulator := ali_mns.NewMNSEmulator(*accountId, *region)
+
+	log.Printf("mns emulator of %s listening on %s", emulator.Endpoint(), *listen)
+	log.Fatal(http.ListenAndServe(*listen, mnstest.NewHandler(emulator, *accessKeyId, *accessKeySecret)))
+}
@@ -0,0 +1,255 @@
+package ali_mns
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/gogap/errors"
+)
+
+// an encoded body is in an envelope naming the content type of its codec,
+// e.g. "mns:ct=json;{...}"
+const (
+	codecEnvelope    = "ct"
+	base64TypePrefix = "base64+"
+)
+
+// Codec encodes message bodies, ContentType names it in the envelope of the
+// body and must not contain ';'.
+type Codec interface {
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// ProtobufCodec is in the protocodec package, so that this one does not
+// depend on protobuf.
+var (
+	JSONCodec Codec = jsonCodec{}
+	GobCodec  Codec = gobCodec{}
+	// RawCodec sends a string or []byte as is.
+	RawCodec Codec = rawCodec{}
+)
+
+var (
+	codecsLocker sync.RWMutex
+	codecs       = map[string]Codec{}
+)
+
+func init() {
+	for _, codec := range []Codec{JSONCodec, GobCodec, RawCodec} {
+		RegisterCodec(codec)
+	}
+}
+
+// RegisterCodec makes codec known to DecodeBody, replacing the one with the
+// same content type.
+func RegisterCodec(codec Codec) {
+	codecsLocker.Lock()
+	defer codecsLocker.Unlock()
+	codecs[codec.ContentType()] = codec
+}
+
+func lookupCodec(contentType string) (Codec, error) {
+	if strings.HasPrefix(contentType, base64TypePrefix) {
+		inner, err := lookupCodec(strings.TrimPrefix(contentType, base64TypePrefix))
+		if err != nil {
+			return nil, err
+		}
+		return NewBase64Codec(inner), nil
+	}
+
+	codecsLocker.RLock()
+	defer codecsLocker.RUnlock()
+	if codec, ok := codecs[contentType]; ok {
+		return codec, nil
+	}
+	return nil, ERR_MNS_UNKNOWN_CODEC.New(errors.Params{"type": contentType})
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return "json"
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) ContentType() string {
+	return "gob"
+}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	buf := bytes.Buffer{}
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type rawCodec struct{}
+
+func (rawCodec) ContentType() string {
+	return "raw"
+}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	switch data := v.(type) {
+	case []byte:
+		return data, nil
+	case string:
+		return []byte(data), nil
+	}
+	return nil, ERR_MNS_CODEC_FAILED.New(errors.Params{"type": "raw", "err": reflect.TypeOf(v).String() + " is not a string or []byte"})
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	switch target := v.(type) {
+	case *[]byte:
+		*target = append([]byte(nil), data...)
+		return nil
+	case *string:
+		*target = string(data)
+		return nil
+	}
+	return ERR_MNS_CODEC_FAILED.New(errors.Params{"type": "raw", "err": reflect.TypeOf(v).String() + " is not a *string or *[]byte"})
+}
+
+type base64Codec struct {
+	inner Codec
+}
+
+// NewBase64Codec wraps inner so its output is always valid in the xml of a
+// request, EncodeBody does so by itself when it has to.
+func NewBase64Codec(inner Codec) Codec {
+	return base64Codec{inner: inner}
+}
+
+func (p base64Codec) ContentType() string {
+	return base64TypePrefix + p.inner.ContentType()
+}
+
+func (p base64Codec) Marshal(v interface{}) ([]byte, error) {
+	data, err := p.inner.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(data)))
+	base64.StdEncoding.Encode(encoded, data)
+	return encoded, nil
+}
+
+func (p base64Codec) Unmarshal(data []byte, v interface{}) error {
+	decoded := make([]byte, base64.StdEncoding.DecodedLen(len(data)))
+	n, err := base64.StdEncoding.Decode(decoded, data)
+	if err != nil {
+		return err
+	}
+	return p.inner.Unmarshal(decoded[:n], v)
+}
+
+// EncodeBody encodes v with codec into a message body marked with the
+// content type, output not valid in xml is base64 wrapped.
+func EncodeBody(codec Codec, v interface{}) (string, error) {
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return "", codecError(codec, err)
+	}
+
+	if !isValidXMLText(data) {
+		codec = NewBase64Codec(codec)
+		if data, err = codec.Marshal(v); err != nil {
+			return "", codecError(codec, err)
+		}
+	}
+	return sealEnvelope(codecEnvelope, codec.ContentType(), string(data)), nil
+}
+
+// DecodeBody decodes a body made by EncodeBody with the codec named by its
+// envelope. Other bodies are taken as raw for string and []byte and as json
+// otherwise.
+func DecodeBody[T any](body string) (v T, err error) {
+	var codec Codec
+	data := body
+	if contentType, rest, ok := openEnvelope(body, codecEnvelope); ok {
+		if codec, err = lookupCodec(contentType); err != nil {
+			return
+		}
+		data = rest
+	} else {
+		switch interface{}(&v).(type) {
+		case *string, *[]byte:
+			codec = RawCodec
+		default:
+			codec = JSONCodec
+		}
+	}
+
+	if err = codec.Unmarshal([]byte(data), &v); err != nil {
+		err = codecError(codec, err)
+	}
+	return
+}
+
+// SendTyped sends v encoded by codec to queue.
+func SendTyped[T any](queue AliMNSQueue, v T, codec Codec, opts ...Option) (resp MessageSendResponse, err error) {
+	body, err := EncodeBody(codec, v)
+	if err != nil {
+		return
+	}
+	return queue.SendMessage(MessageSendRequest{MessageBody: body}, opts...)
+}
+
+// PublishTyped publishes v encoded by codec to topic.
+func PublishTyped[T any](topic AliMNSTopic, v T, codec Codec) (resp MessageSendResponse, err error) {
+	body, err := EncodeBody(codec, v)
+	if err != nil {
+		return
+	}
+	return topic.PublishMessage(MessagePublishRequest{MessageBody: body})
+}
+
+func codecError(codec Codec, err error) error {
+	if IsMNSError(err, ERR_MNS_CODEC_FAILED) {
+		return err
+	}
+	return ERR_MNS_CODEC_FAILED.New(errors.Params{"type": codec.ContentType(), "err": err})
+}
+
+// isValidXMLText reports whether data survives the xml of a request, the
+// encoder replaces invalid characters.
+func isValidXMLText(data []byte) bool {
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size == 1 {
+			return false
+		}
+		if !(r == 0x09 || r == 0x0A || r == 0x0D ||
+			r >= 0x20 && r <= 0xD7FF ||
+			r >= 0xE000 && r <= 0xFFFD ||
+			r >= 0x10000 && r <= 0x10FFFF) {
+			return false
+		}
+		data = data[size:]
+	}
+	return true
+}
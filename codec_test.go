@@ -0,0 +1,86 @@
+package ali_mns
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type codecTestOrder struct {
+	ID    int      `json:"id"`
+	Items []string `json:"items"`
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	order := codecTestOrder{ID: 7, Items: []string{"a", "b"}}
+
+	for _, codec := range []Codec{JSONCodec, GobCodec, NewBase64Codec(JSONCodec)} {
+		body, err := EncodeBody(codec, order)
+		assert.Nil(t, err)
+		decoded, err := DecodeBody[codecTestOrder](body)
+		assert.Nil(t, err, codec.ContentType())
+		assert.Equal(t, order, decoded, codec.ContentType())
+	}
+
+	body, err := EncodeBody(JSONCodec, order)
+	assert.Nil(t, err)
+	assert.Equal(t, `mns:ct=json;{"id":7,"items":["a","b"]}`, body)
+
+	// gob output is binary and gets wrapped
+	body, err = EncodeBody(GobCodec, order)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(body, "mns:ct=base64+gob;"))
+
+	raw, err := EncodeBody(RawCodec, []byte{0x00, 0x01, 'a'})
+	assert.Nil(t, err)
+	assert.Equal(t, "mns:ct=base64+raw;AAFh", raw)
+	data, err := DecodeBody[[]byte](raw)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x00, 0x01, 'a'}, data)
+}
+
+func TestDecodeBodyWithoutMarker(t *testing.T) {
+	text, err := DecodeBody[string]("plain text")
+	assert.Nil(t, err)
+	assert.Equal(t, "plain text", text)
+
+	order, err := DecodeBody[codecTestOrder](`{"id":1}`)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, order.ID)
+
+	// only an envelope names a codec
+	text, err = DecodeBody[string]("ct=json;plain")
+	assert.Nil(t, err)
+	assert.Equal(t, "ct=json;plain", text)
+
+	_, err = DecodeBody[codecTestOrder]("mns:ct=yaml;id: 1")
+	assert.True(t, IsMNSError(err, ERR_MNS_UNKNOWN_CODEC))
+	_, err = DecodeBody[codecTestOrder]("mns:ct=json;not json")
+	assert.True(t, IsMNSError(err, ERR_MNS_CODEC_FAILED))
+}
+
+func TestSendTyped(t *testing.T) {
+	emulator := NewMNSEmulator("", "")
+	assert.Nil(t, NewMNSQueueManager(emulator).CreateSimpleQueue("test-queue"))
+	queue := NewMNSQueue("test-queue", emulator)
+
+	// control characters would be replaced in the xml of a plain body
+	text := "line\x00break\x1b"
+	_, err := SendTyped(queue, text, RawCodec)
+	assert.Nil(t, err)
+	_, err = SendTyped(queue, codecTestOrder{ID: 2}, JSONCodec)
+	assert.Nil(t, err)
+
+	message, err := receiveOne(queue)
+	assert.Nil(t, err)
+	received, err := DecodeBody[string](message.MessageBody)
+	assert.Nil(t, err)
+	assert.Equal(t, text, received)
+
+	message, err = receiveOne(queue)
+	assert.Nil(t, err)
+	order, err := DecodeBody[codecTestOrder](message.MessageBody)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, order.ID)
+}
@@ -0,0 +1,273 @@
+package ali_mns
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/gogap/errors"
+	"github.com/klauspost/compress/zstd"
+)
+
+type CompressionAlgorithm string
+
+const (
+	CompressionGzip CompressionAlgorithm = "gzip"
+	CompressionZstd CompressionAlgorithm = "zstd"
+)
+
+const (
+	DefaultCompressionThreshold = 1024
+	DefaultMaxDecompressedSize  = 4 * 1024 * 1024
+)
+
+// a compressed body is in an envelope naming its algorithm, with the base64
+// of the compressed bytes, e.g. "mns:ce=gzip;H4sI..."
+const compressionEnvelope = "ce"
+
+var (
+	zstdEncoderOnce sync.Once
+	zstdEncoder     *zstd.Encoder
+	zstdEncoderErr  error
+)
+
+// CompressionConfig configures a compression queue or topic. Bodies up to
+// Threshold bytes, and bodies that would not get shorter, are sent as is.
+// Received bodies decompressing to more than MaxDecompressedSize bytes fail
+// with ERR_MNS_DECOMPRESSED_TOO_LARGE.
+type CompressionConfig struct {
+	Algorithm           CompressionAlgorithm
+	Threshold           int
+	MaxDecompressedSize int
+}
+
+func (p CompressionConfig) normalize() CompressionConfig {
+	switch p.Algorithm {
+	case "":
+		p.Algorithm = CompressionGzip
+	case CompressionGzip, CompressionZstd:
+	default:
+		panic("ali_mns: unknown compression algorithm " + string(p.Algorithm))
+	}
+	if p.Threshold <= 0 {
+		p.Threshold = DefaultCompressionThreshold
+	}
+	if p.MaxDecompressedSize <= 0 {
+		p.MaxDecompressedSize = DefaultMaxDecompressedSize
+	}
+	return p
+}
+
+type compressor struct {
+	config CompressionConfig
+}
+
+func (p compressor) compress(body *string) error {
+	if len(*body) <= p.config.Threshold {
+		*body = escapeEnvelope(*body, compressionEnvelope)
+		return nil
+	}
+
+	compressed, err := compressBytes(p.config.Algorithm, []byte(*body))
+	if err != nil {
+		return ERR_MNS_COMPRESSION_FAILED.New(errors.Params{"algorithm": p.config.Algorithm, "err": err})
+	}
+
+	encoded := sealEnvelope(compressionEnvelope, string(p.config.Algorithm), base64.StdEncoding.EncodeToString(compressed))
+	if len(encoded) < len(*body) {
+		*body = encoded
+	} else {
+		*body = escapeEnvelope(*body, compressionEnvelope)
+	}
+	return nil
+}
+
+// decompress restores a compressed body with whatever algorithm it names.
+// Other bodies, and the ones naming an unknown algorithm or not in base64,
+// are left alone.
+func (p compressor) decompress(body *string) error {
+	value, payload, ok := openEnvelope(*body, compressionEnvelope)
+	if !ok {
+		return nil
+	}
+
+	algorithm := CompressionAlgorithm(value)
+	switch algorithm {
+	case "":
+		*body = payload
+		return nil
+	case CompressionGzip, CompressionZstd:
+	default:
+		return nil
+	}
+
+	compressed, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil
+	}
+
+	data, err := decompressBytes(algorithm, compressed, p.config.MaxDecompressedSize)
+	if err != nil {
+		return err
+	}
+	*body = string(data)
+	return nil
+}
+
+func (p compressor) decompressBatch(batch *BatchMessageReceiveResponse) error {
+	for i := range batch.Messages {
+		if err := p.decompress(&batch.Messages[i].MessageBody); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func compressBytes(algorithm CompressionAlgorithm, data []byte) ([]byte, error) {
+	if algorithm == CompressionZstd {
+		zstdEncoderOnce.Do(func() {
+			zstdEncoder, zstdEncoderErr = zstd.NewWriter(nil)
+		})
+		if zstdEncoderErr != nil {
+			return nil, zstdEncoderErr
+		}
+		return zstdEncoder.EncodeAll(data, nil), nil
+	}
+
+	buf := bytes.Buffer{}
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressBytes reads at most maxSize bytes so a small body can not expand
+// into an unbounded allocation.
+func decompressBytes(algorithm CompressionAlgorithm, data []byte, maxSize int) ([]byte, error) {
+	var reader io.Reader
+	switch algorithm {
+	case CompressionGzip:
+		gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, ERR_MNS_COMPRESSION_FAILED.New(errors.Params{"algorithm": algorithm, "err": err})
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	case CompressionZstd:
+		window := uint64(maxSize)
+		if window < zstd.MinWindowSize {
+			window = zstd.MinWindowSize
+		}
+		zstdReader, err := zstd.NewReader(bytes.NewReader(data), zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderLowmem(true), zstd.WithDecoderMaxWindow(window))
+		if err != nil {
+			return nil, ERR_MNS_COMPRESSION_FAILED.New(errors.Params{"algorithm": algorithm, "err": err})
+		}
+		defer zstdReader.Close()
+		reader = zstdReader
+	default:
+		return nil, ERR_MNS_COMPRESSION_FAILED.New(errors.Params{"algorithm": algorithm, "err": "unknown algorithm"})
+	}
+
+	decompressed, err := ioutil.ReadAll(io.LimitReader(reader, int64(maxSize)+1))
+	if err == zstd.ErrWindowSizeExceeded || err == zstd.ErrDecoderSizeExceeded {
+		return nil, ERR_MNS_DECOMPRESSED_TOO_LARGE.New(errors.Params{"max": maxSize})
+	}
+	if err != nil {
+		return nil, ERR_MNS_COMPRESSION_FAILED.New(errors.Params{"algorithm": algorithm, "err": err})
+	}
+	if len(decompressed) > maxSize {
+		return nil, ERR_MNS_DECOMPRESSED_TOO_LARGE.New(errors.Params{"max": maxSize})
+	}
+	return decompressed, nil
+}
+
+type compressionQueue struct {
+	AliMNSQueue
+	compressor
+}
+
+// NewCompressionQueue wraps queue to compress the bodies sent and decompress
+// the bodies received. Wrapping a claim-check queue offloads only the bodies
+// still too large once compressed.
+func NewCompressionQueue(queue AliMNSQueue, config CompressionConfig) AliMNSQueue {
+	return &compressionQueue{
+		AliMNSQueue: queue,
+		compressor:  compressor{config: config.normalize()},
+	}
+}
+
+func (p *compressionQueue) SendMessage(message MessageSendRequest, opts ...Option) (resp MessageSendResponse, err error) {
+	if err = p.compress(&message.MessageBody); err != nil {
+		return
+	}
+	return p.AliMNSQueue.SendMessage(message, opts...)
+}
+
+func (p *compressionQueue) BatchSendMessage(messages ...MessageSendRequest) (resp BatchMessageSendResponse, err error) {
+	compressed := make([]MessageSendRequest, len(messages))
+	for i, message := range messages {
+		if err = p.compress(&message.MessageBody); err != nil {
+			return
+		}
+		compressed[i] = message
+	}
+	return p.AliMNSQueue.BatchSendMessage(compressed...)
+}
+
+func (p *compressionQueue) ReceiveMessage(respChan chan MessageReceiveResponse, errChan chan error, waitseconds ...int64) {
+	relayReceive(func(innerChan chan MessageReceiveResponse) {
+		p.AliMNSQueue.ReceiveMessage(innerChan, errChan, waitseconds...)
+	}, respChan, errChan, func(message *MessageReceiveResponse) error {
+		return p.decompress(&message.MessageBody)
+	})
+}
+
+func (p *compressionQueue) PeekMessage(respChan chan MessageReceiveResponse, errChan chan error) {
+	relayReceive(func(innerChan chan MessageReceiveResponse) {
+		p.AliMNSQueue.PeekMessage(innerChan, errChan)
+	}, respChan, errChan, func(message *MessageReceiveResponse) error {
+		return p.decompress(&message.MessageBody)
+	})
+}
+
+func (p *compressionQueue) BatchReceiveMessage(respChan chan BatchMessageReceiveResponse, errChan chan error, numOfMessages int32, waitseconds ...int64) {
+	relayBatchReceive(func(innerChan chan BatchMessageReceiveResponse) {
+		p.AliMNSQueue.BatchReceiveMessage(innerChan, errChan, numOfMessages, waitseconds...)
+	}, respChan, errChan, p.decompressBatch)
+}
+
+func (p *compressionQueue) BatchPeekMessage(respChan chan BatchMessageReceiveResponse, errChan chan error, numOfMessages int32) {
+	relayBatchReceive(func(innerChan chan BatchMessageReceiveResponse) {
+		p.AliMNSQueue.BatchPeekMessage(innerChan, errChan, numOfMessages)
+	}, respChan, errChan, p.decompressBatch)
+}
+
+type compressionTopic struct {
+	AliMNSTopic
+	compressor
+}
+
+// NewCompressionTopic wraps topic to compress the bodies published, the
+// subscribed queues must notify in SIMPLIFIED format and be read through
+// NewCompressionQueue.
+func NewCompressionTopic(topic AliMNSTopic, config CompressionConfig) AliMNSTopic {
+	return &compressionTopic{
+		AliMNSTopic: topic,
+		compressor:  compressor{config: config.normalize()},
+	}
+}
+
+func (p *compressionTopic) PublishMessage(message MessagePublishRequest) (resp MessageSendResponse, err error) {
+	if err = p.compress(&message.MessageBody); err != nil {
+		return
+	}
+	return p.AliMNSTopic.PublishMessage(message)
+}
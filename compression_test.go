@@ -0,0 +1,87 @@
+package ali_mns
+
+import (
+	"encoding/base64"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressionQueue(t *testing.T) {
+	emulator := NewMNSEmulator("", "")
+	assert.Nil(t, NewMNSQueueManager(emulator).CreateSimpleQueue("test-queue"))
+	raw := NewMNSQueue("test-queue", emulator)
+
+	// larger than the queue allows uncompressed
+	large := strings.Repeat(`{"id":1,"name":"order"},`, 4*1024)
+
+	for _, algorithm := range []CompressionAlgorithm{CompressionGzip, CompressionZstd} {
+		queue := NewCompressionQueue(raw, CompressionConfig{Algorithm: algorithm})
+		_, err := queue.SendMessage(MessageSendRequest{MessageBody: large})
+		assert.Nil(t, err)
+		_, err = queue.BatchSendMessage(MessageSendRequest{MessageBody: "small"}, MessageSendRequest{MessageBody: large})
+		assert.Nil(t, err)
+
+		message, err := receiveOne(raw)
+		assert.Nil(t, err)
+		assert.True(t, strings.HasPrefix(message.MessageBody, "mns:ce="+string(algorithm)+";"))
+		_, err = raw.ChangeMessageVisibility(message.ReceiptHandle, 0)
+		assert.Nil(t, err)
+
+		respChan := make(chan BatchMessageReceiveResponse, 1)
+		errChan := make(chan error, 1)
+		queue.BatchReceiveMessage(respChan, errChan, 16)
+		resp := <-respChan
+		assert.Equal(t, 3, len(resp.Messages))
+		for _, message := range resp.Messages {
+			assert.Contains(t, []string{large, "small"}, message.MessageBody)
+			assert.Nil(t, raw.DeleteMessage(message.ReceiptHandle))
+		}
+	}
+}
+
+func TestCompressionSkipsSmallAndIncompressible(t *testing.T) {
+	p := compressor{config: CompressionConfig{}.normalize()}
+
+	body := "short"
+	assert.Nil(t, p.compress(&body))
+	assert.Equal(t, "short", body)
+
+	random := make([]byte, 3000)
+	rand.New(rand.NewSource(1)).Read(random)
+	body = base64.StdEncoding.EncodeToString(random)
+	original := body
+	assert.Nil(t, p.compress(&body))
+	assert.Equal(t, original, body)
+
+	// unknown algorithms, invalid base64 and plain bodies are left alone
+	for _, other := range []string{"mns:ce=brotli;AAAA", "mns:ce=gzip;not base64", "ce=gzip;AAAA"} {
+		body = other
+		assert.Nil(t, p.decompress(&body))
+		assert.Equal(t, other, body)
+	}
+	body = "mns:ce=gzip;H4sIAAAA"
+	assert.True(t, IsMNSError(p.decompress(&body), ERR_MNS_COMPRESSION_FAILED))
+
+	// a plain body looking like a compressed one survives the round trip
+	body = "mns:ce=gzip;AAAA"
+	assert.Nil(t, p.compress(&body))
+	assert.Nil(t, p.decompress(&body))
+	assert.Equal(t, "mns:ce=gzip;AAAA", body)
+}
+
+func TestDecompressionSizeGuard(t *testing.T) {
+	bomb := strings.Repeat("0", 10*1024*1024)
+
+	for _, algorithm := range []CompressionAlgorithm{CompressionGzip, CompressionZstd} {
+		sender := compressor{config: CompressionConfig{Algorithm: algorithm}.normalize()}
+		body := bomb
+		assert.Nil(t, sender.compress(&body))
+		assert.True(t, len(body) < 64*1024)
+
+		receiver := compressor{config: CompressionConfig{MaxDecompressedSize: 1024 * 1024}.normalize()}
+		assert.True(t, IsMNSError(receiver.decompress(&body), ERR_MNS_DECOMPRESSED_TOO_LARGE), string(algorithm))
+	}
+}
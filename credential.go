@@ -43,12 +43,16 @@ func (p *AliMNSCredential) SetSecretKey(accessKeySecret string) {
 }
 
 func (p *AliMNSCredential) Signature(method Method, headers map[string]string, resource string) (signature string, err error) {
-	signItems := []string{}
-	signItems = append(signItems, string(method))
+	date := time.Now().UTC().Format(http.TimeFormat)
+	return signString(p.accessKeySecret, stringToSign(method, headers, resource, date))
+}
 
+// stringToSign builds the canonical string of a request:
+//
+//	METHOD\nContent-MD5\nContent-Type\nDate\nx-mns-* headers\nresource
+func stringToSign(method Method, headers map[string]string, resource string, date string) string {
 	contentMD5 := ""
 	contentType := ""
-	date := time.Now().UTC().Format(http.TimeFormat)
 
 	if v, exist := headers[CONTENT_MD5]; exist {
 		contentMD5 = v
@@ -72,14 +76,16 @@ func (p *AliMNSCredential) Signature(method Method, headers map[string]string, r
 
 	sort.Sort(sort.StringSlice(mnsHeaders))
 
-	stringToSign := string(method) + "\n" +
+	return string(method) + "\n" +
 		contentMD5 + "\n" +
 		contentType + "\n" +
 		date + "\n" +
 		strings.Join(mnsHeaders, "\n") + "\n" +
 		resource
+}
 
-	sha1Hash := hmac.New(sha1.New, []byte(p.accessKeySecret))
+func signString(accessKeySecret string, stringToSign string) (signature string, err error) {
+	sha1Hash := hmac.New(sha1.New, []byte(accessKeySecret))
 	if _, e := sha1Hash.Write([]byte(stringToSign)); e != nil {
 		err = ERR_SIGN_MESSAGE_FAILED.New(errors.Params{"err": e})
 		return
@@ -73,14 +73,12 @@ func (p *batchOpDecoder) Decode(reader io.Reader, v interface{}) (err error) {
 }
 
 func (p *batchOpDecoder) DecodeError(bodyBytes []byte, resource string) (decodedError error, err error) {
-	bodyReader := bytes.NewReader(bodyBytes)
-
-	decoder := xml.NewDecoder(bodyReader)
-	err = decoder.Decode(&p.v)
+	err = xml.NewDecoder(bytes.NewReader(bodyBytes)).Decode(&p.v)
 	if err != nil {
-		bodyReader.Seek(0, 0)
+		// not a batch result, the whole request failed; the decoder has
+		// consumed the root element, so decode again from the start
 		errResp := ErrorResponse{}
-		err = decoder.Decode(&errResp)
+		err = xml.NewDecoder(bytes.NewReader(bodyBytes)).Decode(&errResp)
 		if err == nil {
 			decodedError = ParseError(errResp, resource)
 		}
@@ -114,14 +112,10 @@ func (p *batchOpDecoderErrResp) Decode(reader io.Reader, v interface{}) (err err
 }
 
 func (p *batchOpDecoderErrResp) DecodeError(bodyBytes []byte, resource string) (decodedError error, err error) {
-	bodyReader := bytes.NewReader(bodyBytes)
-
-	decoder := xml.NewDecoder(bodyReader)
-	err = decoder.Decode(&p.v)
+	err = xml.NewDecoder(bytes.NewReader(bodyBytes)).Decode(&p.v)
 	if err != nil {
-		bodyReader.Seek(0, 0)
 		errResp := ErrorResponse{}
-		err = decoder.Decode(&errResp)
+		err = xml.NewDecoder(bytes.NewReader(bodyBytes)).Decode(&errResp)
 		if err == nil {
 			decodedError = errResp
 		}
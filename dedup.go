@@ -0,0 +1,356 @@
+package ali_mns
+
+import (
+	"bufio"
+	"container/list"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gogap/errors"
+)
+
+const (
+	DefaultDedupTTL         = 24 * time.Hour
+	DefaultDedupInFlightTTL = 5 * time.Minute
+)
+
+type DedupState int
+
+const (
+	// DedupNew means the key was not seen, or its record expired.
+	DedupNew DedupState = iota
+	DedupInFlight
+	DedupDone
+)
+
+// DedupStore records the processing state of message keys. Begin marks key
+// in flight for ttl and returns DedupNew, unless the key has an unexpired
+// record, whose state it returns untouched. Complete marks key done for ttl
+// and Abort forgets it.
+type DedupStore interface {
+	Begin(key string, ttl time.Duration) (DedupState, error)
+	Complete(key string, ttl time.Duration) error
+	Abort(key string) error
+}
+
+type dedupRecord struct {
+	key     string
+	state   DedupState
+	expires time.Time
+}
+
+// MemoryDedupStore is a DedupStore keeping up to capacity keys in memory,
+// the least recently used ones are evicted first.
+type MemoryDedupStore struct {
+	locker   sync.Mutex
+	capacity int
+	records  map[string]*list.Element
+	lru      *list.List
+}
+
+func NewMemoryDedupStore(capacity int) *MemoryDedupStore {
+	if capacity <= 0 {
+		panic("ali_mns: dedup store capacity should be positive")
+	}
+	return &MemoryDedupStore{
+		capacity: capacity,
+		records:  map[string]*list.Element{},
+		lru:      list.New(),
+	}
+}
+
+func (p *MemoryDedupStore) Begin(key string, ttl time.Duration) (DedupState, error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if element, ok := p.records[key]; ok {
+		record := element.Value.(*dedupRecord)
+		if time.Now().Before(record.expires) {
+			p.lru.MoveToFront(element)
+			return record.state, nil
+		}
+	}
+
+	p.set(key, DedupInFlight, ttl)
+	return DedupNew, nil
+}
+
+func (p *MemoryDedupStore) Complete(key string, ttl time.Duration) error {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	p.set(key, DedupDone, ttl)
+	return nil
+}
+
+func (p *MemoryDedupStore) Abort(key string) error {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if element, ok := p.records[key]; ok {
+		p.lru.Remove(element)
+		delete(p.records, key)
+	}
+	return nil
+}
+
+// Len returns how many keys are kept, expired ones included.
+func (p *MemoryDedupStore) Len() int {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	return p.lru.Len()
+}
+
+func (p *MemoryDedupStore) set(key string, state DedupState, ttl time.Duration) {
+	record := &dedupRecord{key: key, state: state, expires: time.Now().Add(ttl)}
+	if element, ok := p.records[key]; ok {
+		element.Value = record
+		p.lru.MoveToFront(element)
+		return
+	}
+
+	p.records[key] = p.lru.PushFront(record)
+	for p.lru.Len() > p.capacity {
+		oldest := p.lru.Back()
+		p.lru.Remove(oldest)
+		delete(p.records, oldest.Value.(*dedupRecord).key)
+	}
+}
+
+// fileDedupEntry is one line of the log of a FileDedupStore, an aborted key
+// is logged with DedupNew.
+type fileDedupEntry struct {
+	Key     string     `json:"k"`
+	State   DedupState `json:"s"`
+	Expires int64      `json:"e"`
+}
+
+// FileDedupStore is a DedupStore surviving restarts. Every change is
+// appended to a log file, replayed when the store is opened and compacted
+// once it is mostly stale. A file must be used by one store at a time.
+type FileDedupStore struct {
+	locker  sync.Mutex
+	path    string
+	file    *os.File
+	records map[string]fileDedupEntry
+	lines   int
+}
+
+func OpenFileDedupStore(path string) (*FileDedupStore, error) {
+	store := &FileDedupStore{path: path, records: map[string]fileDedupEntry{}}
+	if err := store.load(); err != nil {
+		return nil, ERR_MNS_DEDUP_STORE_FAILED.New(errors.Params{"err": err})
+	}
+	if err := store.compact(); err != nil {
+		return nil, ERR_MNS_DEDUP_STORE_FAILED.New(errors.Params{"err": err})
+	}
+	return store, nil
+}
+
+func (p *FileDedupStore) Begin(key string, ttl time.Duration) (DedupState, error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if record, ok := p.records[key]; ok && time.Now().Before(millisecondsTime(record.Expires)) {
+		return record.State, nil
+	}
+	return DedupNew, p.append(fileDedupEntry{Key: key, State: DedupInFlight, Expires: expiresInMillis(ttl)}, false)
+}
+
+func (p *FileDedupStore) Complete(key string, ttl time.Duration) error {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	return p.append(fileDedupEntry{Key: key, State: DedupDone, Expires: expiresInMillis(ttl)}, true)
+}
+
+func (p *FileDedupStore) Abort(key string) error {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	return p.append(fileDedupEntry{Key: key, State: DedupNew}, false)
+}
+
+func (p *FileDedupStore) Close() error {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	return p.file.Close()
+}
+
+func (p *FileDedupStore) load() error {
+	file, err := os.Open(p.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		entry := fileDedupEntry{}
+		// a line torn by a crash is skipped
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			continue
+		}
+		p.apply(entry)
+	}
+	return scanner.Err()
+}
+
+// append logs entry and applies it, sync makes it durable before returning.
+func (p *FileDedupStore) append(entry fileDedupEntry, sync bool) error {
+	line, _ := json.Marshal(entry)
+	if _, err := p.file.Write(append(line, '\n')); err != nil {
+		return ERR_MNS_DEDUP_STORE_FAILED.New(errors.Params{"err": err})
+	}
+	if sync {
+		if err := p.file.Sync(); err != nil {
+			return ERR_MNS_DEDUP_STORE_FAILED.New(errors.Params{"err": err})
+		}
+	}
+
+	p.apply(entry)
+	p.lines++
+	if p.lines > 2*len(p.records)+1024 {
+		if err := p.compact(); err != nil {
+			return ERR_MNS_DEDUP_STORE_FAILED.New(errors.Params{"err": err})
+		}
+	}
+	return nil
+}
+
+func (p *FileDedupStore) apply(entry fileDedupEntry) {
+	if entry.State == DedupNew {
+		delete(p.records, entry.Key)
+	} else {
+		p.records[entry.Key] = entry
+	}
+}
+
+// compact rewrites the log with the unexpired records only.
+func (p *FileDedupStore) compact() error {
+	tmp, err := ioutil.TempFile(filepath.Dir(p.path), filepath.Base(p.path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	now := time.Now()
+	writer := bufio.NewWriter(tmp)
+	for key, record := range p.records {
+		if !now.Before(millisecondsTime(record.Expires)) {
+			delete(p.records, key)
+			continue
+		}
+		line, _ := json.Marshal(record)
+		writer.Write(append(line, '\n'))
+	}
+	if err = writer.Flush(); err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), p.path); err != nil {
+		return err
+	}
+
+	if p.file != nil {
+		p.file.Close()
+	}
+	p.file, err = os.OpenFile(p.path, os.O_WRONLY|os.O_APPEND, 0644)
+	p.lines = len(p.records)
+	return err
+}
+
+func expiresInMillis(ttl time.Duration) int64 {
+	return time.Now().Add(ttl).UnixNano() / int64(time.Millisecond)
+}
+
+// IdempotentConsumerConfig configures an IdempotentConsumer. Key returns the
+// dedup key of a message, MessageIdempotencyKey by default, messages with an
+// empty key are always handled. Processed keys are remembered for TTL, a key
+// being handled is held for InFlightTTL at most, in case its consumer dies.
+type IdempotentConsumerConfig struct {
+	Store       DedupStore
+	Key         func(message MessageReceiveResponse) string
+	TTL         time.Duration
+	InFlightTTL time.Duration
+}
+
+func (p IdempotentConsumerConfig) normalize() IdempotentConsumerConfig {
+	if p.Store == nil {
+		panic("ali_mns: dedup store could not be nil")
+	}
+	if p.Key == nil {
+		p.Key = MessageIdempotencyKey
+	}
+	if p.TTL <= 0 {
+		p.TTL = DefaultDedupTTL
+	}
+	if p.InFlightTTL <= 0 {
+		p.InFlightTTL = DefaultDedupInFlightTTL
+	}
+	return p
+}
+
+// IdempotentConsumer handles each message once and deletes it from queue.
+type IdempotentConsumer struct {
+	queue  AliMNSQueue
+	config IdempotentConsumerConfig
+}
+
+func NewIdempotentConsumer(queue AliMNSQueue, config IdempotentConsumerConfig) *IdempotentConsumer {
+	return &IdempotentConsumer{queue: queue, config: config.normalize()}
+}
+
+// Handle calls handler with message and deletes it once handled. A message
+// already handled is deleted without calling handler. One being handled by
+// another call fails with ERR_MNS_MESSAGE_IN_FLIGHT and is left in the queue,
+// to be seen again after its visibility timeout. If handler fails its error
+// is returned and the message can be handled again. The envelope of a
+// message sent by an IdempotentProducer is stripped before handler sees it,
+// other bodies are passed as they are.
+func (p *IdempotentConsumer) Handle(message MessageReceiveResponse, handler func(MessageReceiveResponse) error) error {
+	key := p.config.Key(message)
+	_, message.MessageBody = SplitIdempotencyKey(message.MessageBody)
+	if key == "" {
+		if err := handler(message); err != nil {
+			return err
+		}
+		return p.queue.DeleteMessage(message.ReceiptHandle)
+	}
+
+	state, err := p.config.Store.Begin(key, p.config.InFlightTTL)
+	if err != nil {
+		return err
+	}
+
+	switch state {
+	case DedupDone:
+		return p.queue.DeleteMessage(message.ReceiptHandle)
+	case DedupInFlight:
+		return ERR_MNS_MESSAGE_IN_FLIGHT.New(errors.Params{"key": key})
+	}
+
+	if err = handler(message); err != nil {
+		p.config.Store.Abort(key)
+		return err
+	}
+	if err = p.config.Store.Complete(key, p.config.TTL); err != nil {
+		return err
+	}
+	return p.queue.DeleteMessage(message.ReceiptHandle)
+}
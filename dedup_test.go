@@ -0,0 +1,158 @@
+package ali_mns
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryDedupStore(t *testing.T) {
+	store := NewMemoryDedupStore(2)
+
+	state, err := store.Begin("a", time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, DedupNew, state)
+	state, _ = store.Begin("a", time.Minute)
+	assert.Equal(t, DedupInFlight, state)
+	assert.Nil(t, store.Complete("a", time.Minute))
+	state, _ = store.Begin("a", time.Minute)
+	assert.Equal(t, DedupDone, state)
+
+	// b is the least recently used when c comes in
+	store.Begin("b", time.Minute)
+	store.Begin("a", time.Minute)
+	store.Begin("c", time.Minute)
+	assert.Equal(t, 2, store.Len())
+	state, _ = store.Begin("b", time.Minute)
+	assert.Equal(t, DedupNew, state)
+
+	store.Begin("expiring", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	state, _ = store.Begin("expiring", time.Minute)
+	assert.Equal(t, DedupNew, state)
+
+	assert.Nil(t, store.Abort("expiring"))
+	state, _ = store.Begin("expiring", time.Minute)
+	assert.Equal(t, DedupNew, state)
+}
+
+func TestFileDedupStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ali_mns-dedup")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dedup.log")
+
+	store, err := OpenFileDedupStore(path)
+	assert.Nil(t, err)
+	for i := 0; i < 3000; i++ {
+		store.Begin(fmt.Sprintf("key-%d", i%10), time.Minute)
+		assert.Nil(t, store.Complete(fmt.Sprintf("key-%d", i%10), time.Minute))
+	}
+	store.Begin("aborted", time.Minute)
+	assert.Nil(t, store.Abort("aborted"))
+	store.Begin("in-flight", time.Minute)
+	assert.Nil(t, store.Close())
+
+	store, err = OpenFileDedupStore(path)
+	assert.Nil(t, err)
+	defer store.Close()
+
+	state, err := store.Begin("key-3", time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, DedupDone, state)
+	state, _ = store.Begin("in-flight", time.Minute)
+	assert.Equal(t, DedupInFlight, state)
+	state, _ = store.Begin("aborted", time.Minute)
+	assert.Equal(t, DedupNew, state)
+
+	// the log was compacted when reopened
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.True(t, len(data) < 4096)
+}
+
+func TestIdempotentConsumer(t *testing.T) {
+	emulator := NewMNSEmulator("", "")
+	manager := NewMNSQueueManager(emulator)
+	assert.Nil(t, manager.CreateSimpleQueue("test-queue"))
+	queue := NewMNSQueue("test-queue", emulator)
+
+	consumer := NewIdempotentConsumer(queue, IdempotentConsumerConfig{
+		Store: NewMemoryDedupStore(16),
+		Key: func(message MessageReceiveResponse) string {
+			return message.MessageBody
+		},
+	})
+
+	calls := 0
+	handler := func(MessageReceiveResponse) error {
+		calls++
+		return nil
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err := queue.SendMessage(MessageSendRequest{MessageBody: "order-1"})
+		assert.Nil(t, err)
+		message, err := receiveOne(queue)
+		assert.Nil(t, err)
+		assert.Nil(t, consumer.Handle(message, handler))
+	}
+	assert.Equal(t, 1, calls)
+
+	// a plain body is handled as it is
+	_, err := queue.SendMessage(MessageSendRequest{MessageBody: "idk=order-3;hello"})
+	assert.Nil(t, err)
+	message, err := receiveOne(queue)
+	assert.Nil(t, err)
+	assert.Nil(t, consumer.Handle(message, func(message MessageReceiveResponse) error {
+		assert.Equal(t, "idk=order-3;hello", message.MessageBody)
+		return nil
+	}))
+
+	attr, err := manager.GetQueueAttributes("test-queue")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), attr.ActiveMessages+attr.InactiveMessages)
+
+	// a duplicate received while the first is being handled is left alone
+	queue.SendMessage(MessageSendRequest{MessageBody: "order-2"})
+	queue.SendMessage(MessageSendRequest{MessageBody: "order-2"})
+	first, _ := receiveOne(queue)
+	second, _ := receiveOne(queue)
+	err = consumer.Handle(first, func(MessageReceiveResponse) error {
+		assert.True(t, IsMNSError(consumer.Handle(second, handler), ERR_MNS_MESSAGE_IN_FLIGHT))
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, calls)
+
+	attr, err = manager.GetQueueAttributes("test-queue")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), attr.InactiveMessages)
+}
+
+func TestIdempotentConsumerHandlerError(t *testing.T) {
+	emulator := NewMNSEmulator("", "")
+	assert.Nil(t, NewMNSQueueManager(emulator).CreateSimpleQueue("test-queue"))
+	queue := NewMNSQueue("test-queue", emulator)
+	consumer := NewIdempotentConsumer(queue, IdempotentConsumerConfig{Store: NewMemoryDedupStore(16)})
+
+	_, err := queue.SendMessage(MessageSendRequest{MessageBody: "hello"})
+	assert.Nil(t, err)
+	message, err := receiveOne(queue)
+	assert.Nil(t, err)
+
+	failure := fmt.Errorf("boom")
+	assert.Equal(t, failure, consumer.Handle(message, func(MessageReceiveResponse) error { return failure }))
+
+	handled := false
+	assert.Nil(t, consumer.Handle(message, func(MessageReceiveResponse) error {
+		handled = true
+		return nil
+	}))
+	assert.True(t, handled)
+}
@@ -0,0 +1,249 @@
+package ali_mns
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	emulatorXMLNS = "http://mns.aliyuncs.com/doc/v1/"
+
+	emulatorMaxRetNumber = 1000
+)
+
+var _ MNSClient = new(MNSEmulator)
+
+// MNSEmulator is an in-memory MNS service. It implements MNSClient, so queues,
+// topics and managers created on top of it work unchanged, and Handle speaks
+// the MNS REST protocol so it can also be served over http.
+type MNSEmulator struct {
+	accountId string
+	region    string
+
+	locker sync.Mutex
+	queues map[string]*emulatedQueue
+	topics map[string]*emulatedTopic
+	seq    uint64
+}
+
+type emulatorError struct {
+	status  int
+	code    string
+	message string
+}
+
+func newEmulatorError(status int, code string, format string, args ...interface{}) *emulatorError {
+	return &emulatorError{status: status, code: code, message: fmt.Sprintf(format, args...)}
+}
+
+type emulatorErrorXML struct {
+	XMLName   xml.Name `xml:"Error"`
+	Xmlns     string   `xml:"xmlns,attr"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	RequestId string   `xml:"RequestId"`
+	HostId    string   `xml:"HostId"`
+}
+
+func NewMNSEmulator(accountId, region string) *MNSEmulator {
+	if accountId == "" {
+		accountId = "1234567890"
+	}
+	if region == "" {
+		region = "cn-hangzhou"
+	}
+	return &MNSEmulator{
+		accountId: accountId,
+		region:    region,
+		queues:    make(map[string]*emulatedQueue),
+		topics:    make(map[string]*emulatedTopic),
+	}
+}
+
+func (p *MNSEmulator) getAccountID() (accountId string) {
+	return p.accountId
+}
+
+func (p *MNSEmulator) getRegion() (region string) {
+	return p.region
+}
+
+func (p *MNSEmulator) SetProxy(url string) {
+}
+
+// Endpoint returns the service url the emulated resources are reported under.
+func (p *MNSEmulator) Endpoint() string {
+	return fmt.Sprintf("http://%s.mns.%s.aliyuncs.com", p.accountId, p.region)
+}
+
+func (p *MNSEmulator) Send(method Method, headers map[string]string, message interface{}, resource string, opts ...Option) (*fasthttp.Response, error) {
+	body, err := marshalMessage(message)
+	if err != nil {
+		return nil, err
+	}
+
+	statusCode, respHeaders, respBody := p.Handle(method, resource, headers, body)
+
+	resp := fasthttp.AcquireResponse()
+	resp.SetStatusCode(statusCode)
+	for header, value := range respHeaders {
+		resp.Header.Set(header, value)
+	}
+	resp.SetBody(respBody)
+
+	return resp, nil
+}
+
+// Handle serves one MNS request, resource is the request uri without the
+// leading slash, e.g. "queues/test/messages?numOfMessages=16".
+func (p *MNSEmulator) Handle(method Method, resource string, headers map[string]string, body []byte) (statusCode int, respHeaders map[string]string, respBody []byte) {
+	requestId := p.nextID()
+
+	respHeaders = map[string]string{
+		headerKeyRequestID: requestId,
+		MQ_VERSION:         version,
+	}
+
+	path, query := splitResource(resource)
+
+	if headers == nil {
+		headers = map[string]string{}
+	}
+
+	statusCode, v, e := p.route(method, strings.Trim(path, "/"), query, headers, body)
+	if e != nil {
+		statusCode = e.status
+		v = emulatorErrorXML{
+			Xmlns:     emulatorXMLNS,
+			Code:      e.code,
+			Message:   e.message,
+			RequestId: requestId,
+			HostId:    p.Endpoint(),
+		}
+	}
+
+	if v != nil {
+		bXml, err := xml.Marshal(v)
+		if err != nil {
+			statusCode = http.StatusInternalServerError
+			bXml, _ = xml.Marshal(emulatorErrorXML{Xmlns: emulatorXMLNS, Code: "InternalError", Message: err.Error(), RequestId: requestId, HostId: p.Endpoint()})
+		}
+		respHeaders[CONTENT_TYPE] = "text/xml;charset=utf-8"
+		respBody = append([]byte(xml.Header), bXml...)
+	}
+
+	return
+}
+
+func (p *MNSEmulator) route(method Method, path string, query neturl.Values, headers map[string]string, body []byte) (int, interface{}, *emulatorError) {
+	segments := strings.Split(path, "/")
+
+	switch {
+	case len(segments) == 1 && segments[0] == "queues" && method == GET:
+		return p.listQueues(headers)
+	case len(segments) == 2 && segments[0] == "queues":
+		switch method {
+		case PUT:
+			return p.createQueue(segments[1], query.Get("metaoverride") == "true", body)
+		case GET:
+			return p.getQueueAttributes(segments[1])
+		case DELETE:
+			return p.deleteQueue(segments[1])
+		}
+	case len(segments) == 3 && segments[0] == "queues" && segments[2] == "messages":
+		switch method {
+		case POST:
+			return p.sendMessages(segments[1], body)
+		case GET:
+			return p.receiveMessages(segments[1], query)
+		case DELETE:
+			if query.Get("ReceiptHandle") != "" {
+				return p.deleteMessage(segments[1], query.Get("ReceiptHandle"))
+			}
+			return p.batchDeleteMessages(segments[1], body)
+		case PUT:
+			return p.changeMessageVisibility(segments[1], query)
+		}
+	case len(segments) == 1 && segments[0] == "topics" && method == GET:
+		return p.listTopics(headers)
+	case len(segments) == 2 && segments[0] == "topics":
+		switch method {
+		case PUT:
+			return p.createTopic(segments[1], query.Get("metaoverride") == "true", body)
+		case GET:
+			return p.getTopicAttributes(segments[1])
+		case DELETE:
+			return p.deleteTopic(segments[1])
+		}
+	case len(segments) == 3 && segments[0] == "topics" && segments[2] == "messages" && method == POST:
+		return p.publishMessage(segments[1], body)
+	case len(segments) == 3 && segments[0] == "topics" && segments[2] == "subscriptions" && method == GET:
+		return p.listSubscriptions(segments[1], headers)
+	case len(segments) == 4 && segments[0] == "topics" && segments[2] == "subscriptions":
+		switch method {
+		case PUT:
+			return p.subscribe(segments[1], segments[3], query.Get("metaoverride") == "true", body)
+		case GET:
+			return p.getSubscriptionAttributes(segments[1], segments[3])
+		case DELETE:
+			return p.unsubscribe(segments[1], segments[3])
+		}
+	}
+
+	return 0, nil, newEmulatorError(http.StatusBadRequest, "InvalidRequestURL", "unsupported request: %s /%s", method, path)
+}
+
+func (p *MNSEmulator) nextID() string {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	return p.nextIDLocked()
+}
+
+func (p *MNSEmulator) nextIDLocked() string {
+	p.seq++
+	return fmt.Sprintf("%016X%016X", time.Now().UnixNano(), p.seq)
+}
+
+// listNames pages through sorted names the way ListQueue and ListTopic expect,
+// the marker is the first name of the next page.
+func listNames(names []string, headers map[string]string) (page []string, nextMarker string, e *emulatorError) {
+	prefix := headers["x-mns-prefix"]
+	marker := headers["x-mns-marker"]
+
+	retNumber := emulatorMaxRetNumber
+	if v, exist := headers["x-mns-ret-number"]; exist {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > emulatorMaxRetNumber {
+			return nil, "", newEmulatorError(http.StatusBadRequest, "InvalidArgument", "x-mns-ret-number should be in range of (1~1000)")
+		}
+		retNumber = n
+	}
+
+	sort.Strings(names)
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) || name < marker {
+			continue
+		}
+		if len(page) == retNumber {
+			nextMarker = name
+			break
+		}
+		page = append(page, name)
+	}
+
+	return
+}
+
+func nowInMillis() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
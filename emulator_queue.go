@@ -0,0 +1,648 @@
+package ali_mns
+
+import (
+	"crypto/md5"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	emulatorMaxBatchSize       = 16
+	emulatorMaxBatchBytes      = 65536
+	emulatorMaxWaitSeconds     = 30
+	emulatorMaxDelaySeconds    = 60480
+	emulatorMaxVisibility      = 43200
+	emulatorMinPriority        = 1
+	emulatorMaxPriority        = 16
+	emulatorDefaultPriority    = 8
+	emulatorPollInterval       = 20 * time.Millisecond
+	emulatorDefaultMessageSize = 65536
+	emulatorDefaultRetention   = 345600
+	emulatorDefaultVisibility  = 30
+)
+
+type emulatedMessage struct {
+	id       string
+	body     string
+	bodyMD5  string
+	priority int64
+	seq      uint64
+
+	enqueueTime      int64
+	firstDequeueTime int64
+	nextVisibleTime  int64
+	dequeueCount     int64
+	receiptHandle    string
+}
+
+type emulatedQueue struct {
+	attr           CreateQueueRequest
+	createTime     int64
+	lastModifyTime int64
+	messages       map[string]*emulatedMessage
+
+	// notify is closed and replaced whenever messages are added
+	notify chan struct{}
+}
+
+type emulatorSendRequest struct {
+	XMLName      xml.Name `xml:"Message"`
+	MessageBody  string   `xml:"MessageBody"`
+	DelaySeconds *int64   `xml:"DelaySeconds"`
+	Priority     *int64   `xml:"Priority"`
+}
+
+type emulatorBatchSendRequest struct {
+	XMLName  xml.Name              `xml:"Messages"`
+	Messages []emulatorSendRequest `xml:"Message"`
+}
+
+type emulatorSendResponse struct {
+	XMLName        xml.Name `xml:"Message"`
+	Xmlns          string   `xml:"xmlns,attr,omitempty"`
+	ErrorCode      string   `xml:"ErrorCode,omitempty"`
+	ErrorMessage   string   `xml:"ErrorMessage,omitempty"`
+	MessageId      string   `xml:"MessageId,omitempty"`
+	MessageBodyMD5 string   `xml:"MessageBodyMD5,omitempty"`
+	ReceiptHandle  string   `xml:"ReceiptHandle,omitempty"`
+}
+
+type emulatorBatchSendResponse struct {
+	XMLName  xml.Name               `xml:"Messages"`
+	Xmlns    string                 `xml:"xmlns,attr"`
+	Messages []emulatorSendResponse `xml:"Message"`
+}
+
+type emulatorReceiveResponse struct {
+	XMLName          xml.Name `xml:"Message"`
+	Xmlns            string   `xml:"xmlns,attr,omitempty"`
+	MessageId        string   `xml:"MessageId"`
+	ReceiptHandle    string   `xml:"ReceiptHandle,omitempty"`
+	MessageBodyMD5   string   `xml:"MessageBodyMD5"`
+	MessageBody      string   `xml:"MessageBody"`
+	EnqueueTime      int64    `xml:"EnqueueTime"`
+	NextVisibleTime  int64    `xml:"NextVisibleTime,omitempty"`
+	FirstDequeueTime int64    `xml:"FirstDequeueTime,omitempty"`
+	DequeueCount     int64    `xml:"DequeueCount"`
+	Priority         int64    `xml:"Priority"`
+}
+
+type emulatorBatchReceiveResponse struct {
+	XMLName  xml.Name                  `xml:"Messages"`
+	Xmlns    string                    `xml:"xmlns,attr"`
+	Messages []emulatorReceiveResponse `xml:"Message"`
+}
+
+type emulatorDeleteError struct {
+	XMLName       xml.Name `xml:"Error"`
+	ErrorCode     string   `xml:"ErrorCode"`
+	ErrorMessage  string   `xml:"ErrorMessage"`
+	ReceiptHandle string   `xml:"ReceiptHandle"`
+}
+
+type emulatorBatchDeleteResponse struct {
+	XMLName xml.Name              `xml:"Errors"`
+	Xmlns   string                `xml:"xmlns,attr"`
+	Errors  []emulatorDeleteError `xml:"Error"`
+}
+
+type emulatorVisibilityResponse struct {
+	XMLName         xml.Name `xml:"ChangeVisibility"`
+	Xmlns           string   `xml:"xmlns,attr"`
+	ReceiptHandle   string   `xml:"ReceiptHandle"`
+	NextVisibleTime int64    `xml:"NextVisibleTime"`
+}
+
+type emulatorQueueAttribute struct {
+	XMLName                xml.Name `xml:"Queue"`
+	Xmlns                  string   `xml:"xmlns,attr"`
+	QueueName              string   `xml:"QueueName"`
+	CreateTime             int64    `xml:"CreateTime"`
+	LastModifyTime         int64    `xml:"LastModifyTime"`
+	DelaySeconds           int32    `xml:"DelaySeconds"`
+	MaxMessageSize         int32    `xml:"MaximumMessageSize"`
+	MessageRetentionPeriod int32    `xml:"MessageRetentionPeriod"`
+	VisibilityTimeout      int32    `xml:"VisibilityTimeout"`
+	PollingWaitSeconds     int32    `xml:"PollingWaitSeconds"`
+	ActiveMessages         int64    `xml:"ActiveMessages"`
+	InactiveMessages       int64    `xml:"InactiveMessages"`
+	DelayMessages          int64    `xml:"DelayMessages"`
+}
+
+type emulatorQueues struct {
+	XMLName    xml.Name `xml:"Queues"`
+	Xmlns      string   `xml:"xmlns,attr"`
+	Queues     []Queue  `xml:"Queue"`
+	NextMarker string   `xml:"NextMarker,omitempty"`
+}
+
+func normalizeQueueAttributes(attr CreateQueueRequest) CreateQueueRequest {
+	if attr.MaxMessageSize == 0 {
+		attr.MaxMessageSize = emulatorDefaultMessageSize
+	}
+	if attr.MessageRetentionPeriod == 0 {
+		attr.MessageRetentionPeriod = emulatorDefaultRetention
+	}
+	if attr.VisibilityTimeout == 0 {
+		attr.VisibilityTimeout = emulatorDefaultVisibility
+	}
+	attr.XMLName = xml.Name{}
+	return attr
+}
+
+func (p *MNSEmulator) createQueue(name string, override bool, body []byte) (int, interface{}, *emulatorError) {
+	attr := CreateQueueRequest{}
+	if len(body) > 0 {
+		if err := xml.Unmarshal(body, &attr); err != nil {
+			return 0, nil, newEmulatorError(http.StatusBadRequest, "MalformedXML", "%s", err)
+		}
+	}
+	attr = normalizeQueueAttributes(attr)
+
+	if err := checkAttributes(attr.DelaySeconds, attr.MaxMessageSize, attr.MessageRetentionPeriod,
+		attr.VisibilityTimeout, attr.PollingWaitSeconds); err != nil {
+		return 0, nil, newEmulatorError(http.StatusBadRequest, "InvalidArgument", "%s", err)
+	}
+
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	now := time.Now().Unix()
+	queue, exist := p.queues[name]
+
+	if override {
+		if !exist {
+			return 0, nil, newEmulatorError(http.StatusNotFound, "QueueNotExist", "The queue you provided is not exist.")
+		}
+		queue.attr = attr
+		queue.lastModifyTime = now
+		return http.StatusNoContent, nil, nil
+	}
+
+	if exist {
+		if queue.attr == attr {
+			return http.StatusNoContent, nil, nil
+		}
+		return 0, nil, newEmulatorError(http.StatusConflict, "QueueAlreadyExist", "The queue you want to create already exist.")
+	}
+
+	p.queues[name] = &emulatedQueue{
+		attr:           attr,
+		createTime:     now,
+		lastModifyTime: now,
+		messages:       make(map[string]*emulatedMessage),
+		notify:         make(chan struct{}),
+	}
+
+	return http.StatusCreated, nil, nil
+}
+
+func (p *MNSEmulator) getQueueAttributes(name string) (int, interface{}, *emulatorError) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	queue, e := p.getQueueLocked(name)
+	if e != nil {
+		return 0, nil, e
+	}
+
+	attr := emulatorQueueAttribute{
+		Xmlns:                  emulatorXMLNS,
+		QueueName:              name,
+		CreateTime:             queue.createTime,
+		LastModifyTime:         queue.lastModifyTime,
+		DelaySeconds:           queue.attr.DelaySeconds,
+		MaxMessageSize:         queue.attr.MaxMessageSize,
+		MessageRetentionPeriod: queue.attr.MessageRetentionPeriod,
+		VisibilityTimeout:      queue.attr.VisibilityTimeout,
+		PollingWaitSeconds:     queue.attr.PollingWaitSeconds,
+	}
+
+	now := nowInMillis()
+	for _, message := range queue.messages {
+		switch {
+		case message.nextVisibleTime <= now:
+			attr.ActiveMessages++
+		case message.dequeueCount == 0:
+			attr.DelayMessages++
+		default:
+			attr.InactiveMessages++
+		}
+	}
+
+	return http.StatusOK, attr, nil
+}
+
+func (p *MNSEmulator) deleteQueue(name string) (int, interface{}, *emulatorError) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if queue, exist := p.queues[name]; exist {
+		close(queue.notify)
+		delete(p.queues, name)
+	}
+
+	return http.StatusNoContent, nil, nil
+}
+
+func (p *MNSEmulator) listQueues(headers map[string]string) (int, interface{}, *emulatorError) {
+	p.locker.Lock()
+	names := make([]string, 0, len(p.queues))
+	for name := range p.queues {
+		names = append(names, name)
+	}
+	p.locker.Unlock()
+
+	page, nextMarker, e := listNames(names, headers)
+	if e != nil {
+		return 0, nil, e
+	}
+
+	queues := emulatorQueues{Xmlns: emulatorXMLNS, NextMarker: nextMarker}
+	for _, name := range page {
+		queues.Queues = append(queues.Queues, Queue{QueueURL: p.Endpoint() + "/queues/" + name})
+	}
+
+	return http.StatusOK, queues, nil
+}
+
+func (p *MNSEmulator) getQueueLocked(name string) (*emulatedQueue, *emulatorError) {
+	queue, exist := p.queues[name]
+	if !exist {
+		return nil, newEmulatorError(http.StatusNotFound, "QueueNotExist", "The queue you provided is not exist.")
+	}
+
+	// drop messages past the retention period
+	expireBefore := nowInMillis() - int64(queue.attr.MessageRetentionPeriod)*1000
+	for id, message := range queue.messages {
+		if message.enqueueTime < expireBefore {
+			delete(queue.messages, id)
+		}
+	}
+
+	return queue, nil
+}
+
+func xmlRootName(body []byte) string {
+	decoder := xml.NewDecoder(strings.NewReader(string(body)))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return ""
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local
+		}
+	}
+}
+
+func (p *MNSEmulator) sendMessages(name string, body []byte) (int, interface{}, *emulatorError) {
+	batch := xmlRootName(body) == "Messages"
+
+	requests := []emulatorSendRequest{}
+	if batch {
+		batchRequest := emulatorBatchSendRequest{}
+		if err := xml.Unmarshal(body, &batchRequest); err != nil {
+			return 0, nil, newEmulatorError(http.StatusBadRequest, "MalformedXML", "%s", err)
+		}
+		requests = batchRequest.Messages
+		if len(requests) == 0 || len(requests) > emulatorMaxBatchSize {
+			return 0, nil, newEmulatorError(http.StatusBadRequest, "InvalidArgument", "the count of batch messages should be in range of (1~%d)", emulatorMaxBatchSize)
+		}
+		size := 0
+		for _, request := range requests {
+			size += len(request.MessageBody)
+		}
+		if size > emulatorMaxBatchBytes {
+			return 0, nil, newEmulatorError(http.StatusBadRequest, "InvalidArgument", "the total size of batch messages should not exceed %d bytes", emulatorMaxBatchBytes)
+		}
+	} else {
+		request := emulatorSendRequest{}
+		if err := xml.Unmarshal(body, &request); err != nil {
+			return 0, nil, newEmulatorError(http.StatusBadRequest, "MalformedXML", "%s", err)
+		}
+		requests = append(requests, request)
+	}
+
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	queue, e := p.getQueueLocked(name)
+	if e != nil {
+		return 0, nil, e
+	}
+
+	failed := false
+	results := []emulatorSendResponse{}
+	for _, request := range requests {
+		result, e := p.enqueueLocked(queue, request)
+		if e != nil {
+			if !batch {
+				return 0, nil, e
+			}
+			failed = true
+			result = emulatorSendResponse{ErrorCode: e.code, ErrorMessage: e.message}
+		}
+		results = append(results, result)
+	}
+
+	close(queue.notify)
+	queue.notify = make(chan struct{})
+
+	if !batch {
+		results[0].Xmlns = emulatorXMLNS
+		return http.StatusCreated, results[0], nil
+	}
+
+	resp := emulatorBatchSendResponse{Xmlns: emulatorXMLNS, Messages: results}
+	if failed {
+		return http.StatusInternalServerError, resp, nil
+	}
+	return http.StatusCreated, resp, nil
+}
+
+func (p *MNSEmulator) enqueueLocked(queue *emulatedQueue, request emulatorSendRequest) (emulatorSendResponse, *emulatorError) {
+	if len(request.MessageBody) == 0 || len(request.MessageBody) > int(queue.attr.MaxMessageSize) {
+		return emulatorSendResponse{}, newEmulatorError(http.StatusBadRequest, "InvalidArgument", "message body size should be in range of (1~%d)", queue.attr.MaxMessageSize)
+	}
+
+	delaySeconds := int64(queue.attr.DelaySeconds)
+	if request.DelaySeconds != nil {
+		delaySeconds = *request.DelaySeconds
+	}
+	if delaySeconds < 0 || delaySeconds > emulatorMaxDelaySeconds {
+		return emulatorSendResponse{}, newEmulatorError(http.StatusBadRequest, "InvalidArgument", "delay seconds should be in range of (0~%d)", emulatorMaxDelaySeconds)
+	}
+
+	priority := int64(emulatorDefaultPriority)
+	if request.Priority != nil && *request.Priority != 0 {
+		priority = *request.Priority
+	}
+	if priority < emulatorMinPriority || priority > emulatorMaxPriority {
+		return emulatorSendResponse{}, newEmulatorError(http.StatusBadRequest, "InvalidArgument", "priority should be in range of (%d~%d)", emulatorMinPriority, emulatorMaxPriority)
+	}
+
+	now := nowInMillis()
+	message := &emulatedMessage{
+		id:              p.nextIDLocked(),
+		body:            request.MessageBody,
+		bodyMD5:         fmt.Sprintf("%X", md5.Sum([]byte(request.MessageBody))),
+		priority:        priority,
+		seq:             p.seq,
+		enqueueTime:     now,
+		nextVisibleTime: now + delaySeconds*1000,
+	}
+	queue.messages[message.id] = message
+
+	return emulatorSendResponse{MessageId: message.id, MessageBodyMD5: message.bodyMD5}, nil
+}
+
+func (p *MNSEmulator) receiveMessages(name string, query neturl.Values) (int, interface{}, *emulatorError) {
+	peekOnly := query.Get("peekonly") == "true"
+	batch := query.Get("numOfMessages") != ""
+
+	numOfMessages := 1
+	if batch {
+		n, err := strconv.Atoi(query.Get("numOfMessages"))
+		if err != nil || n < 1 || n > emulatorMaxBatchSize {
+			return 0, nil, newEmulatorError(http.StatusBadRequest, "InvalidArgument", "numOfMessages should be in range of (1~%d)", emulatorMaxBatchSize)
+		}
+		numOfMessages = n
+	}
+
+	waitSeconds := -1
+	if v := query.Get("waitseconds"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > emulatorMaxWaitSeconds {
+			return 0, nil, newEmulatorError(http.StatusBadRequest, "InvalidArgument", "waitseconds should be in range of (0~%d)", emulatorMaxWaitSeconds)
+		}
+		waitSeconds = n
+	}
+
+	var deadline time.Time
+	for {
+		p.locker.Lock()
+		queue, e := p.getQueueLocked(name)
+		if e != nil {
+			p.locker.Unlock()
+			return 0, nil, e
+		}
+
+		if deadline.IsZero() {
+			if waitSeconds < 0 {
+				waitSeconds = int(queue.attr.PollingWaitSeconds)
+			}
+			if peekOnly {
+				waitSeconds = 0
+			}
+			deadline = time.Now().Add(time.Duration(waitSeconds) * time.Second)
+		}
+
+		messages := p.dequeueLocked(queue, numOfMessages, peekOnly)
+		notify := queue.notify
+		p.locker.Unlock()
+
+		if len(messages) > 0 {
+			if !batch {
+				messages[0].Xmlns = emulatorXMLNS
+				return http.StatusOK, messages[0], nil
+			}
+			return http.StatusOK, emulatorBatchReceiveResponse{Xmlns: emulatorXMLNS, Messages: messages}, nil
+		}
+
+		remain := time.Until(deadline)
+		if remain <= 0 {
+			return 0, nil, newEmulatorError(http.StatusNotFound, "MessageNotExist", "Message not exist.")
+		}
+		if remain > emulatorPollInterval {
+			remain = emulatorPollInterval
+		}
+
+		select {
+		case <-notify:
+		case <-time.After(remain):
+		}
+	}
+}
+
+func (p *MNSEmulator) dequeueLocked(queue *emulatedQueue, numOfMessages int, peekOnly bool) (messages []emulatorReceiveResponse) {
+	now := nowInMillis()
+
+	visible := []*emulatedMessage{}
+	for _, message := range queue.messages {
+		if message.nextVisibleTime <= now {
+			visible = append(visible, message)
+		}
+	}
+
+	sort.Slice(visible, func(i, j int) bool {
+		if visible[i].priority != visible[j].priority {
+			return visible[i].priority < visible[j].priority
+		}
+		return visible[i].seq < visible[j].seq
+	})
+
+	if len(visible) > numOfMessages {
+		visible = visible[:numOfMessages]
+	}
+
+	for _, message := range visible {
+		if !peekOnly {
+			message.dequeueCount++
+			if message.firstDequeueTime == 0 {
+				message.firstDequeueTime = now
+			}
+			message.nextVisibleTime = now + int64(queue.attr.VisibilityTimeout)*1000
+			message.receiptHandle = p.newReceiptHandleLocked(message)
+		}
+
+		resp := emulatorReceiveResponse{
+			MessageId:        message.id,
+			MessageBodyMD5:   message.bodyMD5,
+			MessageBody:      message.body,
+			EnqueueTime:      message.enqueueTime,
+			FirstDequeueTime: message.firstDequeueTime,
+			DequeueCount:     message.dequeueCount,
+			Priority:         message.priority,
+		}
+		if !peekOnly {
+			resp.ReceiptHandle = message.receiptHandle
+			resp.NextVisibleTime = message.nextVisibleTime
+		}
+		messages = append(messages, resp)
+	}
+
+	return
+}
+
+func (p *MNSEmulator) newReceiptHandleLocked(message *emulatedMessage) string {
+	p.seq++
+	return fmt.Sprintf("%s-%X-%X", message.id, message.nextVisibleTime, p.seq)
+}
+
+// lookupReceiptHandleLocked finds the in flight message a receipt handle was
+// issued for, handles are invalidated by a newer receive, a visibility change
+// or the visibility timeout running out.
+func (p *MNSEmulator) lookupReceiptHandleLocked(queue *emulatedQueue, receiptHandle string) (*emulatedMessage, *emulatorError) {
+	i := strings.Index(receiptHandle, "-")
+	if i <= 0 {
+		return nil, newEmulatorError(http.StatusBadRequest, "ReceiptHandleError", "The receipt handle you provided is not valid.")
+	}
+
+	message, exist := queue.messages[receiptHandle[:i]]
+	if !exist {
+		return nil, newEmulatorError(http.StatusNotFound, "MessageNotExist", "Message not exist.")
+	}
+
+	if message.receiptHandle != receiptHandle || message.nextVisibleTime <= nowInMillis() {
+		return nil, newEmulatorError(http.StatusBadRequest, "ReceiptHandleError", "The receipt handle you provided is not valid.")
+	}
+
+	return message, nil
+}
+
+func (p *MNSEmulator) deleteMessage(name string, receiptHandle string) (int, interface{}, *emulatorError) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	queue, e := p.getQueueLocked(name)
+	if e != nil {
+		return 0, nil, e
+	}
+
+	message, e := p.lookupReceiptHandleLocked(queue, receiptHandle)
+	if e != nil {
+		return 0, nil, e
+	}
+
+	delete(queue.messages, message.id)
+
+	return http.StatusNoContent, nil, nil
+}
+
+func (p *MNSEmulator) batchDeleteMessages(name string, body []byte) (int, interface{}, *emulatorError) {
+	handles := ReceiptHandles{}
+	if err := xml.Unmarshal(body, &handles); err != nil {
+		return 0, nil, newEmulatorError(http.StatusBadRequest, "MalformedXML", "%s", err)
+	}
+
+	if len(handles.ReceiptHandles) == 0 || len(handles.ReceiptHandles) > emulatorMaxBatchSize {
+		return 0, nil, newEmulatorError(http.StatusBadRequest, "InvalidArgument", "the count of receipt handles should be in range of (1~%d)", emulatorMaxBatchSize)
+	}
+
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	queue, e := p.getQueueLocked(name)
+	if e != nil {
+		return 0, nil, e
+	}
+
+	resp := emulatorBatchDeleteResponse{Xmlns: emulatorXMLNS}
+	for _, receiptHandle := range handles.ReceiptHandles {
+		message, e := p.lookupReceiptHandleLocked(queue, receiptHandle)
+		if e != nil {
+			resp.Errors = append(resp.Errors, emulatorDeleteError{
+				ErrorCode:     e.code,
+				ErrorMessage:  e.message,
+				ReceiptHandle: receiptHandle,
+			})
+			continue
+		}
+		delete(queue.messages, message.id)
+	}
+
+	if len(resp.Errors) > 0 {
+		return http.StatusNotFound, resp, nil
+	}
+
+	return http.StatusNoContent, nil, nil
+}
+
+func (p *MNSEmulator) changeMessageVisibility(name string, query neturl.Values) (int, interface{}, *emulatorError) {
+	visibilityTimeout, err := strconv.Atoi(query.Get("VisibilityTimeout"))
+	if err != nil {
+		return 0, nil, newEmulatorError(http.StatusBadRequest, "MissingVisibilityTimeout", "VisibilityTimeout is missing or invalid.")
+	}
+	if visibilityTimeout < 0 || visibilityTimeout > emulatorMaxVisibility {
+		return 0, nil, newEmulatorError(http.StatusBadRequest, "InvalidArgument", "VisibilityTimeout should be in range of (0~%d)", emulatorMaxVisibility)
+	}
+
+	receiptHandle := query.Get("ReceiptHandle")
+	if receiptHandle == "" {
+		return 0, nil, newEmulatorError(http.StatusBadRequest, "MissingReceiptHandle", "ReceiptHandle is missing.")
+	}
+
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	queue, e := p.getQueueLocked(name)
+	if e != nil {
+		return 0, nil, e
+	}
+
+	message, e := p.lookupReceiptHandleLocked(queue, receiptHandle)
+	if e != nil {
+		return 0, nil, e
+	}
+
+	message.nextVisibleTime = nowInMillis() + int64(visibilityTimeout)*1000
+	message.receiptHandle = p.newReceiptHandleLocked(message)
+
+	if visibilityTimeout == 0 {
+		close(queue.notify)
+		queue.notify = make(chan struct{})
+	}
+
+	return http.StatusOK, emulatorVisibilityResponse{
+		Xmlns:           emulatorXMLNS,
+		ReceiptHandle:   message.receiptHandle,
+		NextVisibleTime: message.nextVisibleTime,
+	}, nil
+}
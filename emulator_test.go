@@ -0,0 +1,143 @@
+package ali_mns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func receiveOne(queue AliMNSQueue, waitseconds ...int64) (MessageReceiveResponse, error) {
+	respChan := make(chan MessageReceiveResponse, 1)
+	errChan := make(chan error, len(waitseconds)+1)
+	queue.ReceiveMessage(respChan, errChan, waitseconds...)
+	select {
+	case resp := <-respChan:
+		return resp, nil
+	default:
+		return MessageReceiveResponse{}, <-errChan
+	}
+}
+
+func TestEmulatorQueueManager(t *testing.T) {
+	emulator := NewMNSEmulator("", "")
+	manager := NewMNSQueueManager(emulator)
+
+	assert.Nil(t, manager.CreateSimpleQueue("test-queue"))
+	assert.True(t, IsMNSError(manager.CreateSimpleQueue("test-queue"), ERR_MNS_QUEUE_ALREADY_EXIST_AND_HAVE_SAME_ATTR))
+	assert.True(t, IsMNSError(manager.CreateQueue("test-queue", 0, 65536, 345600, 60, 0, 2), ERR_MNS_QUEUE_ALREADY_EXIST))
+
+	assert.Nil(t, manager.SetQueueAttributes("test-queue", 0, 65536, 345600, 60, 0, 2))
+	attr, err := manager.GetQueueAttributes("test-queue")
+	assert.Nil(t, err)
+	assert.Equal(t, "test-queue", attr.QueueName)
+	assert.Equal(t, int32(60), attr.VisibilityTimeout)
+
+	assert.Nil(t, manager.CreateSimpleQueue("other-queue"))
+	queues, err := manager.ListQueue("", 1, "")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(queues.Queues))
+	assert.Equal(t, "test-queue", queues.NextMarker)
+	assert.Equal(t, emulator.Endpoint()+"/queues/other-queue", queues.Queues[0].QueueURL)
+
+	assert.Nil(t, manager.DeleteQueue("test-queue"))
+	_, err = manager.GetQueueAttributes("test-queue")
+	assert.True(t, IsMNSError(err, ERR_MNS_QUEUE_NOT_EXIST))
+
+	topicManager := NewMNSTopicManager(emulator)
+	assert.Nil(t, topicManager.CreateSimpleTopic("test-topic"))
+	assert.True(t, IsMNSError(topicManager.CreateTopic("test-topic", 1024, true), ERR_MNS_TOPIC_ALREADY_EXIST))
+	topicAttr, err := topicManager.GetTopicAttributes("test-topic")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(65536), topicAttr.MaxMessageSize)
+}
+
+func TestEmulatorQueueMessages(t *testing.T) {
+	emulator := NewMNSEmulator("", "")
+	assert.Nil(t, NewMNSQueueManager(emulator).CreateQueue("test-queue", 0, 65536, 345600, 1, 0, 2))
+	queue := NewMNSQueue("test-queue", emulator)
+
+	_, err := receiveOne(queue)
+	assert.True(t, IsMNSError(err, ERR_MNS_MESSAGE_NOT_EXIST))
+
+	low, err := queue.SendMessage(MessageSendRequest{MessageBody: "low", Priority: 16})
+	assert.Nil(t, err)
+	assert.Equal(t, "53CCED8D281A1A0ACE3CB6594DAAA4F7", low.MessageBodyMD5)
+	_, err = queue.SendMessage(MessageSendRequest{MessageBody: "high", Priority: 1})
+	assert.Nil(t, err)
+	_, err = queue.SendMessage(MessageSendRequest{MessageBody: "delayed", DelaySeconds: 1})
+	assert.Nil(t, err)
+	_, err = queue.SendMessage(MessageSendRequest{MessageBody: "too late", DelaySeconds: emulatorMaxDelaySeconds + 1})
+	assert.NotNil(t, err)
+
+	resp, err := receiveOne(queue)
+	assert.Nil(t, err)
+	assert.Equal(t, "high", resp.MessageBody)
+	assert.Equal(t, int64(1), resp.DequeueCount)
+
+	// the visibility timeout expires and the old handle is invalidated
+	time.Sleep(1100 * time.Millisecond)
+	assert.True(t, IsMNSError(queue.DeleteMessage(resp.ReceiptHandle), ERR_MNS_RECEIPT_HANDLE_ERROR))
+
+	respChan := make(chan BatchMessageReceiveResponse, 1)
+	errChan := make(chan error, 1)
+	queue.BatchReceiveMessage(respChan, errChan, 16)
+	batch := <-respChan
+	assert.Equal(t, 3, len(batch.Messages))
+	assert.Equal(t, "high", batch.Messages[0].MessageBody)
+	assert.Equal(t, int64(2), batch.Messages[0].DequeueCount)
+	assert.Equal(t, "delayed", batch.Messages[1].MessageBody)
+	assert.Equal(t, "low", batch.Messages[2].MessageBody)
+
+	visibility, err := queue.ChangeMessageVisibility(batch.Messages[0].ReceiptHandle, 10)
+	assert.Nil(t, err)
+	assert.True(t, IsMNSError(queue.DeleteMessage(batch.Messages[0].ReceiptHandle), ERR_MNS_RECEIPT_HANDLE_ERROR))
+	assert.Nil(t, queue.DeleteMessage(visibility.ReceiptHandle))
+	assert.True(t, IsMNSError(queue.DeleteMessage(visibility.ReceiptHandle), ERR_MNS_MESSAGE_NOT_EXIST))
+
+	deleteResp, err := queue.BatchDeleteMessage(batch.Messages[1].ReceiptHandle, "invalid")
+	assert.True(t, IsMNSError(err, ERR_MNS_BATCH_OP_FAIL))
+	assert.Equal(t, 1, len(deleteResp.FailedMessages))
+	assert.Equal(t, "ReceiptHandleError", deleteResp.FailedMessages[0].ErrorCode)
+
+	_, err = queue.SendMessage(MessageSendRequest{MessageBody: "peek"})
+	assert.Nil(t, err)
+	peekChan := make(chan MessageReceiveResponse, 1)
+	queue.PeekMessage(peekChan, errChan)
+	peeked := <-peekChan
+	assert.Equal(t, "peek", peeked.MessageBody)
+	assert.Equal(t, "", peeked.ReceiptHandle)
+	assert.Equal(t, int64(0), peeked.DequeueCount)
+}
+
+func TestEmulatorLongPollingAndBatchLimits(t *testing.T) {
+	emulator := NewMNSEmulator("", "")
+	assert.Nil(t, NewMNSQueueManager(emulator).CreateSimpleQueue("test-queue"))
+	queue := NewMNSQueue("test-queue", emulator)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		queue.SendMessage(MessageSendRequest{MessageBody: "hello"})
+	}()
+
+	start := time.Now()
+	resp, err := receiveOne(queue, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", resp.MessageBody)
+	assert.True(t, time.Since(start) < 2*time.Second)
+
+	messages := make([]MessageSendRequest, 17)
+	for i := range messages {
+		messages[i] = MessageSendRequest{MessageBody: "hello"}
+	}
+	// the client splits larger batches, so send one past the limit directly
+	_, err = send(emulator, NewAliMNSDecoder(), POST, nil, BatchMessageSendRequest{Messages: messages}, "queues/test-queue/messages", nil)
+	assert.True(t, IsMNSError(err, ERR_MNS_INVALID_ARGUMENT))
+
+	sendResp, err := queue.BatchSendMessage(messages[:16]...)
+	assert.Nil(t, err)
+	assert.Equal(t, 16, len(sendResp.Messages))
+
+	_, err = NewMNSQueue("no-queue", emulator).SendMessage(MessageSendRequest{MessageBody: "hello"})
+	assert.True(t, IsMNSError(err, ERR_MNS_QUEUE_NOT_EXIST))
+}
@@ -0,0 +1,404 @@
+package ali_mns
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	emulatorTopicRetention = 86400
+)
+
+type emulatedTopic struct {
+	attr           CreateTopicRequest
+	createTime     int64
+	lastModifyTime int64
+	messageCount   int64
+	subscriptions  map[string]*emulatedSubscription
+}
+
+type emulatorTopicAttribute struct {
+	XMLName                xml.Name `xml:"Topic"`
+	Xmlns                  string   `xml:"xmlns,attr"`
+	TopicName              string   `xml:"TopicName"`
+	CreateTime             int64    `xml:"CreateTime"`
+	LastModifyTime         int64    `xml:"LastModifyTime"`
+	MaxMessageSize         int32    `xml:"MaximumMessageSize"`
+	MessageRetentionPeriod int32    `xml:"MessageRetentionPeriod"`
+	MessageCount           int64    `xml:"MessageCount"`
+	LoggingEnabled         bool     `xml:"LoggingEnabled"`
+}
+
+type emulatorTopics struct {
+	XMLName    xml.Name `xml:"Topics"`
+	Xmlns      string   `xml:"xmlns,attr"`
+	Topics     []Topic  `xml:"Topic"`
+	NextMarker string   `xml:"NextMarker,omitempty"`
+}
+
+func (p *MNSEmulator) createTopic(name string, override bool, body []byte) (int, interface{}, *emulatorError) {
+	attr := CreateTopicRequest{}
+	if len(body) > 0 {
+		if err := xml.Unmarshal(body, &attr); err != nil {
+			return 0, nil, newEmulatorError(http.StatusBadRequest, "MalformedXML", "%s", err)
+		}
+	}
+	attr.XMLName = xml.Name{}
+	if attr.MaxMessageSize == 0 {
+		attr.MaxMessageSize = emulatorDefaultMessageSize
+	}
+
+	if err := checkMaxMessageSize(attr.MaxMessageSize); err != nil {
+		return 0, nil, newEmulatorError(http.StatusBadRequest, "InvalidArgument", "%s", err)
+	}
+
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	now := time.Now().Unix()
+	topic, exist := p.topics[name]
+
+	if override {
+		if !exist {
+			return 0, nil, newEmulatorError(http.StatusNotFound, "TopicNotExist", "The topic you provided is not exist.")
+		}
+		topic.attr = attr
+		topic.lastModifyTime = now
+		return http.StatusNoContent, nil, nil
+	}
+
+	if exist {
+		if topic.attr == attr {
+			return http.StatusNoContent, nil, nil
+		}
+		return 0, nil, newEmulatorError(http.StatusConflict, "TopicAlreadyExist", "The topic you want to create already exist.")
+	}
+
+	p.topics[name] = &emulatedTopic{
+		attr:           attr,
+		createTime:     now,
+		lastModifyTime: now,
+		subscriptions:  make(map[string]*emulatedSubscription),
+	}
+
+	return http.StatusCreated, nil, nil
+}
+
+func (p *MNSEmulator) getTopicLocked(name string) (*emulatedTopic, *emulatorError) {
+	topic, exist := p.topics[name]
+	if !exist {
+		return nil, newEmulatorError(http.StatusNotFound, "TopicNotExist", "The topic you provided is not exist.")
+	}
+	return topic, nil
+}
+
+func (p *MNSEmulator) getTopicAttributes(name string) (int, interface{}, *emulatorError) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	topic, e := p.getTopicLocked(name)
+	if e != nil {
+		return 0, nil, e
+	}
+
+	return http.StatusOK, emulatorTopicAttribute{
+		Xmlns:                  emulatorXMLNS,
+		TopicName:              name,
+		CreateTime:             topic.createTime,
+		LastModifyTime:         topic.lastModifyTime,
+		MaxMessageSize:         topic.attr.MaxMessageSize,
+		MessageRetentionPeriod: emulatorTopicRetention,
+		MessageCount:           topic.messageCount,
+		LoggingEnabled:         topic.attr.LoggingEnabled,
+	}, nil
+}
+
+func (p *MNSEmulator) deleteTopic(name string) (int, interface{}, *emulatorError) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	delete(p.topics, name)
+
+	return http.StatusNoContent, nil, nil
+}
+
+func (p *MNSEmulator) listTopics(headers map[string]string) (int, interface{}, *emulatorError) {
+	p.locker.Lock()
+	names := make([]string, 0, len(p.topics))
+	for name := range p.topics {
+		names = append(names, name)
+	}
+	p.locker.Unlock()
+
+	page, nextMarker, e := listNames(names, headers)
+	if e != nil {
+		return 0, nil, e
+	}
+
+	topics := emulatorTopics{Xmlns: emulatorXMLNS, NextMarker: nextMarker}
+	for _, name := range page {
+		topics.Topics = append(topics.Topics, Topic{TopicURL: p.Endpoint() + "/topics/" + name})
+	}
+
+	return http.StatusOK, topics, nil
+}
+
+type emulatedSubscription struct {
+	request        MessageSubsribeRequest
+	createTime     int64
+	lastModifyTime int64
+}
+
+type emulatorSubscriptionAttribute struct {
+	XMLName             xml.Name                `xml:"Subscription"`
+	Xmlns               string                  `xml:"xmlns,attr"`
+	SubscriptionName    string                  `xml:"SubscriptionName"`
+	Subscriber          string                  `xml:"Subscriber"`
+	TopicOwner          string                  `xml:"TopicOwner"`
+	TopicName           string                  `xml:"TopicName"`
+	Endpoint            string                  `xml:"Endpoint"`
+	NotifyStrategy      NotifyStrategyType      `xml:"NotifyStrategy"`
+	NotifyContentFormat NotifyContentFormatType `xml:"NotifyContentFormat"`
+	FilterTag           string                  `xml:"FilterTag,omitempty"`
+	CreateTime          int64                   `xml:"CreateTime"`
+	LastModifyTime      int64                   `xml:"LastModifyTime"`
+}
+
+type emulatorSubscriptions struct {
+	XMLName       xml.Name       `xml:"Subscriptions"`
+	Xmlns         string         `xml:"xmlns,attr"`
+	Subscriptions []Subscription `xml:"Subscription"`
+	NextMarker    string         `xml:"NextMarker,omitempty"`
+}
+
+type emulatorPublishRequest struct {
+	XMLName     xml.Name `xml:"Message"`
+	MessageBody string   `xml:"MessageBody"`
+	MessageTag  string   `xml:"MessageTag"`
+}
+
+type emulatorNotification struct {
+	XMLName          xml.Name `xml:"Notification" json:"-"`
+	Xmlns            string   `xml:"xmlns,attr" json:"-"`
+	TopicOwner       string   `xml:"TopicOwner" json:"TopicOwner"`
+	TopicName        string   `xml:"TopicName" json:"TopicName"`
+	Subscriber       string   `xml:"Subscriber" json:"Subscriber"`
+	SubscriptionName string   `xml:"SubscriptionName" json:"SubscriptionName"`
+	MessageId        string   `xml:"MessageId" json:"MessageId"`
+	MessageMD5       string   `xml:"MessageMD5" json:"MessageMD5"`
+	MessageTag       string   `xml:"MessageTag,omitempty" json:"MessageTag,omitempty"`
+	Message          string   `xml:"Message" json:"Message"`
+	PublishTime      int64    `xml:"PublishTime" json:"PublishTime"`
+}
+
+func (p *MNSEmulator) subscribe(topicName, name string, override bool, body []byte) (int, interface{}, *emulatorError) {
+	request := MessageSubsribeRequest{}
+	if err := xml.Unmarshal(body, &request); err != nil {
+		return 0, nil, newEmulatorError(http.StatusBadRequest, "MalformedXML", "%s", err)
+	}
+	request.XMLName = xml.Name{}
+
+	if request.NotifyStrategy == "" {
+		request.NotifyStrategy = BACKOFF_RETRY
+	}
+	if request.NotifyContentFormat == "" {
+		request.NotifyContentFormat = XML
+	}
+
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	topic, e := p.getTopicLocked(topicName)
+	if e != nil {
+		return 0, nil, e
+	}
+
+	now := time.Now().Unix()
+	subscription, exist := topic.subscriptions[name]
+
+	if override {
+		if !exist {
+			return 0, nil, newEmulatorError(http.StatusNotFound, "SubscriptionNotExist", "The subscription you provided is not exist.")
+		}
+		subscription.request.NotifyStrategy = request.NotifyStrategy
+		subscription.lastModifyTime = now
+		return http.StatusNoContent, nil, nil
+	}
+
+	if request.Endpoint == "" {
+		return 0, nil, newEmulatorError(http.StatusBadRequest, "EndpointInvalid", "The endpoint you provided is not valid.")
+	}
+
+	if exist {
+		if subscription.request == request {
+			return http.StatusNoContent, nil, nil
+		}
+		return 0, nil, newEmulatorError(http.StatusConflict, "SubscriptionAlreadyExist", "The subscription you want to create already exist.")
+	}
+
+	topic.subscriptions[name] = &emulatedSubscription{
+		request:        request,
+		createTime:     now,
+		lastModifyTime: now,
+	}
+
+	return http.StatusCreated, nil, nil
+}
+
+func (p *MNSEmulator) getSubscriptionAttributes(topicName, name string) (int, interface{}, *emulatorError) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	topic, e := p.getTopicLocked(topicName)
+	if e != nil {
+		return 0, nil, e
+	}
+
+	subscription, exist := topic.subscriptions[name]
+	if !exist {
+		return 0, nil, newEmulatorError(http.StatusNotFound, "SubscriptionNotExist", "The subscription you provided is not exist.")
+	}
+
+	return http.StatusOK, emulatorSubscriptionAttribute{
+		Xmlns:               emulatorXMLNS,
+		SubscriptionName:    name,
+		Subscriber:          p.accountId,
+		TopicOwner:          p.accountId,
+		TopicName:           topicName,
+		Endpoint:            subscription.request.Endpoint,
+		NotifyStrategy:      subscription.request.NotifyStrategy,
+		NotifyContentFormat: subscription.request.NotifyContentFormat,
+		FilterTag:           subscription.request.FilterTag,
+		CreateTime:          subscription.createTime,
+		LastModifyTime:      subscription.lastModifyTime,
+	}, nil
+}
+
+func (p *MNSEmulator) unsubscribe(topicName, name string) (int, interface{}, *emulatorError) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	topic, e := p.getTopicLocked(topicName)
+	if e != nil {
+		return 0, nil, e
+	}
+
+	delete(topic.subscriptions, name)
+
+	return http.StatusNoContent, nil, nil
+}
+
+func (p *MNSEmulator) listSubscriptions(topicName string, headers map[string]string) (int, interface{}, *emulatorError) {
+	p.locker.Lock()
+	topic, e := p.getTopicLocked(topicName)
+	if e != nil {
+		p.locker.Unlock()
+		return 0, nil, e
+	}
+	names := make([]string, 0, len(topic.subscriptions))
+	for name := range topic.subscriptions {
+		names = append(names, name)
+	}
+	p.locker.Unlock()
+
+	page, nextMarker, e := listNames(names, headers)
+	if e != nil {
+		return 0, nil, e
+	}
+
+	subscriptions := emulatorSubscriptions{Xmlns: emulatorXMLNS, NextMarker: nextMarker}
+	for _, name := range page {
+		subscriptions.Subscriptions = append(subscriptions.Subscriptions, Subscription{
+			SubscriptionURL: p.Endpoint() + "/topics/" + topicName + "/subscriptions/" + name,
+		})
+	}
+
+	return http.StatusOK, subscriptions, nil
+}
+
+// publishMessage delivers the message to every subscription whose endpoint is
+// a queue of the emulator and whose FilterTag matches, other endpoints are
+// accepted but nothing is pushed to them.
+func (p *MNSEmulator) publishMessage(topicName string, body []byte) (int, interface{}, *emulatorError) {
+	request := emulatorPublishRequest{}
+	if err := xml.Unmarshal(body, &request); err != nil {
+		return 0, nil, newEmulatorError(http.StatusBadRequest, "MalformedXML", "%s", err)
+	}
+
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	topic, e := p.getTopicLocked(topicName)
+	if e != nil {
+		return 0, nil, e
+	}
+
+	if len(request.MessageBody) == 0 || len(request.MessageBody) > int(topic.attr.MaxMessageSize) {
+		return 0, nil, newEmulatorError(http.StatusBadRequest, "InvalidArgument", "message body size should be in range of (1~%d)", topic.attr.MaxMessageSize)
+	}
+
+	messageId := p.nextIDLocked()
+	messageMD5 := fmt.Sprintf("%X", md5.Sum([]byte(request.MessageBody)))
+	topic.messageCount++
+
+	for name, subscription := range topic.subscriptions {
+		if subscription.request.FilterTag != "" && subscription.request.FilterTag != request.MessageTag {
+			continue
+		}
+
+		queue, exist := p.queues[p.queueNameOfEndpoint(subscription.request.Endpoint)]
+		if !exist {
+			continue
+		}
+
+		notification := emulatorNotification{
+			Xmlns:            emulatorXMLNS,
+			TopicOwner:       p.accountId,
+			TopicName:        topicName,
+			Subscriber:       p.accountId,
+			SubscriptionName: name,
+			MessageId:        messageId,
+			MessageMD5:       messageMD5,
+			MessageTag:       request.MessageTag,
+			Message:          request.MessageBody,
+			PublishTime:      nowInMillis(),
+		}
+
+		queueBody := request.MessageBody
+		switch subscription.request.NotifyContentFormat {
+		case XML:
+			bXml, _ := xml.Marshal(notification)
+			queueBody = xml.Header + string(bXml)
+		case JSON:
+			bJson, _ := json.Marshal(notification)
+			queueBody = string(bJson)
+		}
+
+		if _, e := p.enqueueLocked(queue, emulatorSendRequest{MessageBody: queueBody}); e == nil {
+			close(queue.notify)
+			queue.notify = make(chan struct{})
+		}
+	}
+
+	return http.StatusCreated, emulatorSendResponse{
+		Xmlns:          emulatorXMLNS,
+		MessageId:      messageId,
+		MessageBodyMD5: messageMD5,
+	}, nil
+}
+
+// queueNameOfEndpoint parses acs:mns:{region}:{accountId}:queues/{name}.
+func (p *MNSEmulator) queueNameOfEndpoint(endpoint string) string {
+	pieces := strings.SplitN(endpoint, ":", 5)
+	if len(pieces) != 5 || pieces[0] != "acs" || pieces[1] != "mns" ||
+		pieces[2] != p.region || pieces[3] != p.accountId ||
+		!strings.HasPrefix(pieces[4], "queues/") {
+		return ""
+	}
+	return strings.TrimPrefix(pieces[4], "queues/")
+}
@@ -0,0 +1,319 @@
+package ali_mns
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gogap/errors"
+)
+
+// an encrypted body is in an envelope naming the cipher, with the json of an
+// encryptionEnvelope
+const encryptionMarker = envelopeMarker + "enc=aes-256-gcm;"
+
+// KeyProvider protects the data keys of encrypted messages. WrapKey
+// encrypts dataKey with the current master key and names it by keyID,
+// UnwrapKey returns ERR_MNS_UNKNOWN_KEY for key ids it does not have.
+type KeyProvider interface {
+	WrapKey(dataKey []byte) (keyID string, wrapped []byte, err error)
+	UnwrapKey(keyID string, wrapped []byte) (dataKey []byte, err error)
+}
+
+// LocalKeyring is a KeyProvider holding 32 byte master keys in memory. New
+// data keys are wrapped with the current key, the older keys are kept to
+// unwrap the messages still in flight after a rotation.
+type LocalKeyring struct {
+	locker  sync.RWMutex
+	keys    map[string]cipher.AEAD
+	current string
+}
+
+func NewLocalKeyring() *LocalKeyring {
+	return &LocalKeyring{keys: map[string]cipher.AEAD{}}
+}
+
+// AddKey adds a master key, the first one added becomes the current one.
+func (p *LocalKeyring) AddKey(keyID string, key []byte) error {
+	if keyID == "" {
+		return ERR_MNS_ENCRYPTION_FAILED.New(errors.Params{"err": "empty key id"})
+	}
+
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return ERR_MNS_ENCRYPTION_FAILED.New(errors.Params{"err": err})
+	}
+
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	p.keys[keyID] = aead
+	if p.current == "" {
+		p.current = keyID
+	}
+	return nil
+}
+
+// Rotate adds a master key and makes it the current one.
+func (p *LocalKeyring) Rotate(keyID string, key []byte) error {
+	if err := p.AddKey(keyID, key); err != nil {
+		return err
+	}
+
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	p.current = keyID
+	return nil
+}
+
+// RemoveKey forgets a retired master key, messages wrapped with it can no
+// longer be decrypted. The current key can not be removed.
+func (p *LocalKeyring) RemoveKey(keyID string) error {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if keyID == p.current {
+		return ERR_MNS_ENCRYPTION_FAILED.New(errors.Params{"err": "can not remove the current key " + keyID})
+	}
+	delete(p.keys, keyID)
+	return nil
+}
+
+// CurrentKeyID returns the id of the key new data keys are wrapped with.
+func (p *LocalKeyring) CurrentKeyID() string {
+	p.locker.RLock()
+	defer p.locker.RUnlock()
+	return p.current
+}
+
+func (p *LocalKeyring) WrapKey(dataKey []byte) (keyID string, wrapped []byte, err error) {
+	p.locker.RLock()
+	keyID = p.current
+	aead := p.keys[keyID]
+	p.locker.RUnlock()
+
+	if aead == nil {
+		return "", nil, ERR_MNS_ENCRYPTION_FAILED.New(errors.Params{"err": "keyring has no key"})
+	}
+
+	wrapped, err = sealAESGCM(aead, dataKey, []byte(keyID))
+	return
+}
+
+func (p *LocalKeyring) UnwrapKey(keyID string, wrapped []byte) (dataKey []byte, err error) {
+	p.locker.RLock()
+	aead := p.keys[keyID]
+	p.locker.RUnlock()
+
+	if aead == nil {
+		return nil, ERR_MNS_UNKNOWN_KEY.New(errors.Params{"kid": keyID})
+	}
+	return openAESGCM(aead, wrapped, []byte(keyID))
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("AES-256 key must be 32 bytes")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealAESGCM returns the nonce followed by the ciphertext.
+func sealAESGCM(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func openAESGCM(aead cipher.AEAD, sealed, additionalData []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additionalData)
+}
+
+// EncryptionConfig configures an encryption queue or topic. Received bodies
+// that are not encrypted fail unless AllowPlaintext is set, e.g. while the
+// producers are being migrated.
+type EncryptionConfig struct {
+	Keys           KeyProvider
+	AllowPlaintext bool
+}
+
+func (p EncryptionConfig) normalize() EncryptionConfig {
+	if p.Keys == nil {
+		panic("ali_mns: encryption key provider could not be nil")
+	}
+	return p
+}
+
+type encryptionEnvelope struct {
+	KeyID      string `json:"kid"`
+	WrappedKey []byte `json:"key"`
+	Data       []byte `json:"data"`
+}
+
+type encryptor struct {
+	config EncryptionConfig
+}
+
+// encrypt seals body with a new data key wrapped by the key provider.
+func (p encryptor) encrypt(body *string) error {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return ERR_MNS_ENCRYPTION_FAILED.New(errors.Params{"err": err})
+	}
+
+	keyID, wrapped, err := p.config.Keys.WrapKey(dataKey)
+	if err != nil {
+		return ERR_MNS_ENCRYPTION_FAILED.New(errors.Params{"err": err})
+	}
+
+	aead, err := newAESGCM(dataKey)
+	if err != nil {
+		return ERR_MNS_ENCRYPTION_FAILED.New(errors.Params{"err": err})
+	}
+	data, err := sealAESGCM(aead, []byte(*body), nil)
+	if err != nil {
+		return ERR_MNS_ENCRYPTION_FAILED.New(errors.Params{"err": err})
+	}
+
+	envelope, _ := json.Marshal(encryptionEnvelope{KeyID: keyID, WrappedKey: wrapped, Data: data})
+	*body = encryptionMarker + string(envelope)
+	return nil
+}
+
+func (p encryptor) decrypt(body *string) error {
+	if !strings.HasPrefix(*body, encryptionMarker) {
+		if p.config.AllowPlaintext {
+			return nil
+		}
+		return ERR_MNS_DECRYPTION_FAILED.New(errors.Params{"kid": "", "err": "message is not encrypted"})
+	}
+
+	envelope := encryptionEnvelope{}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(*body, encryptionMarker)), &envelope); err != nil {
+		return ERR_MNS_DECRYPTION_FAILED.New(errors.Params{"kid": "", "err": err})
+	}
+
+	dataKey, err := p.config.Keys.UnwrapKey(envelope.KeyID, envelope.WrappedKey)
+	if IsMNSError(err, ERR_MNS_UNKNOWN_KEY) {
+		return err
+	} else if err != nil {
+		return ERR_MNS_DECRYPTION_FAILED.New(errors.Params{"kid": envelope.KeyID, "err": err})
+	}
+
+	aead, err := newAESGCM(dataKey)
+	if err != nil {
+		return ERR_MNS_DECRYPTION_FAILED.New(errors.Params{"kid": envelope.KeyID, "err": err})
+	}
+	plaintext, err := openAESGCM(aead, envelope.Data, nil)
+	if err != nil {
+		return ERR_MNS_DECRYPTION_FAILED.New(errors.Params{"kid": envelope.KeyID, "err": err})
+	}
+
+	*body = string(plaintext)
+	return nil
+}
+
+func (p encryptor) decryptBatch(batch *BatchMessageReceiveResponse) error {
+	for i := range batch.Messages {
+		if err := p.decrypt(&batch.Messages[i].MessageBody); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type encryptionQueue struct {
+	AliMNSQueue
+	encryptor
+}
+
+// NewEncryptionQueue wraps queue to encrypt the bodies sent with AES-256-GCM
+// under a data key of their own and decrypt the bodies received. Wrap it in
+// a compression queue to compress before encrypting.
+func NewEncryptionQueue(queue AliMNSQueue, config EncryptionConfig) AliMNSQueue {
+	return &encryptionQueue{
+		AliMNSQueue: queue,
+		encryptor:   encryptor{config: config.normalize()},
+	}
+}
+
+func (p *encryptionQueue) SendMessage(message MessageSendRequest, opts ...Option) (resp MessageSendResponse, err error) {
+	if err = p.encrypt(&message.MessageBody); err != nil {
+		return
+	}
+	return p.AliMNSQueue.SendMessage(message, opts...)
+}
+
+func (p *encryptionQueue) BatchSendMessage(messages ...MessageSendRequest) (resp BatchMessageSendResponse, err error) {
+	encrypted := make([]MessageSendRequest, len(messages))
+	for i, message := range messages {
+		if err = p.encrypt(&message.MessageBody); err != nil {
+			return
+		}
+		encrypted[i] = message
+	}
+	return p.AliMNSQueue.BatchSendMessage(encrypted...)
+}
+
+func (p *encryptionQueue) ReceiveMessage(respChan chan MessageReceiveResponse, errChan chan error, waitseconds ...int64) {
+	relayReceive(func(innerChan chan MessageReceiveResponse) {
+		p.AliMNSQueue.ReceiveMessage(innerChan, errChan, waitseconds...)
+	}, respChan, errChan, func(message *MessageReceiveResponse) error {
+		return p.decrypt(&message.MessageBody)
+	})
+}
+
+func (p *encryptionQueue) PeekMessage(respChan chan MessageReceiveResponse, errChan chan error) {
+	relayReceive(func(innerChan chan MessageReceiveResponse) {
+		p.AliMNSQueue.PeekMessage(innerChan, errChan)
+	}, respChan, errChan, func(message *MessageReceiveResponse) error {
+		return p.decrypt(&message.MessageBody)
+	})
+}
+
+func (p *encryptionQueue) BatchReceiveMessage(respChan chan BatchMessageReceiveResponse, errChan chan error, numOfMessages int32, waitseconds ...int64) {
+	relayBatchReceive(func(innerChan chan BatchMessageReceiveResponse) {
+		p.AliMNSQueue.BatchReceiveMessage(innerChan, errChan, numOfMessages, waitseconds...)
+	}, respChan, errChan, p.decryptBatch)
+}
+
+func (p *encryptionQueue) BatchPeekMessage(respChan chan BatchMessageReceiveResponse, errChan chan error, numOfMessages int32) {
+	relayBatchReceive(func(innerChan chan BatchMessageReceiveResponse) {
+		p.AliMNSQueue.BatchPeekMessage(innerChan, errChan, numOfMessages)
+	}, respChan, errChan, p.decryptBatch)
+}
+
+type encryptionTopic struct {
+	AliMNSTopic
+	encryptor
+}
+
+// NewEncryptionTopic wraps topic to encrypt the bodies published, the
+// subscribed queues must notify in SIMPLIFIED format and be read through
+// NewEncryptionQueue with the same keys.
+func NewEncryptionTopic(topic AliMNSTopic, config EncryptionConfig) AliMNSTopic {
+	return &encryptionTopic{
+		AliMNSTopic: topic,
+		encryptor:   encryptor{config: config.normalize()},
+	}
+}
+
+func (p *encryptionTopic) PublishMessage(message MessagePublishRequest) (resp MessageSendResponse, err error) {
+	if err = p.encrypt(&message.MessageBody); err != nil {
+		return
+	}
+	return p.AliMNSTopic.PublishMessage(message)
+}
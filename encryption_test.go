@@ -0,0 +1,115 @@
+package ali_mns
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func TestLocalKeyring(t *testing.T) {
+	keyring := NewLocalKeyring()
+	_, _, err := keyring.WrapKey(testKey(0))
+	assert.True(t, IsMNSError(err, ERR_MNS_ENCRYPTION_FAILED))
+	assert.True(t, IsMNSError(keyring.AddKey("short", []byte("too short")), ERR_MNS_ENCRYPTION_FAILED))
+
+	assert.Nil(t, keyring.AddKey("k1", testKey(1)))
+	assert.Nil(t, keyring.AddKey("k2", testKey(2)))
+	assert.Equal(t, "k1", keyring.CurrentKeyID())
+
+	keyID, wrapped, err := keyring.WrapKey(testKey(9))
+	assert.Nil(t, err)
+	assert.Equal(t, "k1", keyID)
+
+	assert.Nil(t, keyring.Rotate("k3", testKey(3)))
+	assert.True(t, IsMNSError(keyring.RemoveKey("k3"), ERR_MNS_ENCRYPTION_FAILED))
+
+	dataKey, err := keyring.UnwrapKey("k1", wrapped)
+	assert.Nil(t, err)
+	assert.Equal(t, testKey(9), dataKey)
+
+	// the key id is authenticated with the wrapped key
+	_, err = keyring.UnwrapKey("k2", wrapped)
+	assert.NotNil(t, err)
+
+	assert.Nil(t, keyring.RemoveKey("k1"))
+	_, err = keyring.UnwrapKey("k1", wrapped)
+	assert.True(t, IsMNSError(err, ERR_MNS_UNKNOWN_KEY))
+}
+
+func TestEncryptionQueue(t *testing.T) {
+	emulator := NewMNSEmulator("", "")
+	assert.Nil(t, NewMNSQueueManager(emulator).CreateSimpleQueue("test-queue"))
+	raw := NewMNSQueue("test-queue", emulator)
+
+	producerKeys := NewLocalKeyring()
+	assert.Nil(t, producerKeys.AddKey("k1", testKey(1)))
+	consumerKeys := NewLocalKeyring()
+	assert.Nil(t, consumerKeys.AddKey("k1", testKey(1)))
+	assert.Nil(t, consumerKeys.AddKey("k2", testKey(2)))
+
+	producer := NewEncryptionQueue(raw, EncryptionConfig{Keys: producerKeys})
+	consumer := NewEncryptionQueue(raw, EncryptionConfig{Keys: consumerKeys})
+
+	_, err := producer.SendMessage(MessageSendRequest{MessageBody: "card 4111-1111"})
+	assert.Nil(t, err)
+	assert.Nil(t, producerKeys.Rotate("k2", testKey(2)))
+	_, err = producer.BatchSendMessage(MessageSendRequest{MessageBody: "after rotation"})
+	assert.Nil(t, err)
+
+	peekChan := make(chan BatchMessageReceiveResponse, 1)
+	raw.BatchPeekMessage(peekChan, make(chan error, 1), 16)
+	for _, message := range (<-peekChan).Messages {
+		assert.True(t, strings.HasPrefix(message.MessageBody, encryptionMarker))
+		assert.NotContains(t, message.MessageBody, "card")
+	}
+
+	respChan := make(chan BatchMessageReceiveResponse, 1)
+	consumer.BatchReceiveMessage(respChan, make(chan error, 1), 16)
+	var bodies []string
+	for _, message := range (<-respChan).Messages {
+		bodies = append(bodies, message.MessageBody)
+	}
+	assert.ElementsMatch(t, []string{"card 4111-1111", "after rotation"}, bodies)
+}
+
+func TestEncryptionFailures(t *testing.T) {
+	keys := NewLocalKeyring()
+	assert.Nil(t, keys.AddKey("k1", testKey(1)))
+	p := encryptor{config: EncryptionConfig{Keys: keys}.normalize()}
+
+	body := "secret"
+	assert.Nil(t, p.encrypt(&body))
+
+	// flip a byte of the ciphertext
+	envelope := encryptionEnvelope{}
+	assert.Nil(t, json.Unmarshal([]byte(strings.TrimPrefix(body, encryptionMarker)), &envelope))
+	envelope.Data[len(envelope.Data)-1] ^= 0xff
+	tampered, _ := json.Marshal(envelope)
+	tamperedBody := encryptionMarker + string(tampered)
+	assert.True(t, IsMNSError(p.decrypt(&tamperedBody), ERR_MNS_DECRYPTION_FAILED))
+
+	otherKeys := NewLocalKeyring()
+	assert.Nil(t, otherKeys.AddKey("k9", testKey(9)))
+	other := encryptor{config: EncryptionConfig{Keys: otherKeys}.normalize()}
+	unknownBody := body
+	assert.True(t, IsMNSError(other.decrypt(&unknownBody), ERR_MNS_UNKNOWN_KEY))
+
+	plain := "not encrypted"
+	assert.True(t, IsMNSError(p.decrypt(&plain), ERR_MNS_DECRYPTION_FAILED))
+	p.config.AllowPlaintext = true
+	assert.Nil(t, p.decrypt(&plain))
+	assert.Equal(t, "not encrypted", plain)
+	plain = `enc=aes-256-gcm;{"kid":"k1"}`
+	assert.Nil(t, p.decrypt(&plain))
+	assert.Equal(t, `enc=aes-256-gcm;{"kid":"k1"}`, plain)
+
+	assert.Nil(t, p.decrypt(&body))
+	assert.Equal(t, "secret", body)
+}
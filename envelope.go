@@ -0,0 +1,45 @@
+package ali_mns
+
+import (
+	"strings"
+)
+
+// The bodies this package adds metadata to are envelopes: envelopeMarker,
+// the name of the feature, '=', a value without ';', ';' and the payload,
+// e.g. "mns:ct=json;{...}". Envelopes nest, the payload of one may be
+// another.
+//
+// Bodies starting with envelopeMarker are reserved. A wrapper that sends a
+// body as is but finds it starting with its own envelope name seals it with
+// an empty value, which it strips again on receive, so that a plain body is
+// never taken for its envelope.
+const envelopeMarker = "mns:"
+
+// sealEnvelope wraps payload in an envelope named name.
+func sealEnvelope(name, value, payload string) string {
+	return envelopeMarker + name + "=" + value + ";" + payload
+}
+
+// openEnvelope returns the value and payload of an envelope named name, ok
+// is false for any other body.
+func openEnvelope(body, name string) (value, payload string, ok bool) {
+	prefix := envelopeMarker + name + "="
+	if !strings.HasPrefix(body, prefix) {
+		return
+	}
+
+	end := strings.Index(body[len(prefix):], ";")
+	if end < 0 {
+		return
+	}
+	return body[len(prefix) : len(prefix)+end], body[len(prefix)+end+1:], true
+}
+
+// escapeEnvelope returns body to be sent as is by the wrapper of envelopes
+// named name.
+func escapeEnvelope(body, name string) string {
+	if strings.HasPrefix(body, envelopeMarker+name+"=") {
+		return sealEnvelope(name, "", body)
+	}
+	return body
+}
@@ -0,0 +1,34 @@
+package ali_mns
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvelope(t *testing.T) {
+	body := sealEnvelope("ct", "json", "mns:at=1;{}")
+	assert.Equal(t, "mns:ct=json;mns:at=1;{}", body)
+
+	value, payload, ok := openEnvelope(body, "ct")
+	assert.True(t, ok)
+	assert.Equal(t, "json", value)
+	assert.Equal(t, "mns:at=1;{}", payload)
+
+	for _, other := range []string{"ct=json;{}", "mns:ce=gzip;AAAA", "mns:ct=json", "mns:ctx=1;{}"} {
+		_, _, ok = openEnvelope(other, "ct")
+		assert.False(t, ok, other)
+	}
+}
+
+func TestEscapeEnvelope(t *testing.T) {
+	assert.Equal(t, "hello", escapeEnvelope("hello", "at"))
+	// envelopes of other features are left alone
+	assert.Equal(t, "mns:ct=json;{}", escapeEnvelope("mns:ct=json;{}", "at"))
+
+	escaped := escapeEnvelope("mns:at=1;plain", "at")
+	value, payload, ok := openEnvelope(escaped, "at")
+	assert.True(t, ok)
+	assert.Equal(t, "", value)
+	assert.Equal(t, "mns:at=1;plain", payload)
+}
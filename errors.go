@@ -1,6 +1,8 @@
 package ali_mns
 
 import (
+	"sync"
+
 	"github.com/gogap/errors"
 )
 
@@ -78,7 +80,86 @@ var (
 	ERR_MNS_RET_NUMBER_RANGE_ERROR                 = errors.TN(ALI_MNS_ERR_NS, 132, "list param of ret number is not in range of (1~1000)")
 	ERR_MNS_QUEUE_ALREADY_EXIST_AND_HAVE_SAME_ATTR = errors.TN(ALI_MNS_ERR_NS, 133, "mns queue already exist, and the attribute is the same, queue name: {{.name}}")
 	ERR_MNS_BATCH_OP_FAIL                          = errors.TN(ALI_MNS_ERR_NS, 136, "mns queue batch operation fail")
+	ERR_MNS_CIRCUIT_BREAKER_OPEN                   = errors.TN(ALI_MNS_ERR_NS, 137, "circuit breaker is {{.state}}, request rejected, resource: {{.resource}}")
+	ERR_MNS_VCR_UNMATCHED_REQUEST                  = errors.TN(ALI_MNS_ERR_NS, 138, "vcr: no recorded response for {{.method}} {{.resource}}")
+	ERR_MNS_VCR_RECORD_FAILED                      = errors.TN(ALI_MNS_ERR_NS, 139, "vcr: record request failed, {{.err}}")
+	ERR_MNS_VCR_INVALID_CASSETTE                   = errors.TN(ALI_MNS_ERR_NS, 140, "vcr: invalid cassette line {{.line}}, {{.err}}")
+	ERR_MNS_BATCH_ENTRY_NOT_SENT                   = errors.TN(ALI_MNS_ERR_NS, 141, "batch entry {{.index}} was not sent")
+	ERR_MNS_PRODUCER_CLOSED                        = errors.TN(ALI_MNS_ERR_NS, 142, "async producer of queue {{.queue}} is closed")
+	ERR_MNS_PRODUCER_BUFFER_FULL                   = errors.TN(ALI_MNS_ERR_NS, 143, "async producer buffer of queue {{.queue}} is full, size: {{.size}}")
+	ERR_MNS_ACKER_CLOSED                           = errors.TN(ALI_MNS_ERR_NS, 144, "acker of queue {{.queue}} is closed")
+	ERR_MNS_RECEIPT_HANDLE_EXPIRED                 = errors.TN(ALI_MNS_ERR_NS, 145, "receipt handle {{.handle}} expired at {{.time}} before it was deleted")
+	ERR_MNS_CLAIM_CHECK_FAILED                     = errors.TN(ALI_MNS_ERR_NS, 146, "claim check of blob {{.key}} failed, {{.err}}")
+	ERR_MNS_BLOB_NOT_FOUND                         = errors.TN(ALI_MNS_ERR_NS, 147, "blob {{.key}} not found")
+	ERR_MNS_UNKNOWN_CODEC                          = errors.TN(ALI_MNS_ERR_NS, 148, "unknown codec of content type {{.type}}")
+	ERR_MNS_CODEC_FAILED                           = errors.TN(ALI_MNS_ERR_NS, 149, "{{.type}} codec failed, {{.err}}")
+	ERR_MNS_COMPRESSION_FAILED                     = errors.TN(ALI_MNS_ERR_NS, 150, "{{.algorithm}} compression failed, {{.err}}")
+	ERR_MNS_DECOMPRESSED_TOO_LARGE                 = errors.TN(ALI_MNS_ERR_NS, 151, "decompressed message body exceeds {{.max}} bytes")
+	ERR_MNS_ENCRYPTION_FAILED                      = errors.TN(ALI_MNS_ERR_NS, 152, "message encryption failed, {{.err}}")
+	ERR_MNS_DECRYPTION_FAILED                      = errors.TN(ALI_MNS_ERR_NS, 153, "message decryption with key {{.kid}} failed, {{.err}}")
+	ERR_MNS_UNKNOWN_KEY                            = errors.TN(ALI_MNS_ERR_NS, 154, "unknown encryption key {{.kid}}")
+	ERR_MNS_MESSAGE_CORRUPTED                      = errors.TN(ALI_MNS_ERR_NS, 155, "body md5 of message {{.id}} is {{.actual}}, expected {{.expected}}")
+	ERR_MNS_MESSAGE_IN_FLIGHT                      = errors.TN(ALI_MNS_ERR_NS, 156, "message {{.key}} is being handled by another consumer")
+	ERR_MNS_DEDUP_STORE_FAILED                     = errors.TN(ALI_MNS_ERR_NS, 157, "dedup store failed, {{.err}}")
+	ERR_MNS_INVALID_IDEMPOTENCY_KEY                = errors.TN(ALI_MNS_ERR_NS, 158, "idempotency key {{.key}} should be non-empty and without ';'")
+	ERR_MNS_INVALID_ORDERING_KEY                   = errors.TN(ALI_MNS_ERR_NS, 159, "ordering key {{.key}} should be non-empty and without ';', sequence {{.seq}} should be positive")
+	ERR_MNS_CONSUMER_CLOSED                        = errors.TN(ALI_MNS_ERR_NS, 160, "ordered consumer of queue {{.queue}} is closed")
+	ERR_MNS_INVALID_EXPORT_LINE                    = errors.TN(ALI_MNS_ERR_NS, 161, "invalid exported message at line {{.line}}, {{.err}}")
+	ERR_MNS_MESSAGE_SETTLED                        = errors.TN(ALI_MNS_ERR_NS, 162, "message {{.id}} was already acked or nacked")
 
 	// ERR_MNS_SUBSRIPTION_NAME_LENGTH_ERROR: discarded because of  typo
 	ERR_MNS_SUBSRIPTION_NAME_LENGTH_ERROR = ERR_MNS_SUBSCRIPTION_NAME_LENGTH_ERROR
 )
+
+var (
+	errTemplateCodes = sync.Map{}
+)
+
+func templateCode(tmpl errors.ErrCodeTemplate) uint64 {
+	if code, exist := errTemplateCodes.Load(tmpl); exist {
+		return code.(uint64)
+	}
+	code := tmpl.New().Code()
+	errTemplateCodes.Store(tmpl, code)
+	return code
+}
+
+// IsMNSError reports whether err was created from tmpl, both for errors returned
+// by the default decoders and for the raw ErrorResponse of the *ErrResp decoders.
+func IsMNSError(err error, tmpl errors.ErrCodeTemplate) bool {
+	if err == nil || tmpl == nil {
+		return false
+	}
+
+	switch e := err.(type) {
+	case ErrorResponse:
+		return errMapping[e.Code] == tmpl
+	case *ErrorResponse:
+		return e != nil && errMapping[e.Code] == tmpl
+	case errors.ErrCode:
+		return e.Namespace() == ALI_MNS_ERR_NS && e.Code() == templateCode(tmpl)
+	}
+
+	return false
+}
+
+// MNSErrorCode returns the service error code, e.g. "QueueNotExist", of an
+// error returned by this package, or "" if err is not a service error.
+func MNSErrorCode(err error) string {
+	switch e := err.(type) {
+	case ErrorResponse:
+		return e.Code
+	case *ErrorResponse:
+		if e != nil {
+			return e.Code
+		}
+	}
+
+	for code, tmpl := range errMapping {
+		if IsMNSError(err, tmpl) {
+			return code
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,188 @@
+package ali_mns
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/gogap/errors"
+)
+
+// ExportedMessage is one line of a file written by ExportQueue.
+type ExportedMessage struct {
+	MessageId    string `json:"message_id"`
+	MessageBody  string `json:"body"`
+	EnqueueTime  int64  `json:"enqueue_time"`
+	DequeueCount int64  `json:"dequeue_count"`
+	Priority     int64  `json:"priority"`
+}
+
+// ExportOptions configures ExportQueue. Delete removes the exported messages
+// from the queue, otherwise they are released once the export ends.
+// MaxCount stops the export after that many messages, zero exports all.
+type ExportOptions struct {
+	Delete   bool
+	MaxCount int64
+}
+
+// ExportQueue writes the visible messages of queue to w as JSON Lines, and
+// stops when none is left. Delayed messages and the ones in flight to other
+// consumers are not in the snapshot. A message is deleted only after its line
+// was written, one whose delete the service refuses is released instead.
+// Released messages stay invisible until the export ends, then are made
+// visible again with ChangeMessageVisibility; one received again in between
+// because its visibility timeout passed is written once.
+func ExportQueue(ctx context.Context, queue AliMNSQueue, w io.Writer, opts ExportOptions) (exported int64, err error) {
+	encoder := json.NewEncoder(w)
+
+	// receipt handles of the messages to release, by MessageId
+	held := map[string]string{}
+	defer func() {
+		for _, receiptHandle := range held {
+			if _, e := queue.ChangeMessageVisibility(receiptHandle, 0); e != nil && err == nil {
+				err = e
+			}
+		}
+	}()
+
+	for {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+
+		size := int64(batchMaxMessages)
+		if opts.MaxCount > 0 {
+			if left := opts.MaxCount - exported; left <= 0 {
+				return
+			} else if left < size {
+				size = left
+			}
+		}
+
+		respChan := make(chan BatchMessageReceiveResponse, 1)
+		errChan := make(chan error, 1)
+		queue.BatchReceiveMessage(respChan, errChan, int32(size))
+
+		var batch BatchMessageReceiveResponse
+		select {
+		case batch = <-respChan:
+		case err = <-errChan:
+			if IsMNSError(err, ERR_MNS_MESSAGE_NOT_EXIST) {
+				err = nil
+			}
+			return
+		}
+
+		var handles, ids []string
+		for _, message := range batch.Messages {
+			if _, seen := held[message.MessageId]; seen {
+				held[message.MessageId] = message.ReceiptHandle
+				continue
+			}
+
+			if err = encoder.Encode(ExportedMessage{
+				MessageId:    message.MessageId,
+				MessageBody:  message.MessageBody,
+				EnqueueTime:  message.EnqueueTime,
+				DequeueCount: message.DequeueCount,
+				Priority:     message.Priority,
+			}); err != nil {
+				return
+			}
+			exported++
+
+			if opts.Delete {
+				handles = append(handles, message.ReceiptHandle)
+				ids = append(ids, message.MessageId)
+			} else {
+				held[message.MessageId] = message.ReceiptHandle
+			}
+		}
+
+		if len(handles) > 0 {
+			resp, e := queue.BatchDeleteMessage(handles...)
+			requestFailed := e != nil && !IsMNSError(e, ERR_MNS_BATCH_OP_FAIL)
+			refused := map[string]bool{}
+			for _, receiptHandle := range resp.Failed() {
+				refused[receiptHandle] = true
+			}
+			for i, receiptHandle := range handles {
+				if requestFailed || refused[receiptHandle] {
+					held[ids[i]] = receiptHandle
+				}
+			}
+			if requestFailed {
+				err = e
+				return
+			}
+		}
+	}
+}
+
+// ImportOptions configures ImportQueue. PreserveDelay sends the messages
+// scheduled by SendMessageAt past MaxDelaySeconds, which carry their target
+// time, with the delay left until it. Other messages are visible at once, an
+// export does not know their delay.
+type ImportOptions struct {
+	PreserveDelay bool
+}
+
+// ImportQueue sends the messages of a file written by ExportQueue to queue
+// with their body and priority, in batches. It stops at the first batch with
+// a message not sent and returns its error, imported counts the messages
+// sent until then.
+func ImportQueue(ctx context.Context, queue AliMNSQueue, r io.Reader, opts ImportOptions) (imported int64, err error) {
+	var messages []MessageSendRequest
+	flush := func() error {
+		if len(messages) == 0 {
+			return nil
+		}
+		resp, err := queue.BatchSendMessage(messages...)
+		imported += int64(len(resp.Succeeded()))
+		messages = messages[:0]
+		if failed := resp.Failed(); err == nil && len(failed) > 0 {
+			err = resp.Err(failed[0])
+		}
+		return err
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		exported := ExportedMessage{}
+		if e := json.Unmarshal(scanner.Bytes(), &exported); e != nil {
+			err = ERR_MNS_INVALID_EXPORT_LINE.New(errors.Params{"line": line, "err": e})
+			return
+		}
+
+		message := MessageSendRequest{MessageBody: exported.MessageBody, Priority: exported.Priority}
+		if opts.PreserveDelay {
+			if at, _, ok := splitSchedule(exported.MessageBody); ok {
+				if message.DelaySeconds = delaySecondsUntil(at); message.DelaySeconds > MaxDelaySeconds {
+					message.DelaySeconds = MaxDelaySeconds
+				}
+			}
+		}
+
+		messages = append(messages, message)
+		if len(messages) == batchMaxMessages {
+			if err = flush(); err != nil {
+				return
+			}
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+
+	err = flush()
+	return
+}
@@ -0,0 +1,89 @@
+package ali_mns
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gogap/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportQueueRelease(t *testing.T) {
+	_, src, _ := newMoveQueues(t, 20)
+
+	buffer := &bytes.Buffer{}
+	exported, err := ExportQueue(context.Background(), src, buffer, ExportOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(20), exported)
+	assert.Equal(t, 20, strings.Count(buffer.String(), "\n"))
+
+	// the messages are visible again
+	exported, err = ExportQueue(context.Background(), src, &bytes.Buffer{}, ExportOptions{MaxCount: 5})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), exported)
+}
+
+func TestExportQueueDeleteFailure(t *testing.T) {
+	_, src, _ := newMoveQueues(t, 3)
+
+	refusing := &refusingQueue{AliMNSQueue: src, err: ERR_MNS_CIRCUIT_BREAKER_OPEN.New(errors.Params{"resource": "queues/dead-letter", "state": CircuitOpen})}
+	exported, err := ExportQueue(context.Background(), refusing, &bytes.Buffer{}, ExportOptions{Delete: true})
+	assert.True(t, IsMNSError(err, ERR_MNS_CIRCUIT_BREAKER_OPEN))
+	assert.Equal(t, int64(3), exported)
+
+	// the messages not deleted were released
+	exported, err = ExportQueue(context.Background(), src, &bytes.Buffer{}, ExportOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), exported)
+}
+
+func TestExportImportQueue(t *testing.T) {
+	manager, src, dst := newMoveQueues(t, 20)
+	at := time.Now().Add(time.Hour).UnixNano() / int64(time.Millisecond)
+	_, err := src.SendMessage(MessageSendRequest{MessageBody: sealEnvelope(scheduleEnvelope, strconv.FormatInt(at, 10), "later")})
+	assert.Nil(t, err)
+
+	buffer := &bytes.Buffer{}
+	exported, err := ExportQueue(context.Background(), src, buffer, ExportOptions{Delete: true})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(21), exported)
+
+	attr, err := manager.GetQueueAttributes("dead-letter")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), attr.ActiveMessages+attr.InactiveMessages)
+
+	imported, err := ImportQueue(context.Background(), dst, bytes.NewReader(buffer.Bytes()), ImportOptions{PreserveDelay: true})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(21), imported)
+
+	attr, err = manager.GetQueueAttributes("main")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(20), attr.ActiveMessages)
+	assert.Equal(t, int64(1), attr.DelayMessages)
+
+	respChan := make(chan BatchMessageReceiveResponse, 1)
+	dst.BatchReceiveMessage(respChan, make(chan error, 1), 16)
+	for _, message := range (<-respChan).Messages {
+		var i int64
+		fmt.Sscanf(message.MessageBody, "message-%d", &i)
+		assert.Equal(t, i%16+1, message.Priority)
+	}
+}
+
+func TestImportQueueInvalidLine(t *testing.T) {
+	_, _, dst := newMoveQueues(t, 0)
+
+	file := `{"message_id":"1","body":"a","priority":1}
+
+not json
+`
+	imported, err := ImportQueue(context.Background(), dst, strings.NewReader(file), ImportOptions{})
+	assert.True(t, IsMNSError(err, ERR_MNS_INVALID_EXPORT_LINE))
+	assert.Contains(t, err.Error(), "line 3")
+	assert.Equal(t, int64(0), imported)
+}
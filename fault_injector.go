@@ -0,0 +1,220 @@
+package ali_mns
+
+import (
+	"encoding/xml"
+	"math/rand"
+	"net"
+	"os"
+	"path"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gogap/errors"
+	"github.com/valyala/fasthttp"
+)
+
+type FaultKind int
+
+const (
+	// FaultDelay waits Delay before sending the request.
+	FaultDelay FaultKind = iota + 1
+	// FaultErrorCode answers with an MNS error of ErrorCode without sending
+	// the request, with StatusCode or 500.
+	FaultErrorCode
+	// FaultHTTPStatus answers with StatusCode or 503 and an empty body without
+	// sending the request, like a failing gateway.
+	FaultHTTPStatus
+	// FaultCorruptBody sends the request and truncates the response body.
+	FaultCorruptBody
+	// FaultConnectionReset fails with a connection reset without sending the
+	// request.
+	FaultConnectionReset
+)
+
+func (k FaultKind) String() string {
+	switch k {
+	case FaultDelay:
+		return "delay"
+	case FaultErrorCode:
+		return "error-code"
+	case FaultHTTPStatus:
+		return "http-status"
+	case FaultCorruptBody:
+		return "corrupt-body"
+	case FaultConnectionReset:
+		return "connection-reset"
+	}
+	return "unknown"
+}
+
+// FaultRule injects a fault into matching requests with Probability.
+//
+// Operation is the name of the client method, e.g. "ReceiveMessage" or
+// "CreateQueue", Resource a path.Match pattern of the resource without the
+// query string, e.g. "queues/orders-*/messages". Empty ones match any request.
+// Delay is waited before any fault of the rule, so combined with
+// FaultConnectionReset it simulates a timeout.
+type FaultRule struct {
+	Operation   string
+	Resource    string
+	Probability float64
+	Kind        FaultKind
+
+	Delay      time.Duration
+	ErrorCode  string
+	StatusCode int
+}
+
+func (p FaultRule) matches(operation, resource string) bool {
+	if p.Operation != "" && p.Operation != operation {
+		return false
+	}
+	if p.Resource != "" {
+		matched, _ := path.Match(p.Resource, resource)
+		return matched
+	}
+	return true
+}
+
+// FaultInjector decides which requests fail. Rules are evaluated in order,
+// firing delay rules all apply while the first other firing rule ends the
+// evaluation. The same seed and sequence of requests always inject the same
+// faults.
+type FaultInjector struct {
+	locker   sync.Mutex
+	rules    []FaultRule
+	rand     *rand.Rand
+	injected map[FaultKind]int64
+}
+
+func NewFaultInjector(seed int64, rules ...FaultRule) *FaultInjector {
+	injector := &FaultInjector{
+		rand:     rand.New(rand.NewSource(seed)),
+		injected: make(map[FaultKind]int64),
+	}
+	for _, rule := range rules {
+		injector.AddRule(rule)
+	}
+	return injector
+}
+
+func (p *FaultInjector) AddRule(rule FaultRule) {
+	if _, err := path.Match(rule.Resource, ""); err != nil {
+		panic("ali_mns: invalid fault rule resource pattern " + rule.Resource)
+	}
+
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	p.rules = append(p.rules, rule)
+}
+
+// ClearRules stops injecting faults.
+func (p *FaultInjector) ClearRules() {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	p.rules = nil
+}
+
+// Injected returns how many faults of kind were injected.
+func (p *FaultInjector) Injected(kind FaultKind) int64 {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	return p.injected[kind]
+}
+
+// pick returns the delay to wait and the fault to inject, if any.
+func (p *FaultInjector) pick(operation, resource string) (delay time.Duration, fault *FaultRule) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	for i := range p.rules {
+		rule := p.rules[i]
+		if !rule.matches(operation, resource) || p.rand.Float64() >= rule.Probability {
+			continue
+		}
+
+		p.injected[rule.Kind]++
+		delay += rule.Delay
+		if rule.Kind != FaultDelay {
+			return delay, &rule
+		}
+	}
+	return
+}
+
+type faultInjectionClient struct {
+	MNSClient
+	injector *FaultInjector
+}
+
+// NewFaultInjectionClient injects the faults of injector into requests sent
+// through client, for testing how consumers cope with an unreliable service.
+func NewFaultInjectionClient(client MNSClient, injector *FaultInjector) MNSClient {
+	return &faultInjectionClient{MNSClient: client, injector: injector}
+}
+
+func (p *faultInjectionClient) Send(method Method, headers map[string]string, message interface{}, resource string, opts ...Option) (*fasthttp.Response, error) {
+	resourcePath, _ := splitResource(resource)
+	delay, fault := p.injector.pick(operationName(method, resource, message), resourcePath)
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+
+	if fault == nil {
+		return p.MNSClient.Send(method, headers, message, resource, opts...)
+	}
+
+	switch fault.Kind {
+	case FaultErrorCode:
+		statusCode := fault.StatusCode
+		if statusCode == 0 {
+			statusCode = fasthttp.StatusInternalServerError
+		}
+		body, _ := xml.Marshal(ErrorResponse{
+			Code:      fault.ErrorCode,
+			Message:   "injected fault",
+			RequestId: "injected-fault",
+		})
+		return faultResponse(statusCode, append([]byte(xml.Header), body...)), nil
+	case FaultHTTPStatus:
+		statusCode := fault.StatusCode
+		if statusCode == 0 {
+			statusCode = fasthttp.StatusServiceUnavailable
+		}
+		return faultResponse(statusCode, nil), nil
+	case FaultConnectionReset:
+		return nil, ERR_SEND_REQUEST_FAILED.New(errors.Params{"err": &net.OpError{
+			Op:  "read",
+			Net: "tcp",
+			Err: os.NewSyscallError("read", syscall.ECONNRESET),
+		}})
+	case FaultCorruptBody:
+		resp, err := p.MNSClient.Send(method, headers, message, resource, opts...)
+		if resp != nil {
+			body := resp.Body()
+			resp.SetBody(append([]byte(nil), body[:len(body)/2]...))
+		}
+		return resp, err
+	}
+
+	return p.MNSClient.Send(method, headers, message, resource, opts...)
+}
+
+func (p *faultInjectionClient) getAdaptiveQPSConfig() *AdaptiveQPSConfig {
+	if getter, ok := p.MNSClient.(adaptiveQPSConfigGetter); ok {
+		return getter.getAdaptiveQPSConfig()
+	}
+	return nil
+}
+
+func faultResponse(statusCode int, body []byte) *fasthttp.Response {
+	resp := fasthttp.AcquireResponse()
+	resp.SetStatusCode(statusCode)
+	resp.Header.Set(headerKeyRequestID, "injected-fault")
+	resp.SetBody(body)
+	return resp
+}
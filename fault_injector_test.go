@@ -0,0 +1,90 @@
+package ali_mns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOperationName(t *testing.T) {
+	assert.Equal(t, "SendMessage", operationName(POST, "queues/q/messages", MessageSendRequest{}))
+	assert.Equal(t, "BatchSendMessage", operationName(POST, "queues/q/messages", BatchMessageSendRequest{}))
+	assert.Equal(t, "ReceiveMessage", operationName(GET, "queues/q/messages?waitseconds=3", nil))
+	assert.Equal(t, "BatchPeekMessage", operationName(GET, "queues/q/messages?numOfMessages=16&peekonly=true", nil))
+	assert.Equal(t, "DeleteMessage", operationName(DELETE, "queues/q/messages?ReceiptHandle=rh", nil))
+	assert.Equal(t, "BatchDeleteMessage", operationName(DELETE, "queues/q/messages", ReceiptHandles{}))
+	assert.Equal(t, "SetQueueAttributes", operationName(PUT, "queues/q?metaoverride=true", nil))
+	assert.Equal(t, "Subscribe", operationName(PUT, "topics/t/subscriptions/s", nil))
+	assert.Equal(t, "ListTopic", operationName(GET, "topics", nil))
+}
+
+func TestFaultInjectionClient(t *testing.T) {
+	emulator := NewMNSEmulator("", "")
+	assert.Nil(t, NewMNSQueueManager(emulator).CreateSimpleQueue("orders"))
+	assert.Nil(t, NewMNSQueueManager(emulator).CreateSimpleQueue("audit"))
+
+	injector := NewFaultInjector(1)
+	client := NewFaultInjectionClient(emulator, injector)
+	orders := NewMNSQueue("orders", client)
+	audit := NewMNSQueue("audit", client)
+
+	injector.AddRule(FaultRule{
+		Operation:   "SendMessage",
+		Resource:    "queues/orders/*",
+		Probability: 1,
+		Kind:        FaultErrorCode,
+		ErrorCode:   "QpsLimitExceeded",
+		StatusCode:  403,
+	})
+	_, err := orders.SendMessage(MessageSendRequest{MessageBody: "a"})
+	assert.True(t, IsMNSError(err, ERR_MNS_QPS_LIMIT_EXCEEDED))
+	_, err = audit.SendMessage(MessageSendRequest{MessageBody: "a"})
+	assert.Nil(t, err)
+
+	injector.ClearRules()
+	injector.AddRule(FaultRule{Operation: "SendMessage", Probability: 1, Kind: FaultConnectionReset})
+	_, err = audit.SendMessage(MessageSendRequest{MessageBody: "b"})
+	assert.True(t, IsMNSError(err, ERR_SEND_REQUEST_FAILED))
+
+	injector.ClearRules()
+	injector.AddRule(FaultRule{Operation: "PeekMessage", Probability: 1, Kind: FaultCorruptBody})
+	respChan := make(chan MessageReceiveResponse, 1)
+	errChan := make(chan error, 1)
+	audit.PeekMessage(respChan, errChan)
+	assert.True(t, IsMNSError(<-errChan, ERR_UNMARSHAL_RESPONSE_FAILED))
+
+	injector.ClearRules()
+	injector.AddRule(FaultRule{Operation: "PeekMessage", Probability: 1, Kind: FaultHTTPStatus, StatusCode: 502})
+	audit.PeekMessage(respChan, errChan)
+	assert.True(t, IsMNSError(<-errChan, ERR_UNMARSHAL_ERROR_RESPONSE_FAILED))
+
+	injector.ClearRules()
+	injector.AddRule(FaultRule{Probability: 1, Kind: FaultDelay, Delay: 20 * time.Millisecond})
+	start := time.Now()
+	audit.PeekMessage(respChan, errChan)
+	assert.Equal(t, "a", (<-respChan).MessageBody)
+	assert.True(t, time.Since(start) >= 20*time.Millisecond)
+
+	assert.Equal(t, int64(1), injector.Injected(FaultErrorCode))
+	assert.Equal(t, int64(1), injector.Injected(FaultDelay))
+}
+
+func TestFaultInjectorDeterministic(t *testing.T) {
+	rule := FaultRule{Probability: 0.3, Kind: FaultErrorCode, ErrorCode: "InternalError"}
+
+	pattern := func(seed int64) (faults []bool) {
+		injector := NewFaultInjector(seed, rule)
+		for i := 0; i < 50; i++ {
+			_, fault := injector.pick("ReceiveMessage", "queues/q/messages")
+			faults = append(faults, fault != nil)
+		}
+		return
+	}
+
+	first := pattern(42)
+	assert.Equal(t, first, pattern(42))
+	assert.NotEqual(t, first, pattern(43))
+	assert.Contains(t, first, true)
+	assert.Contains(t, first, false)
+}
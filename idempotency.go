@@ -0,0 +1,136 @@
+package ali_mns
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogap/errors"
+)
+
+const DefaultIdempotencyWindow = 10 * time.Minute
+
+// a message sent with an idempotency key is in an envelope with the key,
+// e.g. "mns:idk=order-1;..."
+const idempotencyEnvelope = "idk"
+
+// SplitIdempotencyKey returns the idempotency key and the body of a message
+// sent by an IdempotentProducer, key is empty and rest is body for any other
+// message.
+func SplitIdempotencyKey(body string) (key, rest string) {
+	key, rest, ok := openEnvelope(body, idempotencyEnvelope)
+	if !ok || key == "" {
+		return "", body
+	}
+	return key, rest
+}
+
+// MessageIdempotencyKey returns the idempotency key of message, or its
+// MessageId if it was sent without one. It is the default Key of an
+// IdempotentConsumer, which drops the messages whose key was processed.
+func MessageIdempotencyKey(message MessageReceiveResponse) string {
+	if key, _ := SplitIdempotencyKey(message.MessageBody); key != "" {
+		return key
+	}
+	return message.MessageId
+}
+
+type idempotentSend struct {
+	key     string
+	done    chan struct{}
+	resp    MessageSendResponse
+	err     error
+	expires time.Time
+}
+
+// IdempotentProducer sends messages carrying an idempotency key. A key sent
+// successfully is not sent again within the window, its first response is
+// returned instead, and concurrent sends of one key share a single request.
+// A failed send is not remembered, so retrying it after a timeout may enqueue
+// the message twice; consumers drop the copy by its key.
+type IdempotentProducer struct {
+	queue  AliMNSQueue
+	window time.Duration
+
+	locker sync.Mutex
+	sends  map[string]*idempotentSend
+	sent   *list.List
+}
+
+// NewIdempotentProducer returns a producer remembering the keys sent to
+// queue for window, DefaultIdempotencyWindow if it is not positive.
+func NewIdempotentProducer(queue AliMNSQueue, window time.Duration) *IdempotentProducer {
+	if window <= 0 {
+		window = DefaultIdempotencyWindow
+	}
+	return &IdempotentProducer{
+		queue:  queue,
+		window: window,
+		sends:  map[string]*idempotentSend{},
+		sent:   list.New(),
+	}
+}
+
+func (p *IdempotentProducer) Send(key string, message MessageSendRequest, opts ...Option) (resp MessageSendResponse, err error) {
+	if key == "" || strings.Contains(key, ";") {
+		err = ERR_MNS_INVALID_IDEMPOTENCY_KEY.New(errors.Params{"key": key})
+		return
+	}
+
+	p.locker.Lock()
+	p.expire()
+	if send, ok := p.sends[key]; ok {
+		p.locker.Unlock()
+		<-send.done
+		if send.err == nil {
+			return send.resp, nil
+		}
+		// the send we waited for failed, ours may still succeed
+		return p.Send(key, message, opts...)
+	}
+	send := &idempotentSend{key: key, done: make(chan struct{})}
+	p.sends[key] = send
+	p.locker.Unlock()
+
+	message.MessageBody = sealEnvelope(idempotencyEnvelope, key, message.MessageBody)
+	send.resp, send.err = p.queue.SendMessage(message, opts...)
+
+	p.locker.Lock()
+	if send.err != nil {
+		delete(p.sends, key)
+	} else {
+		send.expires = time.Now().Add(p.window)
+		p.sent.PushBack(send)
+	}
+	p.locker.Unlock()
+	close(send.done)
+
+	return send.resp, send.err
+}
+
+// Forget lets key be sent again before the window ends.
+func (p *IdempotentProducer) Forget(key string) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if send, ok := p.sends[key]; ok && !send.expires.IsZero() {
+		delete(p.sends, key)
+	}
+}
+
+// expire drops the keys sent longer than the window ago, they are in the
+// order they were sent.
+func (p *IdempotentProducer) expire() {
+	now := time.Now()
+	for element := p.sent.Front(); element != nil; element = p.sent.Front() {
+		send := element.Value.(*idempotentSend)
+		if now.Before(send.expires) {
+			return
+		}
+		p.sent.Remove(element)
+		if p.sends[send.key] == send {
+			delete(p.sends, send.key)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package ali_mns
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitIdempotencyKey(t *testing.T) {
+	key, body := SplitIdempotencyKey("mns:idk=order-1;hello")
+	assert.Equal(t, "order-1", key)
+	assert.Equal(t, "hello", body)
+
+	for _, plain := range []string{"hello", "idk=order-1;hello", "mns:idk=;hello", "mns:idk=unterminated"} {
+		key, body = SplitIdempotencyKey(plain)
+		assert.Equal(t, "", key)
+		assert.Equal(t, plain, body)
+	}
+}
+
+func TestIdempotentProducer(t *testing.T) {
+	emulator := NewMNSEmulator("", "")
+	manager := NewMNSQueueManager(emulator)
+	assert.Nil(t, manager.CreateSimpleQueue("test-queue"))
+	client := &gatedClient{MNSClient: emulator, gate: make(chan struct{})}
+	producer := NewIdempotentProducer(NewMNSQueue("test-queue", client), 50*time.Millisecond)
+
+	_, err := producer.Send("bad;key", MessageSendRequest{MessageBody: "hello"})
+	assert.True(t, IsMNSError(err, ERR_MNS_INVALID_IDEMPOTENCY_KEY))
+
+	// concurrent sends of one key share a request
+	ids := make([]string, 5)
+	wg := sync.WaitGroup{}
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := producer.Send("order-1", MessageSendRequest{MessageBody: "hello"})
+			assert.Nil(t, err)
+			ids[i] = resp.MessageId
+		}(i)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(client.gate)
+	wg.Wait()
+	assert.Equal(t, 1, client.count())
+	for _, id := range ids {
+		assert.Equal(t, ids[0], id)
+	}
+
+	producer.Send("order-1", MessageSendRequest{MessageBody: "hello"})
+	assert.Equal(t, 1, client.count())
+
+	producer.Forget("order-1")
+	producer.Send("order-1", MessageSendRequest{MessageBody: "hello"})
+	assert.Equal(t, 2, client.count())
+
+	time.Sleep(60 * time.Millisecond)
+	producer.Send("order-1", MessageSendRequest{MessageBody: "hello"})
+	assert.Equal(t, 3, client.count())
+}
+
+func TestIdempotentProducerRetryAfterFailure(t *testing.T) {
+	emulator := NewMNSEmulator("", "")
+	assert.Nil(t, NewMNSQueueManager(emulator).CreateSimpleQueue("test-queue"))
+	client := &flakyClient{gatedClient: gatedClient{MNSClient: emulator}, failures: 1}
+	queue := NewMNSQueue("test-queue", client)
+	producer := NewIdempotentProducer(queue, time.Minute)
+
+	_, err := producer.Send("order-1", MessageSendRequest{MessageBody: "hello"})
+	assert.NotNil(t, err)
+	_, err = producer.Send("order-1", MessageSendRequest{MessageBody: "hello"})
+	assert.Nil(t, err)
+
+	// a send that timed out after reaching the queue leaves a copy, dropped
+	// by the consumer
+	_, err = queue.SendMessage(MessageSendRequest{MessageBody: "mns:idk=order-1;hello"})
+	assert.Nil(t, err)
+
+	consumer := NewIdempotentConsumer(queue, IdempotentConsumerConfig{Store: NewMemoryDedupStore(16)})
+	var bodies []string
+	for i := 0; i < 2; i++ {
+		message, err := receiveOne(queue)
+		assert.Nil(t, err)
+		assert.Nil(t, consumer.Handle(message, func(message MessageReceiveResponse) error {
+			bodies = append(bodies, message.MessageBody)
+			return nil
+		}))
+	}
+	assert.Equal(t, []string{"hello"}, bodies)
+}
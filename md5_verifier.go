@@ -0,0 +1,161 @@
+package ali_mns
+
+import (
+	"crypto/md5"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gogap/errors"
+)
+
+// MD5Stats counts the bodies checked against the MessageBodyMD5 reported by
+// the service and how many of them did not match.
+type MD5Stats struct {
+	SendChecked       int64
+	SendMismatches    int64
+	ReceiveChecked    int64
+	ReceiveMismatches int64
+}
+
+// MD5Verifier checks message bodies and keeps the stats, one verifier can be
+// shared by several queues and topics.
+type MD5Verifier struct {
+	locker sync.Mutex
+	stats  MD5Stats
+}
+
+func NewMD5Verifier() *MD5Verifier {
+	return &MD5Verifier{}
+}
+
+func (p *MD5Verifier) Stats() MD5Stats {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	return p.stats
+}
+
+// verify returns ERR_MNS_MESSAGE_CORRUPTED if reportedMD5 is not the md5 of
+// body, an empty reportedMD5 is not checked.
+func (p *MD5Verifier) verify(messageId, body, reportedMD5 string, received bool) error {
+	if reportedMD5 == "" {
+		return nil
+	}
+
+	actual := fmt.Sprintf("%X", md5.Sum([]byte(body)))
+	mismatch := !strings.EqualFold(actual, reportedMD5)
+
+	p.locker.Lock()
+	if received {
+		p.stats.ReceiveChecked++
+		if mismatch {
+			p.stats.ReceiveMismatches++
+		}
+	} else {
+		p.stats.SendChecked++
+		if mismatch {
+			p.stats.SendMismatches++
+		}
+	}
+	p.locker.Unlock()
+
+	if mismatch {
+		return ERR_MNS_MESSAGE_CORRUPTED.New(errors.Params{"id": messageId, "expected": reportedMD5, "actual": actual})
+	}
+	return nil
+}
+
+func (p *MD5Verifier) verifyBatch(batch *BatchMessageReceiveResponse) error {
+	var err error
+	for _, message := range batch.Messages {
+		if e := p.verify(message.MessageId, message.MessageBody, message.MessageBodyMD5, true); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+type md5VerifyingQueue struct {
+	AliMNSQueue
+	verifier *MD5Verifier
+}
+
+// NewMD5VerifyingQueue wraps queue to check the MessageBodyMD5 of the
+// messages sent and received, mismatches fail with ERR_MNS_MESSAGE_CORRUPTED.
+// A sent message is enqueued anyway, a received one is not delivered and
+// becomes visible again after its visibility timeout, a whole batch if one of
+// its messages mismatches. It must wrap the queue directly, below any
+// wrapper changing the bodies.
+func NewMD5VerifyingQueue(queue AliMNSQueue, verifier *MD5Verifier) AliMNSQueue {
+	return &md5VerifyingQueue{AliMNSQueue: queue, verifier: verifier}
+}
+
+func (p *md5VerifyingQueue) SendMessage(message MessageSendRequest, opts ...Option) (resp MessageSendResponse, err error) {
+	if resp, err = p.AliMNSQueue.SendMessage(message, opts...); err != nil {
+		return
+	}
+	err = p.verifier.verify(resp.MessageId, message.MessageBody, resp.MessageBodyMD5, false)
+	return
+}
+
+// BatchSendMessage checks every entry sent, err is ERR_MNS_MESSAGE_CORRUPTED
+// only if the batch did not fail otherwise.
+func (p *md5VerifyingQueue) BatchSendMessage(messages ...MessageSendRequest) (resp BatchMessageSendResponse, err error) {
+	resp, err = p.AliMNSQueue.BatchSendMessage(messages...)
+	for i, entry := range resp.Messages {
+		if i >= len(messages) || entry.ErrorCode != "" {
+			continue
+		}
+		if e := p.verifier.verify(entry.MessageId, messages[i].MessageBody, entry.MessageBodyMD5, false); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+func (p *md5VerifyingQueue) ReceiveMessage(respChan chan MessageReceiveResponse, errChan chan error, waitseconds ...int64) {
+	relayReceive(func(innerChan chan MessageReceiveResponse) {
+		p.AliMNSQueue.ReceiveMessage(innerChan, errChan, waitseconds...)
+	}, respChan, errChan, p.verifyMessage)
+}
+
+func (p *md5VerifyingQueue) PeekMessage(respChan chan MessageReceiveResponse, errChan chan error) {
+	relayReceive(func(innerChan chan MessageReceiveResponse) {
+		p.AliMNSQueue.PeekMessage(innerChan, errChan)
+	}, respChan, errChan, p.verifyMessage)
+}
+
+func (p *md5VerifyingQueue) BatchReceiveMessage(respChan chan BatchMessageReceiveResponse, errChan chan error, numOfMessages int32, waitseconds ...int64) {
+	relayBatchReceive(func(innerChan chan BatchMessageReceiveResponse) {
+		p.AliMNSQueue.BatchReceiveMessage(innerChan, errChan, numOfMessages, waitseconds...)
+	}, respChan, errChan, p.verifier.verifyBatch)
+}
+
+func (p *md5VerifyingQueue) BatchPeekMessage(respChan chan BatchMessageReceiveResponse, errChan chan error, numOfMessages int32) {
+	relayBatchReceive(func(innerChan chan BatchMessageReceiveResponse) {
+		p.AliMNSQueue.BatchPeekMessage(innerChan, errChan, numOfMessages)
+	}, respChan, errChan, p.verifier.verifyBatch)
+}
+
+func (p *md5VerifyingQueue) verifyMessage(message *MessageReceiveResponse) error {
+	return p.verifier.verify(message.MessageId, message.MessageBody, message.MessageBodyMD5, true)
+}
+
+type md5VerifyingTopic struct {
+	AliMNSTopic
+	verifier *MD5Verifier
+}
+
+// NewMD5VerifyingTopic wraps topic to check the MessageBodyMD5 of the
+// messages published.
+func NewMD5VerifyingTopic(topic AliMNSTopic, verifier *MD5Verifier) AliMNSTopic {
+	return &md5VerifyingTopic{AliMNSTopic: topic, verifier: verifier}
+}
+
+func (p *md5VerifyingTopic) PublishMessage(message MessagePublishRequest) (resp MessageSendResponse, err error) {
+	if resp, err = p.AliMNSTopic.PublishMessage(message); err != nil {
+		return
+	}
+	err = p.verifier.verify(resp.MessageId, message.MessageBody, resp.MessageBodyMD5, false)
+	return
+}
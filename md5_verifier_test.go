@@ -0,0 +1,54 @@
+package ali_mns
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestMD5VerifyingQueue(t *testing.T) {
+	emulator := NewMNSEmulator("", "")
+	assert.Nil(t, NewMNSQueueManager(emulator).CreateSimpleQueue("test-queue"))
+	verifier := NewMD5Verifier()
+	queue := NewMD5VerifyingQueue(NewMNSQueue("test-queue", emulator), verifier)
+
+	_, err := queue.SendMessage(MessageSendRequest{MessageBody: "hello"})
+	assert.Nil(t, err)
+	_, err = queue.BatchSendMessage(MessageSendRequest{MessageBody: "a"}, MessageSendRequest{MessageBody: "b"})
+	assert.Nil(t, err)
+
+	respChan := make(chan BatchMessageReceiveResponse, 1)
+	queue.BatchReceiveMessage(respChan, make(chan error, 1), 16)
+	assert.Equal(t, 3, len((<-respChan).Messages))
+
+	assert.Equal(t, MD5Stats{SendChecked: 3, ReceiveChecked: 3}, verifier.Stats())
+}
+
+func TestMD5VerifyingQueueMismatch(t *testing.T) {
+	mMNSClient := &mockMNSClient{}
+	mMNSClient.On("Send", Method(POST), mock.Anything, mock.Anything, mock.Anything).Return(batchResponse(201, `<?xml version="1.0" encoding="UTF-8"?>
+<Message xmlns="http://mns.aliyuncs.com/doc/v1/">
+  <MessageId>id-0</MessageId>
+  <MessageBodyMD5>00000000000000000000000000000000</MessageBodyMD5>
+</Message>`), nil)
+	mMNSClient.On("Send", GET, mock.Anything, mock.Anything, mock.Anything).Return(batchResponse(200, `<?xml version="1.0" encoding="UTF-8"?>
+<Message xmlns="http://mns.aliyuncs.com/doc/v1/">
+  <MessageId>id-1</MessageId>
+  <ReceiptHandle>handle</ReceiptHandle>
+  <MessageBodyMD5>5D41402ABC4B2A76B9719D911017C592</MessageBodyMD5>
+  <MessageBody>hellO</MessageBody>
+</Message>`), nil)
+
+	verifier := NewMD5Verifier()
+	queue := NewMD5VerifyingQueue(NewMNSQueue("test-queue", mMNSClient), verifier)
+
+	resp, err := queue.SendMessage(MessageSendRequest{MessageBody: "hello"})
+	assert.True(t, IsMNSError(err, ERR_MNS_MESSAGE_CORRUPTED))
+	assert.Equal(t, "id-0", resp.MessageId)
+
+	_, err = receiveOne(queue)
+	assert.True(t, IsMNSError(err, ERR_MNS_MESSAGE_CORRUPTED))
+
+	assert.Equal(t, MD5Stats{SendChecked: 1, SendMismatches: 1, ReceiveChecked: 1, ReceiveMismatches: 1}, verifier.Stats())
+}
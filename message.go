@@ -191,6 +191,10 @@ type BatchMessageSendResponse struct {
 	RequestID string
 	XMLName   xml.Name                `xml:"Messages" json:"-"`
 	Messages  []BatchMessageSendEntry `xml:"Message" json:"messages"`
+
+	requestErr error
+	// requestIDs holds the id of the request each message was last sent in
+	requestIDs []string
 }
 
 func (r *BatchMessageSendResponse) SetRequestID(reqID string) {
@@ -210,6 +214,10 @@ type BatchMessageDeleteErrorResponse struct {
 	RequestID      string
 	XMLName        xml.Name                 `xml:"Errors" json:"-"`
 	FailedMessages []MessageDeleteFailEntry `xml:"Error" json:"errors"`
+	// ReceiptHandles are the handles the batch was asked to delete.
+	ReceiptHandles []string `xml:"-" json:"-"`
+
+	requestErr error
 }
 
 func (r *BatchMessageDeleteErrorResponse) SetRequestID(reqID string) {
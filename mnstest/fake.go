@@ -0,0 +1,104 @@
+package mnstest
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/aliyun-fc/ali_mns"
+)
+
+// TestingT is the subset of *testing.T the assertion helpers need.
+type TestingT interface {
+	Errorf(format string, args ...interface{})
+}
+
+// Call is one recorded method call of a fake.
+type Call struct {
+	Method string
+	Args   []interface{}
+}
+
+// ServiceError returns the error the client reports for an MNS error code,
+// e.g. ServiceError("QueueNotExist") satisfies
+// ali_mns.IsMNSError(err, ali_mns.ERR_MNS_QUEUE_NOT_EXIST).
+func ServiceError(code string) error {
+	return ali_mns.ParseError(ali_mns.ErrorResponse{
+		Code:      code,
+		Message:   "fake " + code,
+		RequestId: "fake-request-id",
+	}, "fake")
+}
+
+// recorder keeps the calls made on a fake and the errors scripted for them,
+// it is embedded by all fakes.
+type recorder struct {
+	locker   sync.Mutex
+	calls    []Call
+	failures map[string][]error
+	seq      int
+}
+
+// FailNext makes the next len(errs) calls of method return errs in order.
+func (p *recorder) FailNext(method string, errs ...error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if p.failures == nil {
+		p.failures = make(map[string][]error)
+	}
+	p.failures[method] = append(p.failures[method], errs...)
+}
+
+// Calls returns all recorded calls in order.
+func (p *recorder) Calls() []Call {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	return append([]Call(nil), p.calls...)
+}
+
+// CallCount returns how many times method was called.
+func (p *recorder) CallCount(method string) (count int) {
+	for _, call := range p.Calls() {
+		if call.Method == method {
+			count++
+		}
+	}
+	return
+}
+
+// AssertCalled checks that method was called times times.
+func (p *recorder) AssertCalled(t TestingT, method string, times int) bool {
+	if count := p.CallCount(method); count != times {
+		t.Errorf("mnstest: expected %s to be called %d times, got %d", method, times, count)
+		return false
+	}
+	return true
+}
+
+// Reset forgets all recorded calls and scripted errors.
+func (p *recorder) Reset() {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	p.calls = nil
+	p.failures = nil
+}
+
+// record must be called with the lock held, it returns the scripted error of
+// the call if any.
+func (p *recorder) record(method string, args ...interface{}) error {
+	p.calls = append(p.calls, Call{Method: method, Args: args})
+
+	errs := p.failures[method]
+	if len(errs) == 0 {
+		return nil
+	}
+	p.failures[method] = errs[1:]
+	return errs[0]
+}
+
+func (p *recorder) nextID(prefix string) string {
+	p.seq++
+	return fmt.Sprintf("%s-%d", prefix, p.seq)
+}
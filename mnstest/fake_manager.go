@@ -0,0 +1,285 @@
+package mnstest
+
+import (
+	"context"
+	"time"
+
+	"github.com/aliyun-fc/ali_mns"
+)
+
+var (
+	_ ali_mns.AliQueueManager = &FakeQueueManager{}
+	_ ali_mns.AliTopicManager = &FakeTopicManager{}
+)
+
+// FakeQueueManager is an in-memory ali_mns.AliQueueManager for unit tests.
+type FakeQueueManager struct {
+	recorder
+
+	queues map[string]ali_mns.QueueAttribute
+}
+
+func NewFakeQueueManager() *FakeQueueManager {
+	return &FakeQueueManager{queues: make(map[string]ali_mns.QueueAttribute)}
+}
+
+// AssertQueueExists checks that queueName was created and not deleted.
+func (p *FakeQueueManager) AssertQueueExists(t TestingT, queueName string) bool {
+	p.locker.Lock()
+	_, exist := p.queues[queueName]
+	p.locker.Unlock()
+
+	if !exist {
+		t.Errorf("mnstest: queue %s does not exist", queueName)
+	}
+	return exist
+}
+
+func (p *FakeQueueManager) CreateSimpleQueue(queueName string) (err error) {
+	return p.CreateQueue(queueName, 0, 65536, 345600, 30, 0, 2)
+}
+
+func (p *FakeQueueManager) CreateQueue(queueName string, delaySeconds int32, maxMessageSize int32, messageRetentionPeriod int32, visibilityTimeout int32, pollingWaitSeconds int32, slices int32) (err error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if err = p.record("CreateQueue", queueName, delaySeconds, maxMessageSize, messageRetentionPeriod, visibilityTimeout, pollingWaitSeconds, slices); err != nil {
+		return
+	}
+
+	attr := ali_mns.QueueAttribute{
+		QueueName:              queueName,
+		DelaySeconds:           delaySeconds,
+		MaxMessageSize:         maxMessageSize,
+		MessageRetentionPeriod: messageRetentionPeriod,
+		VisibilityTimeout:      visibilityTimeout,
+		PollingWaitSeconds:     pollingWaitSeconds,
+	}
+
+	if old, exist := p.queues[queueName]; exist {
+		old.CreateTime, old.LastModifyTime = 0, 0
+		if old != attr {
+			return ServiceError("QueueAlreadyExist")
+		}
+		return
+	}
+
+	attr.CreateTime = time.Now().Unix()
+	attr.LastModifyTime = attr.CreateTime
+	p.queues[queueName] = attr
+	return
+}
+
+func (p *FakeQueueManager) SetQueueAttributes(queueName string, delaySeconds int32, maxMessageSize int32, messageRetentionPeriod int32, visibilityTimeout int32, pollingWaitSeconds int32, slices int32) (err error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if err = p.record("SetQueueAttributes", queueName, delaySeconds, maxMessageSize, messageRetentionPeriod, visibilityTimeout, pollingWaitSeconds, slices); err != nil {
+		return
+	}
+
+	attr, exist := p.queues[queueName]
+	if !exist {
+		return ServiceError("QueueNotExist")
+	}
+
+	attr.DelaySeconds = delaySeconds
+	attr.MaxMessageSize = maxMessageSize
+	attr.MessageRetentionPeriod = messageRetentionPeriod
+	attr.VisibilityTimeout = visibilityTimeout
+	attr.PollingWaitSeconds = pollingWaitSeconds
+	attr.LastModifyTime = time.Now().Unix()
+	p.queues[queueName] = attr
+	return
+}
+
+func (p *FakeQueueManager) GetQueueAttributes(queueName string) (attr ali_mns.QueueAttribute, err error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if err = p.record("GetQueueAttributes", queueName); err != nil {
+		return
+	}
+
+	attr, exist := p.queues[queueName]
+	if !exist {
+		err = ServiceError("QueueNotExist")
+	}
+	return
+}
+
+func (p *FakeQueueManager) DeleteQueue(queueName string) (err error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if err = p.record("DeleteQueue", queueName); err != nil {
+		return
+	}
+
+	delete(p.queues, queueName)
+	return
+}
+
+// PurgeQueue reports the ActiveMessages of the queue as deleted and clears
+// them, the fake keeps no messages.
+func (p *FakeQueueManager) PurgeQueue(ctx context.Context, queueName string, onProgress func(ali_mns.PurgeProgress), qps ...int32) (deleted int64, err error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if err = p.record("PurgeQueue", queueName); err != nil {
+		return
+	}
+
+	attr, exist := p.queues[queueName]
+	if !exist {
+		err = ServiceError("QueueNotExist")
+		return
+	}
+
+	deleted, attr.ActiveMessages = attr.ActiveMessages, 0
+	p.queues[queueName] = attr
+	if onProgress != nil && deleted > 0 {
+		onProgress(ali_mns.PurgeProgress{Deleted: deleted})
+	}
+	return
+}
+
+func (p *FakeQueueManager) ListQueue(nextMarker string, retNumber int32, prefix string) (queues ali_mns.Queues, err error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if err = p.record("ListQueue", nextMarker, retNumber, prefix); err != nil {
+		return
+	}
+
+	names := make([]string, 0, len(p.queues))
+	for name := range p.queues {
+		names = append(names, name)
+	}
+
+	for _, name := range page(names, nextMarker, retNumber, prefix, &queues.NextMarker) {
+		queues.Queues = append(queues.Queues, ali_mns.Queue{QueueURL: "queues/" + name})
+	}
+	return
+}
+
+// FakeTopicManager is an in-memory ali_mns.AliTopicManager for unit tests.
+type FakeTopicManager struct {
+	recorder
+
+	topics map[string]ali_mns.TopicAttribute
+}
+
+func NewFakeTopicManager() *FakeTopicManager {
+	return &FakeTopicManager{topics: make(map[string]ali_mns.TopicAttribute)}
+}
+
+// AssertTopicExists checks that topicName was created and not deleted.
+func (p *FakeTopicManager) AssertTopicExists(t TestingT, topicName string) bool {
+	p.locker.Lock()
+	_, exist := p.topics[topicName]
+	p.locker.Unlock()
+
+	if !exist {
+		t.Errorf("mnstest: topic %s does not exist", topicName)
+	}
+	return exist
+}
+
+func (p *FakeTopicManager) CreateSimpleTopic(topicName string) (err error) {
+	return p.CreateTopic(topicName, 65536, false)
+}
+
+func (p *FakeTopicManager) CreateTopic(topicName string, maxMessageSize int32, loggingEnabled bool) (err error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if err = p.record("CreateTopic", topicName, maxMessageSize, loggingEnabled); err != nil {
+		return
+	}
+
+	attr := ali_mns.TopicAttribute{
+		TopicName:      topicName,
+		MaxMessageSize: maxMessageSize,
+		LoggingEnabled: loggingEnabled,
+	}
+
+	if old, exist := p.topics[topicName]; exist {
+		old.CreateTime, old.LastModifyTime = 0, 0
+		if old != attr {
+			return ServiceError("TopicAlreadyExist")
+		}
+		return
+	}
+
+	attr.CreateTime = time.Now().Unix()
+	attr.LastModifyTime = attr.CreateTime
+	p.topics[topicName] = attr
+	return
+}
+
+func (p *FakeTopicManager) SetTopicAttributes(topicName string, maxMessageSize int32, loggingEnabled bool) (err error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if err = p.record("SetTopicAttributes", topicName, maxMessageSize, loggingEnabled); err != nil {
+		return
+	}
+
+	attr, exist := p.topics[topicName]
+	if !exist {
+		return ServiceError("TopicNotExist")
+	}
+
+	attr.MaxMessageSize = maxMessageSize
+	attr.LoggingEnabled = loggingEnabled
+	attr.LastModifyTime = time.Now().Unix()
+	p.topics[topicName] = attr
+	return
+}
+
+func (p *FakeTopicManager) GetTopicAttributes(topicName string) (attr ali_mns.TopicAttribute, err error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if err = p.record("GetTopicAttributes", topicName); err != nil {
+		return
+	}
+
+	attr, exist := p.topics[topicName]
+	if !exist {
+		err = ServiceError("TopicNotExist")
+	}
+	return
+}
+
+func (p *FakeTopicManager) DeleteTopic(topicName string) (err error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if err = p.record("DeleteTopic", topicName); err != nil {
+		return
+	}
+
+	delete(p.topics, topicName)
+	return
+}
+
+func (p *FakeTopicManager) ListTopic(nextMarker string, retNumber int32, prefix string) (topics ali_mns.Topics, err error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if err = p.record("ListTopic", nextMarker, retNumber, prefix); err != nil {
+		return
+	}
+
+	names := make([]string, 0, len(p.topics))
+	for name := range p.topics {
+		names = append(names, name)
+	}
+
+	for _, name := range page(names, nextMarker, retNumber, prefix, &topics.NextMarker) {
+		topics.Topics = append(topics.Topics, ali_mns.Topic{TopicURL: "topics/" + name})
+	}
+	return
+}
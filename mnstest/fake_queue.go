@@ -0,0 +1,367 @@
+package mnstest
+
+import (
+	"crypto/md5"
+	"fmt"
+	"time"
+
+	"github.com/aliyun-fc/ali_mns"
+)
+
+var _ ali_mns.AliMNSQueue = &FakeQueue{}
+
+// FakeQueue is an in-memory ali_mns.AliMNSQueue for unit tests. Receives are
+// served from the messages added with AddMessages, or from the sent ones when
+// Loopback is set, and answer MessageNotExist at once when there is none
+// instead of long polling.
+type FakeQueue struct {
+	recorder
+
+	// Loopback makes sent messages receivable.
+	Loopback bool
+
+	name       string
+	qpsMonitor *ali_mns.QPSMonitor
+
+	messages   []ali_mns.MessageReceiveResponse
+	sent       []ali_mns.MessageSendRequest
+	deleted    []string
+	failSend   map[string]string
+	failDelete map[string]string
+}
+
+func NewFakeQueue(name string) *FakeQueue {
+	return &FakeQueue{
+		name:       name,
+		qpsMonitor: ali_mns.NewQPSMonitor(5, 0),
+		failSend:   make(map[string]string),
+		failDelete: make(map[string]string),
+	}
+}
+
+// AddMessages queues messages to be received, missing message ids, receipt
+// handles and md5s are generated.
+func (p *FakeQueue) AddMessages(messages ...ali_mns.MessageReceiveResponse) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	for _, message := range messages {
+		p.addMessage(message)
+	}
+}
+
+// AddMessageBodies queues messages with the given bodies to be received.
+func (p *FakeQueue) AddMessageBodies(bodies ...string) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	for _, body := range bodies {
+		p.addMessage(ali_mns.MessageReceiveResponse{MessageBody: body})
+	}
+}
+
+// FailSend makes sending a message with body fail with the MNS error code,
+// alone or as an entry of a batch.
+func (p *FakeQueue) FailSend(body string, code string) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	p.failSend[body] = code
+}
+
+// FailDelete makes deleting receiptHandle fail with the MNS error code, alone
+// or as an entry of a batch.
+func (p *FakeQueue) FailDelete(receiptHandle string, code string) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	p.failDelete[receiptHandle] = code
+}
+
+// SentMessages returns the messages sent successfully, in order.
+func (p *FakeQueue) SentMessages() []ali_mns.MessageSendRequest {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	return append([]ali_mns.MessageSendRequest(nil), p.sent...)
+}
+
+// DeletedReceiptHandles returns the receipt handles deleted successfully, in
+// order.
+func (p *FakeQueue) DeletedReceiptHandles() []string {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	return append([]string(nil), p.deleted...)
+}
+
+// AssertSent checks that a message with body was sent.
+func (p *FakeQueue) AssertSent(t TestingT, body string) bool {
+	for _, message := range p.SentMessages() {
+		if message.MessageBody == body {
+			return true
+		}
+	}
+	t.Errorf("mnstest: no message with body %q was sent to queue %s", body, p.name)
+	return false
+}
+
+// AssertNotSent checks that no message with body was sent.
+func (p *FakeQueue) AssertNotSent(t TestingT, body string) bool {
+	for _, message := range p.SentMessages() {
+		if message.MessageBody == body {
+			t.Errorf("mnstest: message with body %q was sent to queue %s", body, p.name)
+			return false
+		}
+	}
+	return true
+}
+
+// AssertDeleted checks that receiptHandle was deleted.
+func (p *FakeQueue) AssertDeleted(t TestingT, receiptHandle string) bool {
+	for _, handle := range p.DeletedReceiptHandles() {
+		if handle == receiptHandle {
+			return true
+		}
+	}
+	t.Errorf("mnstest: receipt handle %q was not deleted from queue %s", receiptHandle, p.name)
+	return false
+}
+
+// AssertNotDeleted checks that receiptHandle was not deleted.
+func (p *FakeQueue) AssertNotDeleted(t TestingT, receiptHandle string) bool {
+	for _, handle := range p.DeletedReceiptHandles() {
+		if handle == receiptHandle {
+			t.Errorf("mnstest: receipt handle %q was deleted from queue %s", receiptHandle, p.name)
+			return false
+		}
+	}
+	return true
+}
+
+func (p *FakeQueue) QPSMonitor() *ali_mns.QPSMonitor {
+	return p.qpsMonitor
+}
+
+func (p *FakeQueue) Name() string {
+	return p.name
+}
+
+func (p *FakeQueue) SendMessage(message ali_mns.MessageSendRequest, opts ...ali_mns.Option) (resp ali_mns.MessageSendResponse, err error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if err = p.record("SendMessage", message); err != nil {
+		return
+	}
+
+	entry := p.send(message)
+	if entry.ErrorCode != "" {
+		err = ServiceError(entry.ErrorCode)
+		return
+	}
+
+	resp.RequestID = p.nextID("request")
+	resp.MessageId = entry.MessageId
+	resp.MessageBodyMD5 = entry.MessageBodyMD5
+	return
+}
+
+func (p *FakeQueue) BatchSendMessage(messages ...ali_mns.MessageSendRequest) (resp ali_mns.BatchMessageSendResponse, err error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if err = p.record("BatchSendMessage", messages); err != nil {
+		return
+	}
+
+	resp.RequestID = p.nextID("request")
+	for _, message := range messages {
+		entry := p.send(message)
+		if entry.ErrorCode != "" {
+			err = ali_mns.ERR_MNS_BATCH_OP_FAIL.New()
+		}
+		resp.Messages = append(resp.Messages, entry)
+	}
+	return
+}
+
+func (p *FakeQueue) ReceiveMessage(respChan chan ali_mns.MessageReceiveResponse, errChan chan error, waitseconds ...int64) {
+	p.locker.Lock()
+	messages, err := p.receive(1, true, "ReceiveMessage", waitseconds)
+	p.locker.Unlock()
+
+	if err != nil {
+		errChan <- err
+		return
+	}
+	respChan <- messages[0]
+}
+
+func (p *FakeQueue) BatchReceiveMessage(respChan chan ali_mns.BatchMessageReceiveResponse, errChan chan error, numOfMessages int32, waitseconds ...int64) {
+	p.locker.Lock()
+	messages, err := p.receive(int(numOfMessages), true, "BatchReceiveMessage", numOfMessages, waitseconds)
+	resp := ali_mns.BatchMessageReceiveResponse{Messages: messages}
+	if err == nil {
+		resp.RequestID = p.nextID("request")
+	}
+	p.locker.Unlock()
+
+	if err != nil {
+		errChan <- err
+		return
+	}
+	respChan <- resp
+}
+
+func (p *FakeQueue) PeekMessage(respChan chan ali_mns.MessageReceiveResponse, errChan chan error) {
+	p.locker.Lock()
+	messages, err := p.receive(1, false, "PeekMessage")
+	p.locker.Unlock()
+
+	if err != nil {
+		errChan <- err
+		return
+	}
+	respChan <- messages[0]
+}
+
+func (p *FakeQueue) BatchPeekMessage(respChan chan ali_mns.BatchMessageReceiveResponse, errChan chan error, numOfMessages int32) {
+	p.locker.Lock()
+	messages, err := p.receive(int(numOfMessages), false, "BatchPeekMessage", numOfMessages)
+	resp := ali_mns.BatchMessageReceiveResponse{Messages: messages}
+	if err == nil {
+		resp.RequestID = p.nextID("request")
+	}
+	p.locker.Unlock()
+
+	if err != nil {
+		errChan <- err
+		return
+	}
+	respChan <- resp
+}
+
+func (p *FakeQueue) DeleteMessage(receiptHandle string) (err error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if err = p.record("DeleteMessage", receiptHandle); err != nil {
+		return
+	}
+
+	if code, exist := p.failDelete[receiptHandle]; exist {
+		return ServiceError(code)
+	}
+	p.deleted = append(p.deleted, receiptHandle)
+	return
+}
+
+func (p *FakeQueue) BatchDeleteMessage(receiptHandles ...string) (resp ali_mns.BatchMessageDeleteErrorResponse, err error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if err = p.record("BatchDeleteMessage", receiptHandles); err != nil {
+		return
+	}
+
+	resp.RequestID = p.nextID("request")
+	resp.ReceiptHandles = receiptHandles
+	for _, receiptHandle := range receiptHandles {
+		if code, exist := p.failDelete[receiptHandle]; exist {
+			resp.FailedMessages = append(resp.FailedMessages, ali_mns.MessageDeleteFailEntry{
+				ErrorCode:     code,
+				ErrorMessage:  "fake " + code,
+				ReceiptHandle: receiptHandle,
+			})
+			continue
+		}
+		p.deleted = append(p.deleted, receiptHandle)
+	}
+
+	if len(resp.FailedMessages) > 0 {
+		err = ali_mns.ERR_MNS_BATCH_OP_FAIL.New()
+	}
+	return
+}
+
+func (p *FakeQueue) ChangeMessageVisibility(receiptHandle string, visibilityTimeout int64) (resp ali_mns.MessageVisibilityChangeResponse, err error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if err = p.record("ChangeMessageVisibility", receiptHandle, visibilityTimeout); err != nil {
+		return
+	}
+
+	resp.RequestID = p.nextID("request")
+	resp.ReceiptHandle = p.nextID(receiptHandle)
+	resp.NextVisibleTime = time.Now().Add(time.Duration(visibilityTimeout)*time.Second).UnixNano() / int64(time.Millisecond)
+	return
+}
+
+func (p *FakeQueue) send(message ali_mns.MessageSendRequest) ali_mns.BatchMessageSendEntry {
+	if code, exist := p.failSend[message.MessageBody]; exist {
+		return ali_mns.BatchMessageSendEntry{ErrorCode: code, ErrorMessage: "fake " + code}
+	}
+
+	p.sent = append(p.sent, message)
+	received := p.addMessage(ali_mns.MessageReceiveResponse{MessageBody: message.MessageBody, Priority: message.Priority})
+	if !p.Loopback {
+		p.messages = p.messages[:len(p.messages)-1]
+	}
+
+	return ali_mns.BatchMessageSendEntry{MessageId: received.MessageId, MessageBodyMD5: received.MessageBodyMD5}
+}
+
+func (p *FakeQueue) addMessage(message ali_mns.MessageReceiveResponse) ali_mns.MessageReceiveResponse {
+	if message.MessageId == "" {
+		message.MessageId = p.nextID("message")
+	}
+	if message.ReceiptHandle == "" {
+		message.ReceiptHandle = p.nextID("handle")
+	}
+	if message.MessageBodyMD5 == "" {
+		message.MessageBodyMD5 = fmt.Sprintf("%X", md5.Sum([]byte(message.MessageBody)))
+	}
+	if message.EnqueueTime == 0 {
+		message.EnqueueTime = time.Now().UnixNano() / int64(time.Millisecond)
+	}
+	p.messages = append(p.messages, message)
+	return message
+}
+
+// receive must be called with the lock held, it records the call and takes
+// up to n messages. The result is sent to the caller's channels after the
+// lock is released, so a caller that reads them late does not block the
+// queue.
+func (p *FakeQueue) receive(n int, consume bool, method string, args ...interface{}) ([]ali_mns.MessageReceiveResponse, error) {
+	if err := p.record(method, args...); err != nil {
+		return nil, err
+	}
+
+	messages := p.take(n, consume)
+	if len(messages) == 0 {
+		return nil, ServiceError("MessageNotExist")
+	}
+	return messages, nil
+}
+
+// take returns up to n queued messages, removing them when consume is set.
+// Like MNSQueue, n <= 0 stands for DefaultNumOfMessages.
+func (p *FakeQueue) take(n int, consume bool) []ali_mns.MessageReceiveResponse {
+	if n <= 0 {
+		n = int(ali_mns.DefaultNumOfMessages)
+	}
+	if n > len(p.messages) {
+		n = len(p.messages)
+	}
+
+	messages := append([]ali_mns.MessageReceiveResponse(nil), p.messages[:n]...)
+	if consume {
+		p.messages = p.messages[n:]
+		for i := range messages {
+			messages[i].DequeueCount++
+		}
+	}
+	return messages
+}
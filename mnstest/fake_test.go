@@ -0,0 +1,178 @@
+package mnstest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aliyun-fc/ali_mns"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingT struct {
+	errors []string
+}
+
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestFakeQueue(t *testing.T) {
+	queue := NewFakeQueue("test-queue")
+	queue.AddMessageBodies("first", "second")
+
+	respChan := make(chan ali_mns.MessageReceiveResponse, 1)
+	errChan := make(chan error, 1)
+
+	queue.ReceiveMessage(respChan, errChan, 30)
+	resp := <-respChan
+	assert.Equal(t, "first", resp.MessageBody)
+	assert.Equal(t, int64(1), resp.DequeueCount)
+	assert.Nil(t, queue.DeleteMessage(resp.ReceiptHandle))
+
+	queue.FailNext("ReceiveMessage", ServiceError("QueueNotExist"))
+	queue.ReceiveMessage(respChan, errChan)
+	assert.True(t, ali_mns.IsMNSError(<-errChan, ali_mns.ERR_MNS_QUEUE_NOT_EXIST))
+
+	queue.ReceiveMessage(respChan, errChan)
+	assert.Equal(t, "second", (<-respChan).MessageBody)
+
+	queue.ReceiveMessage(respChan, errChan)
+	assert.True(t, ali_mns.IsMNSError(<-errChan, ali_mns.ERR_MNS_MESSAGE_NOT_EXIST))
+
+	_, err := queue.SendMessage(ali_mns.MessageSendRequest{MessageBody: "hello"})
+	assert.Nil(t, err)
+
+	queue.AssertSent(t, "hello")
+	queue.AssertDeleted(t, resp.ReceiptHandle)
+	queue.AssertCalled(t, "ReceiveMessage", 4)
+
+	mockT := new(recordingT)
+	assert.False(t, queue.AssertSent(mockT, "nope"))
+	assert.False(t, queue.AssertDeleted(mockT, "no-handle"))
+	assert.Equal(t, 2, len(mockT.errors))
+}
+
+func TestFakeQueueUnbufferedChannels(t *testing.T) {
+	queue := NewFakeQueue("test-queue")
+	queue.AddMessageBodies("first")
+
+	respChan := make(chan ali_mns.MessageReceiveResponse)
+	errChan := make(chan error)
+	go queue.ReceiveMessage(respChan, errChan)
+
+	// the queue is usable while the receive waits for its reader
+	for queue.CallCount("ReceiveMessage") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	queue.AddMessageBodies("second")
+	assert.Equal(t, "first", (<-respChan).MessageBody)
+
+	batchChan := make(chan ali_mns.BatchMessageReceiveResponse)
+	go queue.BatchPeekMessage(batchChan, errChan, 16)
+	for queue.CallCount("BatchPeekMessage") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	queue.AddMessageBodies("third")
+	assert.Equal(t, 1, len((<-batchChan).Messages))
+}
+
+func TestFakeQueueDefaultNumOfMessages(t *testing.T) {
+	queue := NewFakeQueue("test-queue")
+	queue.AddMessageBodies("first", "second")
+
+	batchChan := make(chan ali_mns.BatchMessageReceiveResponse, 1)
+	errChan := make(chan error, 1)
+	for _, n := range []int32{0, -1} {
+		queue.BatchPeekMessage(batchChan, errChan, n)
+		assert.Equal(t, 2, len((<-batchChan).Messages))
+	}
+	queue.BatchReceiveMessage(batchChan, errChan, 0)
+	assert.Equal(t, 2, len((<-batchChan).Messages))
+
+	queue.AddMessageBodies("third")
+	queue.BatchReceiveMessage(batchChan, errChan, -1)
+	assert.Equal(t, 1, len((<-batchChan).Messages))
+}
+
+func TestFakeQueueBatchFailures(t *testing.T) {
+	queue := NewFakeQueue("test-queue")
+	queue.Loopback = true
+	queue.FailSend("bad", "InvalidArgument")
+
+	resp, err := queue.BatchSendMessage(
+		ali_mns.MessageSendRequest{MessageBody: "good"},
+		ali_mns.MessageSendRequest{MessageBody: "bad"},
+	)
+	assert.True(t, ali_mns.IsMNSError(err, ali_mns.ERR_MNS_BATCH_OP_FAIL))
+	assert.NotEmpty(t, resp.Messages[0].MessageId)
+	assert.Equal(t, "InvalidArgument", resp.Messages[1].ErrorCode)
+	queue.AssertNotSent(t, "bad")
+
+	respChan := make(chan ali_mns.BatchMessageReceiveResponse, 1)
+	errChan := make(chan error, 1)
+	queue.BatchReceiveMessage(respChan, errChan, 16)
+	received := (<-respChan).Messages
+	assert.Equal(t, 1, len(received))
+	assert.Equal(t, "good", received[0].MessageBody)
+
+	queue.FailDelete("expired", "ReceiptHandleError")
+	deleteResp, err := queue.BatchDeleteMessage(received[0].ReceiptHandle, "expired")
+	assert.True(t, ali_mns.IsMNSError(err, ali_mns.ERR_MNS_BATCH_OP_FAIL))
+	assert.Equal(t, 1, len(deleteResp.FailedMessages))
+	assert.Equal(t, "expired", deleteResp.FailedMessages[0].ReceiptHandle)
+	queue.AssertDeleted(t, received[0].ReceiptHandle)
+	queue.AssertNotDeleted(t, "expired")
+}
+
+func TestFakeTopic(t *testing.T) {
+	topic := NewFakeTopic("test-topic")
+
+	assert.Nil(t, topic.Subscribe("sub", ali_mns.MessageSubsribeRequest{Endpoint: topic.GenerateQueueEndpoint("q")}))
+	attr, err := topic.GetSubscriptionAttributes("sub")
+	assert.Nil(t, err)
+	assert.Equal(t, "acs:mns:cn-hangzhou:1234567890:queues/q", attr.Endpoint)
+	assert.Equal(t, ali_mns.BACKOFF_RETRY, attr.NotifyStrategy)
+	topic.AssertSubscribed(t, "sub")
+
+	_, err = topic.PublishMessage(ali_mns.MessagePublishRequest{MessageBody: "event"})
+	assert.Nil(t, err)
+	topic.AssertPublished(t, "event")
+
+	assert.Nil(t, topic.Unsubscribe("sub"))
+	_, err = topic.GetSubscriptionAttributes("sub")
+	assert.True(t, ali_mns.IsMNSError(err, ali_mns.ERR_MNS_SUBSCRIPTION_NOT_EXIST))
+}
+
+func TestFakeManagers(t *testing.T) {
+	queueManager := NewFakeQueueManager()
+	assert.Nil(t, queueManager.CreateSimpleQueue("b"))
+	assert.Nil(t, queueManager.CreateSimpleQueue("a"))
+	assert.Nil(t, queueManager.CreateSimpleQueue("a"))
+	assert.True(t, ali_mns.IsMNSError(queueManager.CreateQueue("a", 10, 1024, 60, 30, 0, 2), ali_mns.ERR_MNS_QUEUE_ALREADY_EXIST))
+	queueManager.AssertQueueExists(t, "a")
+
+	queues, err := queueManager.ListQueue("", 1, "")
+	assert.Nil(t, err)
+	assert.Equal(t, []ali_mns.Queue{{QueueURL: "queues/a"}}, queues.Queues)
+	assert.Equal(t, "b", queues.NextMarker)
+
+	deleted, err := queueManager.PurgeQueue(context.Background(), "b", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), deleted)
+
+	queueManager.FailNext("DeleteQueue", ServiceError("AccessDenied"))
+	assert.NotNil(t, queueManager.DeleteQueue("a"))
+	assert.Nil(t, queueManager.DeleteQueue("a"))
+	_, err = queueManager.GetQueueAttributes("a")
+	assert.True(t, ali_mns.IsMNSError(err, ali_mns.ERR_MNS_QUEUE_NOT_EXIST))
+
+	topicManager := NewFakeTopicManager()
+	assert.Nil(t, topicManager.CreateSimpleTopic("t"))
+	assert.Nil(t, topicManager.SetTopicAttributes("t", 1024, true))
+	attr, err := topicManager.GetTopicAttributes("t")
+	assert.Nil(t, err)
+	assert.True(t, attr.LoggingEnabled)
+	topicManager.AssertCalled(t, "CreateTopic", 1)
+}
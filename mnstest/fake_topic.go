@@ -0,0 +1,211 @@
+package mnstest
+
+import (
+	"crypto/md5"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aliyun-fc/ali_mns"
+)
+
+var _ ali_mns.AliMNSTopic = &FakeTopic{}
+
+// FakeTopic is an in-memory ali_mns.AliMNSTopic for unit tests, it records
+// published messages and keeps subscriptions.
+type FakeTopic struct {
+	recorder
+
+	// AccountId and Region are used to generate queue endpoints.
+	AccountId string
+	Region    string
+
+	name string
+
+	published     []ali_mns.MessagePublishRequest
+	subscriptions map[string]ali_mns.SubscriptionAttribute
+}
+
+func NewFakeTopic(name string) *FakeTopic {
+	return &FakeTopic{
+		AccountId:     "1234567890",
+		Region:        "cn-hangzhou",
+		name:          name,
+		subscriptions: make(map[string]ali_mns.SubscriptionAttribute),
+	}
+}
+
+// PublishedMessages returns the messages published successfully, in order.
+func (p *FakeTopic) PublishedMessages() []ali_mns.MessagePublishRequest {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	return append([]ali_mns.MessagePublishRequest(nil), p.published...)
+}
+
+// AssertPublished checks that a message with body was published.
+func (p *FakeTopic) AssertPublished(t TestingT, body string) bool {
+	for _, message := range p.PublishedMessages() {
+		if message.MessageBody == body {
+			return true
+		}
+	}
+	t.Errorf("mnstest: no message with body %q was published to topic %s", body, p.name)
+	return false
+}
+
+// AssertSubscribed checks that subscriptionName exists.
+func (p *FakeTopic) AssertSubscribed(t TestingT, subscriptionName string) bool {
+	p.locker.Lock()
+	_, exist := p.subscriptions[subscriptionName]
+	p.locker.Unlock()
+
+	if !exist {
+		t.Errorf("mnstest: topic %s has no subscription %s", p.name, subscriptionName)
+	}
+	return exist
+}
+
+func (p *FakeTopic) Name() string {
+	return p.name
+}
+
+func (p *FakeTopic) GenerateQueueEndpoint(queueName string) string {
+	return "acs:mns:" + p.Region + ":" + p.AccountId + ":queues/" + queueName
+}
+
+func (p *FakeTopic) GenerateMailEndpoint(mailAddress string) string {
+	return "mail:directmail:" + mailAddress
+}
+
+func (p *FakeTopic) GenerateExtendEndpoint(serviceName string, endpointUrl string) string {
+	url := strings.TrimPrefix(strings.TrimPrefix(endpointUrl, "http://"), "https://")
+	return "extend:" + serviceName + ":" + url
+}
+
+func (p *FakeTopic) PublishMessage(message ali_mns.MessagePublishRequest) (resp ali_mns.MessageSendResponse, err error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if err = p.record("PublishMessage", message); err != nil {
+		return
+	}
+
+	p.published = append(p.published, message)
+	resp.RequestID = p.nextID("request")
+	resp.MessageId = p.nextID("message")
+	resp.MessageBodyMD5 = fmt.Sprintf("%X", md5.Sum([]byte(message.MessageBody)))
+	return
+}
+
+func (p *FakeTopic) Subscribe(subscriptionName string, message ali_mns.MessageSubsribeRequest) (err error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if err = p.record("Subscribe", subscriptionName, message); err != nil {
+		return
+	}
+
+	if message.NotifyStrategy == "" {
+		message.NotifyStrategy = ali_mns.BACKOFF_RETRY
+	}
+	if message.NotifyContentFormat == "" {
+		message.NotifyContentFormat = ali_mns.XML
+	}
+
+	p.subscriptions[subscriptionName] = ali_mns.SubscriptionAttribute{
+		SubscriptionName:    subscriptionName,
+		Subscriber:          p.AccountId,
+		TopicOwner:          p.AccountId,
+		TopicName:           p.name,
+		Endpoint:            message.Endpoint,
+		NotifyStrategy:      message.NotifyStrategy,
+		NotifyContentFormat: message.NotifyContentFormat,
+		FilterTag:           message.FilterTag,
+	}
+	return
+}
+
+func (p *FakeTopic) SetSubscriptionAttributes(subscriptionName string, notifyStrategy ali_mns.NotifyStrategyType) (err error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if err = p.record("SetSubscriptionAttributes", subscriptionName, notifyStrategy); err != nil {
+		return
+	}
+
+	attr, exist := p.subscriptions[subscriptionName]
+	if !exist {
+		return ServiceError("SubscriptionNotExist")
+	}
+	attr.NotifyStrategy = notifyStrategy
+	p.subscriptions[subscriptionName] = attr
+	return
+}
+
+func (p *FakeTopic) GetSubscriptionAttributes(subscriptionName string) (attr ali_mns.SubscriptionAttribute, err error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if err = p.record("GetSubscriptionAttributes", subscriptionName); err != nil {
+		return
+	}
+
+	attr, exist := p.subscriptions[subscriptionName]
+	if !exist {
+		err = ServiceError("SubscriptionNotExist")
+	}
+	return
+}
+
+func (p *FakeTopic) Unsubscribe(subscriptionName string) (err error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if err = p.record("Unsubscribe", subscriptionName); err != nil {
+		return
+	}
+
+	delete(p.subscriptions, subscriptionName)
+	return
+}
+
+func (p *FakeTopic) ListSubscriptionByTopic(nextMarker string, retNumber int32, prefix string) (subscriptions ali_mns.Subscriptions, err error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if err = p.record("ListSubscriptionByTopic", nextMarker, retNumber, prefix); err != nil {
+		return
+	}
+
+	names := make([]string, 0, len(p.subscriptions))
+	for name := range p.subscriptions {
+		names = append(names, name)
+	}
+
+	for _, name := range page(names, nextMarker, retNumber, prefix, &subscriptions.NextMarker) {
+		subscriptions.Subscriptions = append(subscriptions.Subscriptions, ali_mns.Subscription{
+			SubscriptionURL: "topics/" + p.name + "/subscriptions/" + name,
+		})
+	}
+	return
+}
+
+// page sorts names and returns the ones matching prefix from nextMarker on, at
+// most retNumber of them, setting marker to the first name left out.
+func page(names []string, nextMarker string, retNumber int32, prefix string, marker *string) []string {
+	sort.Strings(names)
+
+	matched := []string{}
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) && name >= nextMarker {
+			matched = append(matched, name)
+		}
+	}
+
+	if retNumber > 0 && int(retNumber) < len(matched) {
+		*marker = matched[retNumber]
+		matched = matched[:retNumber]
+	}
+	return matched
+}
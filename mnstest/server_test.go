@@ -0,0 +1,126 @@
+package mnstest
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aliyun-fc/ali_mns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerQueue(t *testing.T) {
+	server := NewServer("test-id", "test-secret")
+	defer server.Close()
+
+	client := server.Client()
+	assert.Nil(t, ali_mns.NewMNSQueueManager(client).CreateSimpleQueue("test-queue"))
+
+	queue := ali_mns.NewMNSQueue("test-queue", client)
+	sendResp, err := queue.SendMessage(ali_mns.MessageSendRequest{MessageBody: "hello <world>"})
+	assert.Nil(t, err)
+	assert.NotEmpty(t, sendResp.MessageId)
+	assert.NotEmpty(t, sendResp.RequestID)
+
+	respChan := make(chan ali_mns.BatchMessageReceiveResponse, 1)
+	errChan := make(chan error, 1)
+	queue.BatchReceiveMessage(respChan, errChan, 16, 1)
+	resp := <-respChan
+	assert.Equal(t, 1, len(resp.Messages))
+	assert.Equal(t, "hello <world>", resp.Messages[0].MessageBody)
+	assert.Equal(t, sendResp.MessageId, resp.Messages[0].MessageId)
+
+	// receipt handles are url escaped in the query string and signed as is
+	assert.Nil(t, queue.DeleteMessage(resp.Messages[0].ReceiptHandle))
+
+	_, err = ali_mns.NewMNSQueueManager(client).GetQueueAttributes("no-queue")
+	assert.True(t, ali_mns.IsMNSError(err, ali_mns.ERR_MNS_QUEUE_NOT_EXIST))
+}
+
+func TestServerTopicFanOut(t *testing.T) {
+	server := NewServer("test-id", "test-secret")
+	defer server.Close()
+
+	client := server.Client()
+	queueManager := ali_mns.NewMNSQueueManager(client)
+	assert.Nil(t, queueManager.CreateSimpleQueue("raw-queue"))
+	assert.Nil(t, queueManager.CreateSimpleQueue("xml-queue"))
+	assert.Nil(t, ali_mns.NewMNSTopicManager(client).CreateSimpleTopic("test-topic"))
+
+	topic := ali_mns.NewMNSTopic("test-topic", client)
+	assert.Nil(t, topic.Subscribe("raw", ali_mns.MessageSubsribeRequest{
+		Endpoint:            topic.GenerateQueueEndpoint("raw-queue"),
+		NotifyContentFormat: ali_mns.SIMPLIFIED,
+		FilterTag:           "important",
+	}))
+	assert.Nil(t, topic.Subscribe("xml", ali_mns.MessageSubsribeRequest{
+		Endpoint: topic.GenerateQueueEndpoint("xml-queue"),
+	}))
+
+	subscriptions, err := topic.ListSubscriptionByTopic("", 0, "")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(subscriptions.Subscriptions))
+
+	_, err = topic.PublishMessage(ali_mns.MessagePublishRequest{MessageBody: "first"})
+	assert.Nil(t, err)
+	_, err = topic.PublishMessage(ali_mns.MessagePublishRequest{MessageBody: "second", MessageTag: "important"})
+	assert.Nil(t, err)
+
+	respChan := make(chan ali_mns.BatchMessageReceiveResponse, 1)
+	errChan := make(chan error, 1)
+
+	ali_mns.NewMNSQueue("raw-queue", client).BatchReceiveMessage(respChan, errChan, 16)
+	resp := <-respChan
+	assert.Equal(t, 1, len(resp.Messages))
+	assert.Equal(t, "second", resp.Messages[0].MessageBody)
+
+	ali_mns.NewMNSQueue("xml-queue", client).BatchReceiveMessage(respChan, errChan, 16)
+	resp = <-respChan
+	assert.Equal(t, 2, len(resp.Messages))
+	assert.True(t, strings.Contains(resp.Messages[0].MessageBody, "<Message>first</Message>"))
+	assert.True(t, strings.Contains(resp.Messages[0].MessageBody, "<SubscriptionName>xml</SubscriptionName>"))
+}
+
+func TestServerAuthentication(t *testing.T) {
+	server := NewServer("test-id", "test-secret")
+	defer server.Close()
+
+	err := ali_mns.NewMNSQueueManager(server.ClientWithKey("test-id", "wrong-secret")).CreateSimpleQueue("test-queue")
+	assert.True(t, ali_mns.IsMNSError(err, ali_mns.ERR_MNS_SIGNATURE_DOES_NOT_MATCH))
+
+	err = ali_mns.NewMNSQueueManager(server.ClientWithKey("wrong-id", "test-secret")).CreateSimpleQueue("test-queue")
+	assert.True(t, ali_mns.IsMNSError(err, ali_mns.ERR_MNS_INVALID_ACCESS_KEY_ID))
+}
+
+func TestServerSignsWithRequestDate(t *testing.T) {
+	server := NewServer("test-id", "test-secret")
+	defer server.Close()
+
+	newRequest := func(date string) *http.Request {
+		req, err := http.NewRequest("GET", server.URL+"/queues/no-queue", nil)
+		assert.Nil(t, err)
+		req.Header.Set(ali_mns.MQ_VERSION, "2015-06-06")
+		if date != "" {
+			req.Header.Set(ali_mns.DATE, date)
+		}
+		signature, err := ali_mns.NewAliMNSCredential("test-secret").Signature("GET", map[string]string{
+			ali_mns.DATE:       date,
+			ali_mns.MQ_VERSION: "2015-06-06",
+		}, "/queues/no-queue")
+		assert.Nil(t, err)
+		req.Header.Set(ali_mns.AUTHORIZATION, "MNS test-id:"+signature)
+		return req
+	}
+
+	// signed a while ago, the request reaches the emulator
+	resp, err := http.DefaultClient.Do(newRequest(time.Now().Add(-2 * time.Minute).UTC().Format(http.TimeFormat)))
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, err = http.DefaultClient.Do(newRequest(""))
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,145 @@
+package ali_mns
+
+import (
+	"context"
+	"time"
+)
+
+// MoveOptions configures MoveMessages.
+//
+// MaxCount stops the move after that many messages, zero moves all. Filter
+// selects the messages to move, the others are left in the source and become
+// visible again after their visibility timeout. RateLimit caps the average
+// messages sent per second, in batches of at most RateLimit, zero is
+// unlimited. DryRun receives and filters the messages without sending or
+// deleting them, they become visible again too.
+type MoveOptions struct {
+	MaxCount  int64
+	Filter    func(message MessageReceiveResponse) bool
+	RateLimit int
+	DryRun    bool
+}
+
+// MoveResult counts the messages handled by MoveMessages. Failed ones were
+// not sent and stay in the source, NotDeleted ones were sent but could not be
+// deleted from the source and will be delivered, and moved, again.
+type MoveResult struct {
+	Moved      int64
+	Skipped    int64
+	Failed     int64
+	NotDeleted int64
+}
+
+// MoveMessages receives the messages of src and sends them to dst with their
+// body and priority, a message is deleted from src once it was sent. It stops
+// when src has no visible message left, MaxCount is reached, ctx is done or a
+// request fails as a whole; result counts the messages handled until then.
+func MoveMessages(ctx context.Context, src, dst AliMNSQueue, opts MoveOptions) (result MoveResult, err error) {
+	batchSize := int64(batchMaxMessages)
+	var interval time.Duration
+	if opts.RateLimit > 0 {
+		interval = time.Second / time.Duration(opts.RateLimit)
+		if int64(opts.RateLimit) < batchSize {
+			batchSize = int64(opts.RateLimit)
+		}
+	}
+	next := time.Now()
+
+	for {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+
+		size := batchSize
+		if opts.MaxCount > 0 {
+			if left := opts.MaxCount - result.Moved; left <= 0 {
+				return
+			} else if left < size {
+				size = left
+			}
+		}
+
+		respChan := make(chan BatchMessageReceiveResponse, 1)
+		errChan := make(chan error, 1)
+		src.BatchReceiveMessage(respChan, errChan, int32(size))
+
+		var batch BatchMessageReceiveResponse
+		select {
+		case batch = <-respChan:
+		case err = <-errChan:
+			if IsMNSError(err, ERR_MNS_MESSAGE_NOT_EXIST) {
+				err = nil
+			}
+			return
+		}
+
+		var messages []MessageSendRequest
+		var handles []string
+		for _, message := range batch.Messages {
+			if opts.Filter != nil && !opts.Filter(message) {
+				result.Skipped++
+				continue
+			}
+			messages = append(messages, MessageSendRequest{MessageBody: message.MessageBody, Priority: message.Priority})
+			handles = append(handles, message.ReceiptHandle)
+		}
+		if len(messages) == 0 {
+			continue
+		}
+		if opts.DryRun {
+			result.Moved += int64(len(messages))
+			continue
+		}
+
+		if interval > 0 {
+			if err = sleepUntil(ctx, next); err != nil {
+				return
+			}
+			if now := time.Now(); next.Before(now) {
+				next = now
+			}
+			next = next.Add(interval * time.Duration(len(messages)))
+		}
+
+		sendResp, sendErr := dst.BatchSendMessage(messages...)
+		if sendErr != nil && !IsMNSError(sendErr, ERR_MNS_BATCH_OP_FAIL) {
+			result.Failed += int64(len(messages))
+			err = sendErr
+			return
+		}
+
+		var sent []string
+		for _, i := range sendResp.Succeeded() {
+			sent = append(sent, handles[i])
+		}
+		result.Moved += int64(len(sent))
+		result.Failed += int64(len(messages) - len(sent))
+		if len(sent) == 0 {
+			continue
+		}
+
+		deleteResp, deleteErr := src.BatchDeleteMessage(sent...)
+		if deleteErr != nil && !IsMNSError(deleteErr, ERR_MNS_BATCH_OP_FAIL) {
+			result.NotDeleted += int64(len(sent))
+			err = deleteErr
+			return
+		}
+		result.NotDeleted += int64(len(deleteResp.FailedMessages))
+	}
+}
+
+func sleepUntil(ctx context.Context, at time.Time) error {
+	wait := time.Until(at)
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
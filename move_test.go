@@ -0,0 +1,119 @@
+package ali_mns
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gogap/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMoveQueues(t *testing.T, count int) (manager AliQueueManager, src, dst AliMNSQueue) {
+	emulator := NewMNSEmulator("", "")
+	manager = NewMNSQueueManager(emulator)
+	assert.Nil(t, manager.CreateSimpleQueue("dead-letter"))
+	assert.Nil(t, manager.CreateSimpleQueue("main"))
+	src = NewMNSQueue("dead-letter", emulator)
+	dst = NewMNSQueue("main", emulator)
+
+	for i := 0; i < count; i++ {
+		_, err := src.SendMessage(MessageSendRequest{MessageBody: fmt.Sprintf("message-%d", i), Priority: int64(i%16 + 1)})
+		assert.Nil(t, err)
+	}
+	return
+}
+
+func TestMoveMessages(t *testing.T) {
+	manager, src, dst := newMoveQueues(t, 40)
+
+	result, err := MoveMessages(context.Background(), src, dst, MoveOptions{
+		Filter: func(message MessageReceiveResponse) bool {
+			return !strings.HasSuffix(message.MessageBody, "0")
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, MoveResult{Moved: 36, Skipped: 4}, result)
+
+	attr, err := manager.GetQueueAttributes("main")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(36), attr.ActiveMessages)
+	attr, err = manager.GetQueueAttributes("dead-letter")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), attr.ActiveMessages+attr.InactiveMessages)
+
+	message, err := receiveOne(dst)
+	assert.Nil(t, err)
+	assert.Equal(t, "message-", message.MessageBody[:8])
+	var i int64
+	fmt.Sscanf(message.MessageBody, "message-%d", &i)
+	assert.Equal(t, i%16+1, message.Priority)
+}
+
+func TestMoveMessagesMaxCountAndDryRun(t *testing.T) {
+	manager, src, dst := newMoveQueues(t, 40)
+
+	result, err := MoveMessages(context.Background(), src, dst, MoveOptions{MaxCount: 20, DryRun: true})
+	assert.Nil(t, err)
+	assert.Equal(t, MoveResult{Moved: 20}, result)
+	attr, err := manager.GetQueueAttributes("main")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), attr.ActiveMessages)
+
+	result, err = MoveMessages(context.Background(), src, dst, MoveOptions{MaxCount: 5})
+	assert.Nil(t, err)
+	assert.Equal(t, MoveResult{Moved: 5}, result)
+	attr, err = manager.GetQueueAttributes("main")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), attr.ActiveMessages)
+}
+
+func TestMoveMessagesRateLimit(t *testing.T) {
+	_, src, dst := newMoveQueues(t, 40)
+
+	start := time.Now()
+	result, err := MoveMessages(context.Background(), src, dst, MoveOptions{RateLimit: 100})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(40), result.Moved)
+	// batches of 16 go out 160ms apart
+	assert.True(t, time.Since(start) >= 300*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	_, src, dst = newMoveQueues(t, 6)
+	_, err = MoveMessages(ctx, src, dst, MoveOptions{RateLimit: 2})
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestMoveMessagesSendFailure(t *testing.T) {
+	manager, src, _ := newMoveQueues(t, 3)
+
+	result, err := MoveMessages(context.Background(), src, NewMNSQueue("no-queue", NewMNSEmulator("", "")), MoveOptions{})
+	assert.True(t, IsMNSError(err, ERR_MNS_QUEUE_NOT_EXIST))
+	assert.Equal(t, MoveResult{Failed: 3}, result)
+
+	attr, err := manager.GetQueueAttributes("dead-letter")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), attr.InactiveMessages)
+}
+
+// refusingQueue fails every batch delete as a whole with err.
+type refusingQueue struct {
+	AliMNSQueue
+	err error
+}
+
+func (p *refusingQueue) BatchDeleteMessage(receiptHandles ...string) (BatchMessageDeleteErrorResponse, error) {
+	return BatchMessageDeleteErrorResponse{}, p.err
+}
+
+func TestMoveMessagesDeleteFailure(t *testing.T) {
+	_, src, dst := newMoveQueues(t, 3)
+
+	refusing := &refusingQueue{AliMNSQueue: src, err: ERR_MNS_CIRCUIT_BREAKER_OPEN.New(errors.Params{"resource": "queues/dead-letter", "state": CircuitOpen})}
+	result, err := MoveMessages(context.Background(), refusing, dst, MoveOptions{})
+	assert.True(t, IsMNSError(err, ERR_MNS_CIRCUIT_BREAKER_OPEN))
+	assert.Equal(t, MoveResult{Moved: 3, NotDeleted: 3}, result)
+}
@@ -0,0 +1,101 @@
+package ali_mns
+
+import (
+	neturl "net/url"
+	"strings"
+)
+
+// operationName names the request the way the client interfaces do, e.g.
+// "SendMessage" or "GetQueueAttributes", so layers under MNSClient.Send can
+// tell operations apart. Unknown requests are named "METHOD path".
+func operationName(method Method, resource string, message interface{}) string {
+	path, query := splitResource(resource)
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	switch {
+	case len(segments) == 1 && segments[0] == "queues" && method == GET:
+		return "ListQueue"
+	case len(segments) == 2 && segments[0] == "queues":
+		switch method {
+		case PUT:
+			if query.Get("metaoverride") == "true" {
+				return "SetQueueAttributes"
+			}
+			return "CreateQueue"
+		case GET:
+			return "GetQueueAttributes"
+		case DELETE:
+			return "DeleteQueue"
+		}
+	case len(segments) == 3 && segments[0] == "queues" && segments[2] == "messages":
+		switch method {
+		case POST:
+			switch message.(type) {
+			case BatchMessageSendRequest, *BatchMessageSendRequest:
+				return "BatchSendMessage"
+			}
+			return "SendMessage"
+		case GET:
+			batch := query.Get("numOfMessages") != ""
+			switch {
+			case query.Get("peekonly") == "true" && batch:
+				return "BatchPeekMessage"
+			case query.Get("peekonly") == "true":
+				return "PeekMessage"
+			case batch:
+				return "BatchReceiveMessage"
+			}
+			return "ReceiveMessage"
+		case DELETE:
+			if query.Get("ReceiptHandle") != "" {
+				return "DeleteMessage"
+			}
+			return "BatchDeleteMessage"
+		case PUT:
+			return "ChangeMessageVisibility"
+		}
+	case len(segments) == 1 && segments[0] == "topics" && method == GET:
+		return "ListTopic"
+	case len(segments) == 2 && segments[0] == "topics":
+		switch method {
+		case PUT:
+			if query.Get("metaoverride") == "true" {
+				return "SetTopicAttributes"
+			}
+			return "CreateTopic"
+		case GET:
+			return "GetTopicAttributes"
+		case DELETE:
+			return "DeleteTopic"
+		}
+	case len(segments) == 3 && segments[0] == "topics" && segments[2] == "messages" && method == POST:
+		return "PublishMessage"
+	case len(segments) == 3 && segments[0] == "topics" && segments[2] == "subscriptions" && method == GET:
+		return "ListSubscriptionByTopic"
+	case len(segments) == 4 && segments[0] == "topics" && segments[2] == "subscriptions":
+		switch method {
+		case PUT:
+			if query.Get("metaoverride") == "true" {
+				return "SetSubscriptionAttributes"
+			}
+			return "Subscribe"
+		case GET:
+			return "GetSubscriptionAttributes"
+		case DELETE:
+			return "Unsubscribe"
+		}
+	}
+
+	return string(method) + " " + path
+}
+
+// splitResource splits a resource into its path and query.
+func splitResource(resource string) (path string, query neturl.Values) {
+	path = resource
+	query = neturl.Values{}
+	if i := strings.Index(resource, "?"); i >= 0 {
+		path = resource[:i]
+		query, _ = neturl.ParseQuery(resource[i+1:])
+	}
+	return
+}
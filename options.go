@@ -22,6 +22,8 @@ const (
 	optReqTimeout    = "ReqTimeout"
 	optSecurityToken = "SecurityToken"
 	optMaxConns      = "MaxConns"
+	optAdaptiveQPS   = "AdaptiveQPS"
+	optDial          = "Dial"
 )
 
 type optionValue struct {
@@ -81,6 +83,30 @@ func SecurityToken(securityToken string) Option {
 	}
 }
 
+// Dial overrides how the client connects to the MNS endpoint, e.g. to reach
+// a local emulator while keeping the {accountId}.mns.{region} url.
+func Dial(dial fasthttp.DialFunc) Option {
+	return func(params optionParams) error {
+		params[optDial] = optionValue{
+			value: dial,
+			typ:   clientOption,
+		}
+		return nil
+	}
+}
+
+// AdaptiveQPS makes every queue and topic created from the client use an
+// AIMD qps limiter that backs off on QpsLimitExceeded.
+func AdaptiveQPS(config AdaptiveQPSConfig) Option {
+	return func(params optionParams) error {
+		params[optAdaptiveQPS] = optionValue{
+			value: config,
+			typ:   clientOption,
+		}
+		return nil
+	}
+}
+
 func initMNSClientOption(cli *aliMNSClient, opts ...Option) error {
 	params := optionParams{}
 	for _, opt := range opts {
@@ -99,6 +125,13 @@ func initMNSClientOption(cli *aliMNSClient, opts ...Option) error {
 	if optValue, ok := params[optMaxConns]; ok && optValue.typ == clientOption {
 		cli.maxConnsSize = optValue.value.(int)
 	}
+	if optValue, ok := params[optAdaptiveQPS]; ok && optValue.typ == clientOption {
+		config := optValue.value.(AdaptiveQPSConfig)
+		cli.adaptiveQPS = &config
+	}
+	if optValue, ok := params[optDial]; ok && optValue.typ == clientOption {
+		cli.dial = optValue.value.(fasthttp.DialFunc)
+	}
 	return nil
 }
 
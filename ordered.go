@@ -0,0 +1,367 @@
+package ali_mns
+
+import (
+	"context"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gogap/errors"
+)
+
+const (
+	DefaultOrderedWorkers          = 8
+	DefaultOrderedWorkerBufferSize = 16
+	DefaultReorderWindow           = 5 * time.Second
+	DefaultMaxPendingPerKey        = 64
+	DefaultRedelaySeconds          = 10
+	DefaultOrderedWaitSeconds      = 5
+)
+
+// a message sent by SendSequenced is in an envelope with its sequence number
+// and ordering key, e.g. "mns:seq=12,order-1;..."
+const sequenceEnvelope = "seq"
+
+// SendSequenced sends message carrying its ordering key and sequence number.
+// The sequence numbers of a key start at 1 and have no gaps, an
+// OrderedConsumer handles the messages of the key in that order.
+func SendSequenced(queue AliMNSQueue, key string, seq int64, message MessageSendRequest, opts ...Option) (resp MessageSendResponse, err error) {
+	if key == "" || strings.Contains(key, ";") || seq <= 0 {
+		err = ERR_MNS_INVALID_ORDERING_KEY.New(errors.Params{"key": key, "seq": seq})
+		return
+	}
+
+	message.MessageBody = sealEnvelope(sequenceEnvelope, strconv.FormatInt(seq, 10)+","+key, message.MessageBody)
+	return queue.SendMessage(message, opts...)
+}
+
+// splitSequence returns the sequence number, ordering key and body of a
+// message sent by SendSequenced.
+func splitSequence(body string) (seq int64, key, rest string, ok bool) {
+	value, payload, found := openEnvelope(body, sequenceEnvelope)
+	comma := strings.Index(value, ",")
+	if !found || comma < 0 || comma+1 == len(value) {
+		return
+	}
+	seq, err := strconv.ParseInt(value[:comma], 10, 64)
+	if err != nil || seq <= 0 {
+		return 0, "", "", false
+	}
+	return seq, value[comma+1:], payload, true
+}
+
+// MessageOrderingKey returns the ordering key of a message sent by
+// SendSequenced, or an empty string.
+func MessageOrderingKey(message MessageReceiveResponse) string {
+	_, key, _, _ := splitSequence(message.MessageBody)
+	return key
+}
+
+// OrderedConsumerConfig configures an OrderedConsumer.
+//
+// Key returns the ordering key of the messages not sent by SendSequenced,
+// none by default; sequenced messages always go by the key they were sent
+// with. Messages with an empty key are spread over the workers. A sequenced message
+// arriving before its predecessors is held until they are handled, for
+// ReorderWindow at most, after which the missing ones are skipped and handled
+// whenever they arrive. A key holding MaxPendingPerKey messages makes the
+// next early ones visible again after RedelaySeconds instead. The window
+// should be well below the visibility timeout of the queue.
+//
+// OnError is called with the messages whose handler or delete failed, they are
+// left in the queue, and with the errors of Run. It runs on the worker or Run
+// goroutine.
+type OrderedConsumerConfig struct {
+	Key              func(message MessageReceiveResponse) string
+	Workers          int
+	WorkerBufferSize int
+	ReorderWindow    time.Duration
+	MaxPendingPerKey int
+	RedelaySeconds   int64
+	WaitSeconds      int64
+
+	OnError func(message MessageReceiveResponse, err error)
+}
+
+func (p OrderedConsumerConfig) normalize() OrderedConsumerConfig {
+	if p.Key == nil {
+		p.Key = MessageOrderingKey
+	}
+	if p.Workers <= 0 {
+		p.Workers = DefaultOrderedWorkers
+	}
+	if p.WorkerBufferSize <= 0 {
+		p.WorkerBufferSize = DefaultOrderedWorkerBufferSize
+	}
+	if p.ReorderWindow <= 0 {
+		p.ReorderWindow = DefaultReorderWindow
+	}
+	if p.MaxPendingPerKey <= 0 {
+		p.MaxPendingPerKey = DefaultMaxPendingPerKey
+	}
+	if p.RedelaySeconds <= 0 {
+		p.RedelaySeconds = DefaultRedelaySeconds
+	}
+	if p.WaitSeconds <= 0 {
+		p.WaitSeconds = DefaultOrderedWaitSeconds
+	}
+	if p.OnError == nil {
+		p.OnError = func(MessageReceiveResponse, error) {}
+	}
+	return p
+}
+
+type pendingSequenced struct {
+	message MessageReceiveResponse
+	arrived time.Time
+}
+
+// sequenceState is the progress of a key on its worker, next is zero until
+// the first message of the key is handled.
+type sequenceState struct {
+	next    int64
+	pending map[int64]*pendingSequenced
+	touched time.Time
+}
+
+type orderedWorker struct {
+	messages chan MessageReceiveResponse
+	keys     map[string]*sequenceState
+}
+
+// OrderedConsumer handles messages on a fixed set of workers, the messages of
+// a key always on the same one, so that each key is handled serially while
+// different keys run in parallel. A handled message is deleted.
+type OrderedConsumer struct {
+	queue   AliMNSQueue
+	config  OrderedConsumerConfig
+	handler func(MessageReceiveResponse) error
+
+	locker  sync.RWMutex
+	closed  bool
+	senders sync.WaitGroup
+	done    chan struct{}
+	workers []*orderedWorker
+	next    uint32
+	wg      sync.WaitGroup
+}
+
+func NewOrderedConsumer(queue AliMNSQueue, config OrderedConsumerConfig, handler func(MessageReceiveResponse) error) *OrderedConsumer {
+	config = config.normalize()
+	consumer := &OrderedConsumer{queue: queue, config: config, handler: handler, done: make(chan struct{})}
+	for i := 0; i < config.Workers; i++ {
+		worker := &orderedWorker{
+			messages: make(chan MessageReceiveResponse, config.WorkerBufferSize),
+			keys:     map[string]*sequenceState{},
+		}
+		consumer.workers = append(consumer.workers, worker)
+		consumer.wg.Add(1)
+		go consumer.work(worker)
+	}
+	return consumer
+}
+
+// Run receives messages and dispatches them until ctx is done. Receive errors
+// other than an empty queue are passed to OnError and retried after a second.
+func (p *OrderedConsumer) Run(ctx context.Context) error {
+	for ctx.Err() == nil {
+		respChan := make(chan BatchMessageReceiveResponse, 1)
+		errChan := make(chan error, 1)
+		p.queue.BatchReceiveMessage(respChan, errChan, DefaultNumOfMessages, p.config.WaitSeconds)
+
+		select {
+		case batch := <-respChan:
+			for _, message := range batch.Messages {
+				if err := p.Dispatch(message); err != nil {
+					return err
+				}
+			}
+		case err := <-errChan:
+			if IsMNSError(err, ERR_MNS_MESSAGE_NOT_EXIST) {
+				continue
+			}
+			p.config.OnError(MessageReceiveResponse{}, err)
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+// Dispatch hands message to the worker of its key, waiting for room in its
+// buffer. It fails with ERR_MNS_CONSUMER_CLOSED if Close is called meanwhile.
+func (p *OrderedConsumer) Dispatch(message MessageReceiveResponse) error {
+	p.locker.RLock()
+	if p.closed {
+		p.locker.RUnlock()
+		return ERR_MNS_CONSUMER_CLOSED.New(errors.Params{"queue": p.queue.Name()})
+	}
+	p.senders.Add(1)
+	p.locker.RUnlock()
+	defer p.senders.Done()
+
+	key := MessageOrderingKey(message)
+	if key == "" {
+		key = p.config.Key(message)
+	}
+
+	var index uint32
+	if key != "" {
+		hash := fnv.New32a()
+		hash.Write([]byte(key))
+		index = hash.Sum32()
+	} else {
+		index = atomic.AddUint32(&p.next, 1)
+	}
+	select {
+	case p.workers[index%uint32(len(p.workers))].messages <- message:
+		return nil
+	case <-p.done:
+		return ERR_MNS_CONSUMER_CLOSED.New(errors.Params{"queue": p.queue.Name()})
+	}
+}
+
+// Close stops accepting messages and waits until the dispatched ones are
+// handled. Sequenced messages still held are left in the queue.
+func (p *OrderedConsumer) Close(ctx context.Context) error {
+	p.locker.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.done)
+		go func() {
+			p.senders.Wait()
+			for _, worker := range p.workers {
+				close(worker.messages)
+			}
+		}()
+	}
+	p.locker.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *OrderedConsumer) work(worker *orderedWorker) {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.config.ReorderWindow / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case message, ok := <-worker.messages:
+			if !ok {
+				return
+			}
+			p.accept(worker, message)
+		case <-ticker.C:
+			p.skipGaps(worker)
+		}
+	}
+}
+
+func (p *OrderedConsumer) accept(worker *orderedWorker, message MessageReceiveResponse) {
+	seq, key, body, ok := splitSequence(message.MessageBody)
+	if !ok {
+		p.handle(message)
+		return
+	}
+	message.MessageBody = body
+
+	state, ok := worker.keys[key]
+	if !ok {
+		state = &sequenceState{pending: map[int64]*pendingSequenced{}}
+		worker.keys[key] = state
+	}
+	state.touched = time.Now()
+
+	switch {
+	case seq < state.next:
+		// its turn was skipped, or it is delivered again
+		p.handle(message)
+	case seq == state.next || state.next == 0 && seq == 1:
+		if p.handle(message) {
+			state.next = seq + 1
+			p.drain(state)
+		}
+	default:
+		if _, held := state.pending[seq]; !held && len(state.pending) >= p.config.MaxPendingPerKey {
+			if _, err := p.queue.ChangeMessageVisibility(message.ReceiptHandle, p.config.RedelaySeconds); err != nil {
+				p.config.OnError(message, err)
+			}
+			return
+		}
+		state.pending[seq] = &pendingSequenced{message: message, arrived: time.Now()}
+	}
+}
+
+// drain handles the held messages following the last one handled.
+func (p *OrderedConsumer) drain(state *sequenceState) {
+	for {
+		pending, ok := state.pending[state.next]
+		if !ok {
+			return
+		}
+		delete(state.pending, state.next)
+		if !p.handle(pending.message) {
+			return
+		}
+		state.next++
+	}
+}
+
+// skipGaps gives up waiting for the predecessors of messages held longer
+// than the window, and forgets keys idle for several windows.
+func (p *OrderedConsumer) skipGaps(worker *orderedWorker) {
+	now := time.Now()
+	for key, state := range worker.keys {
+		if len(state.pending) == 0 {
+			if now.Sub(state.touched) > 10*p.config.ReorderWindow {
+				delete(worker.keys, key)
+			}
+			continue
+		}
+
+		lowest, expired := int64(0), false
+		for seq, pending := range state.pending {
+			if lowest == 0 || seq < lowest {
+				lowest = seq
+			}
+			if now.Sub(pending.arrived) >= p.config.ReorderWindow {
+				expired = true
+			}
+		}
+		if expired {
+			state.next = lowest
+			p.drain(state)
+		}
+	}
+}
+
+// handle calls the handler and deletes message, it returns whether the
+// handler succeeded.
+func (p *OrderedConsumer) handle(message MessageReceiveResponse) bool {
+	if err := p.handler(message); err != nil {
+		p.config.OnError(message, err)
+		return false
+	}
+	if err := p.queue.DeleteMessage(message.ReceiptHandle); err != nil {
+		p.config.OnError(message, err)
+	}
+	return true
+}
@@ -0,0 +1,229 @@
+package ali_mns
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitSequence(t *testing.T) {
+	seq, key, body, ok := splitSequence("mns:seq=12,order-1;hello")
+	assert.True(t, ok)
+	assert.Equal(t, int64(12), seq)
+	assert.Equal(t, "order-1", key)
+	assert.Equal(t, "hello", body)
+
+	for _, plain := range []string{"hello", "seq=12,order-1;hello", "mns:seq=0,key;hello", "mns:seq=1,;hello", "mns:seq=1;key,hello", "mns:seq=x,key;hello"} {
+		_, _, _, ok = splitSequence(plain)
+		assert.False(t, ok, plain)
+	}
+}
+
+func TestOrderedConsumerKeys(t *testing.T) {
+	emulator := NewMNSEmulator("", "")
+	manager := NewMNSQueueManager(emulator)
+	assert.Nil(t, manager.CreateSimpleQueue("test-queue"))
+	queue := NewMNSQueue("test-queue", emulator)
+
+	for i := 0; i < 10; i++ {
+		for _, key := range []string{"a", "b", "c", "d"} {
+			_, err := queue.SendMessage(MessageSendRequest{MessageBody: fmt.Sprintf("%s-%d", key, i)})
+			assert.Nil(t, err)
+		}
+	}
+
+	var locker sync.Mutex
+	running, maxRunning := map[string]int{}, 0
+	handled := map[string][]string{}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	consumer := NewOrderedConsumer(queue, OrderedConsumerConfig{
+		Key: func(message MessageReceiveResponse) string {
+			return strings.Split(message.MessageBody, "-")[0]
+		},
+		Workers:     4,
+		WaitSeconds: 1,
+	}, func(message MessageReceiveResponse) error {
+		key := strings.Split(message.MessageBody, "-")[0]
+		locker.Lock()
+		running[key]++
+		assert.Equal(t, 1, running[key])
+		total := 0
+		for _, n := range running {
+			total += n
+		}
+		if total > maxRunning {
+			maxRunning = total
+		}
+		locker.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		locker.Lock()
+		running[key]--
+		handled[key] = append(handled[key], message.MessageBody)
+		if len(handled["a"])+len(handled["b"])+len(handled["c"])+len(handled["d"]) == 40 {
+			cancel()
+		}
+		locker.Unlock()
+		return nil
+	})
+
+	assert.Equal(t, context.Canceled, consumer.Run(ctx))
+	assert.Nil(t, consumer.Close(context.Background()))
+	assert.True(t, maxRunning > 1)
+	for _, bodies := range handled {
+		assert.Equal(t, 10, len(bodies))
+	}
+
+	attr, err := manager.GetQueueAttributes("test-queue")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), attr.ActiveMessages+attr.InactiveMessages)
+
+	err = consumer.Dispatch(MessageReceiveResponse{})
+	assert.True(t, IsMNSError(err, ERR_MNS_CONSUMER_CLOSED))
+}
+
+func TestOrderedConsumerSequences(t *testing.T) {
+	emulator := NewMNSEmulator("", "")
+	manager := NewMNSQueueManager(emulator)
+	assert.Nil(t, manager.CreateSimpleQueue("test-queue"))
+	queue := NewMNSQueue("test-queue", emulator)
+
+	_, err := SendSequenced(queue, "bad;key", 1, MessageSendRequest{MessageBody: "x"})
+	assert.True(t, IsMNSError(err, ERR_MNS_INVALID_ORDERING_KEY))
+
+	// a is complete, b misses its first message, c has more early messages
+	// than it can hold
+	sent := map[string][]int64{"a": {1, 2, 3}, "b": {2, 3}, "c": {3, 4, 5}}
+	for key, seqs := range sent {
+		for _, seq := range seqs {
+			_, err = SendSequenced(queue, key, seq, MessageSendRequest{MessageBody: fmt.Sprintf("%s-%d", key, seq)})
+			assert.Nil(t, err)
+		}
+	}
+
+	respChan := make(chan BatchMessageReceiveResponse, 1)
+	queue.BatchReceiveMessage(respChan, make(chan error, 1), 16)
+	messages := map[string]MessageReceiveResponse{}
+	for _, message := range (<-respChan).Messages {
+		_, _, body, _ := splitSequence(message.MessageBody)
+		messages[body] = message
+	}
+
+	var locker sync.Mutex
+	var handled []string
+	consumer := NewOrderedConsumer(queue, OrderedConsumerConfig{
+		Workers:          2,
+		ReorderWindow:    50 * time.Millisecond,
+		MaxPendingPerKey: 2,
+	}, func(message MessageReceiveResponse) error {
+		locker.Lock()
+		handled = append(handled, message.MessageBody)
+		locker.Unlock()
+		return nil
+	})
+
+	for _, body := range []string{"a-3", "b-3", "c-3", "c-5", "a-1", "b-2", "c-4", "a-2"} {
+		assert.Nil(t, consumer.Dispatch(messages[body]))
+	}
+	time.Sleep(150 * time.Millisecond)
+	assert.Nil(t, consumer.Close(context.Background()))
+
+	var a, b, c []string
+	for _, body := range handled {
+		switch body[0] {
+		case 'a':
+			a = append(a, body)
+		case 'b':
+			b = append(b, body)
+		case 'c':
+			c = append(c, body)
+		}
+	}
+	assert.Equal(t, []string{"a-1", "a-2", "a-3"}, a)
+	assert.Equal(t, []string{"b-2", "b-3"}, b)
+	assert.Equal(t, []string{"c-3", "c-5"}, c)
+
+	// c-4 found c full and was made visible again later instead of being held
+	attr, err := manager.GetQueueAttributes("test-queue")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), attr.InactiveMessages)
+}
+
+func TestOrderedConsumerSequencesCustomKey(t *testing.T) {
+	emulator := NewMNSEmulator("", "")
+	assert.Nil(t, NewMNSQueueManager(emulator).CreateSimpleQueue("test-queue"))
+	queue := NewMNSQueue("test-queue", emulator)
+
+	for seq := int64(1); seq <= 8; seq++ {
+		_, err := SendSequenced(queue, "a", seq, MessageSendRequest{MessageBody: fmt.Sprintf("a-%d", seq)})
+		assert.Nil(t, err)
+	}
+	respChan := make(chan BatchMessageReceiveResponse, 1)
+	queue.BatchReceiveMessage(respChan, make(chan error, 1), 16)
+	messages := (<-respChan).Messages
+	assert.Equal(t, 8, len(messages))
+
+	var locker sync.Mutex
+	var handled []string
+	// a Key spreading every message does not split the sequence of "a"
+	consumer := NewOrderedConsumer(queue, OrderedConsumerConfig{
+		Key: func(message MessageReceiveResponse) string {
+			return message.MessageId
+		},
+		Workers:       4,
+		ReorderWindow: time.Minute,
+	}, func(message MessageReceiveResponse) error {
+		locker.Lock()
+		handled = append(handled, message.MessageBody)
+		locker.Unlock()
+		return nil
+	})
+
+	for i := len(messages) - 1; i >= 0; i-- {
+		assert.Nil(t, consumer.Dispatch(messages[i]))
+	}
+	time.Sleep(50 * time.Millisecond)
+	assert.Nil(t, consumer.Close(context.Background()))
+
+	assert.Equal(t, []string{"a-1", "a-2", "a-3", "a-4", "a-5", "a-6", "a-7", "a-8"}, handled)
+}
+
+func TestOrderedConsumerCloseWhileBlocked(t *testing.T) {
+	emulator := NewMNSEmulator("", "")
+	assert.Nil(t, NewMNSQueueManager(emulator).CreateSimpleQueue("test-queue"))
+
+	gate := make(chan struct{})
+	consumer := NewOrderedConsumer(NewMNSQueue("test-queue", emulator), OrderedConsumerConfig{
+		Workers:          1,
+		WorkerBufferSize: 1,
+	}, func(message MessageReceiveResponse) error {
+		<-gate
+		return nil
+	})
+
+	assert.Nil(t, consumer.Dispatch(MessageReceiveResponse{MessageBody: "first"}))
+	time.Sleep(20 * time.Millisecond)
+	assert.Nil(t, consumer.Dispatch(MessageReceiveResponse{MessageBody: "second"}))
+
+	blocked := make(chan error, 1)
+	go func() {
+		blocked <- consumer.Dispatch(MessageReceiveResponse{MessageBody: "third"})
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// Close honours its ctx and releases the blocked Dispatch
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	assert.Equal(t, context.DeadlineExceeded, consumer.Close(ctx))
+	cancel()
+	assert.True(t, IsMNSError(<-blocked, ERR_MNS_CONSUMER_CLOSED))
+
+	close(gate)
+	assert.Nil(t, consumer.Close(context.Background()))
+}
@@ -0,0 +1,54 @@
+// Package protocodec provides the protobuf Codec of ali_mns, importing it
+// registers the codec so that ali_mns.DecodeBody decodes protobuf bodies.
+package protocodec
+
+import (
+	"reflect"
+
+	"github.com/aliyun-fc/ali_mns"
+	"github.com/gogap/errors"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec marshals proto.Message values and unmarshals into a proto.Message
+// or a pointer to one, allocating it if nil.
+var Codec ali_mns.Codec = protobufCodec{}
+
+func init() {
+	ali_mns.RegisterCodec(Codec)
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string {
+	return "protobuf"
+}
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	message, ok := v.(proto.Message)
+	if !ok {
+		return nil, notMessage(v)
+	}
+	return proto.Marshal(message)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	if message, ok := v.(proto.Message); ok {
+		return proto.Unmarshal(data, message)
+	}
+
+	value := reflect.ValueOf(v)
+	if value.Kind() == reflect.Ptr && value.Elem().Kind() == reflect.Ptr {
+		if value.Elem().IsNil() {
+			value.Elem().Set(reflect.New(value.Elem().Type().Elem()))
+		}
+		if message, ok := value.Elem().Interface().(proto.Message); ok {
+			return proto.Unmarshal(data, message)
+		}
+	}
+	return notMessage(v)
+}
+
+func notMessage(v interface{}) error {
+	return ali_mns.ERR_MNS_CODEC_FAILED.New(errors.Params{"type": "protobuf", "err": reflect.TypeOf(v).String() + " is not a proto.Message"})
+}
@@ -0,0 +1,20 @@
+package protocodec
+
+import (
+	"testing"
+
+	"github.com/aliyun-fc/ali_mns"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestCodec(t *testing.T) {
+	body, err := ali_mns.EncodeBody(Codec, wrapperspb.String("hello"))
+	assert.Nil(t, err)
+	value, err := ali_mns.DecodeBody[*wrapperspb.StringValue](body)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", value.GetValue())
+
+	_, err = ali_mns.EncodeBody(Codec, struct{ ID int }{1})
+	assert.True(t, ali_mns.IsMNSError(err, ali_mns.ERR_MNS_CODEC_FAILED))
+}
@@ -1,23 +1,82 @@
 package ali_mns
 
 import (
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
+const (
+	defaultAdaptiveDecreaseFactor   = 0.5
+	defaultAdaptiveIncreaseInterval = time.Second
+	defaultAdaptiveDecreaseCooldown = time.Second
+)
+
+// AdaptiveQPSConfig configures the AIMD (additive increase, multiplicative
+// decrease) mode of QPSMonitor. The effective limit is cut by DecreaseFactor
+// each time the server answers with QpsLimitExceeded, and raised by
+// IncreaseStep after every IncreaseInterval of successful calls.
+type AdaptiveQPSConfig struct {
+	MinLimit         int32
+	MaxLimit         int32
+	IncreaseStep     int32
+	DecreaseFactor   float64
+	IncreaseInterval time.Duration
+	DecreaseCooldown time.Duration
+}
+
+func (p AdaptiveQPSConfig) normalize(initLimit int32) AdaptiveQPSConfig {
+	if p.MaxLimit <= 0 {
+		p.MaxLimit = initLimit
+	}
+	if p.MinLimit <= 0 {
+		p.MinLimit = 1
+	}
+	if p.MinLimit > p.MaxLimit {
+		p.MinLimit = p.MaxLimit
+	}
+	if p.IncreaseStep <= 0 {
+		p.IncreaseStep = p.MaxLimit / 20
+		if p.IncreaseStep <= 0 {
+			p.IncreaseStep = 1
+		}
+	}
+	if p.DecreaseFactor <= 0 || p.DecreaseFactor >= 1 {
+		p.DecreaseFactor = defaultAdaptiveDecreaseFactor
+	}
+	if p.IncreaseInterval <= 0 {
+		p.IncreaseInterval = defaultAdaptiveIncreaseInterval
+	}
+	if p.DecreaseCooldown <= 0 {
+		p.DecreaseCooldown = defaultAdaptiveDecreaseCooldown
+	}
+	return p
+}
+
 type QPSMonitor struct {
 	qpsLimit     int32
 	latestIndex  int32
 	delaySecond  int32
 	totalQueries []int32
+	windowLocker sync.Mutex
+
+	adaptive       *AdaptiveQPSConfig
+	adaptiveLocker sync.Mutex
+	lastIncrease   time.Time
+	lastDecrease   time.Time
 }
 
 func (p *QPSMonitor) Pulse() {
-	index := p.updateIndex()
-	atomic.AddInt32(&p.totalQueries[index], 1)
+	p.windowLocker.Lock()
+	defer p.windowLocker.Unlock()
+
+	p.totalQueries[p.updateIndexLocked()]++
 }
 
 func (p *QPSMonitor) QPS() int32 {
+	p.windowLocker.Lock()
+	defer p.windowLocker.Unlock()
+
 	var totalCount int32 = 0
 	for i, queryCount := range p.totalQueries {
 		if int32(i) != p.latestIndex {
@@ -27,12 +86,56 @@ func (p *QPSMonitor) QPS() int32 {
 	return totalCount / (p.delaySecond - 1)
 }
 
+// EffectiveLimit returns the qps limit currently enforced, which changes over
+// time when adaptive mode is enabled. Zero means unlimited.
+func (p *QPSMonitor) EffectiveLimit() int32 {
+	return atomic.LoadInt32(&p.qpsLimit)
+}
+
+// EnableAdaptive switches the monitor to AIMD mode, starting from the current
+// limit clamped into [config.MinLimit, config.MaxLimit].
+func (p *QPSMonitor) EnableAdaptive(config AdaptiveQPSConfig) {
+	p.adaptiveLocker.Lock()
+	defer p.adaptiveLocker.Unlock()
+
+	config = config.normalize(p.EffectiveLimit())
+	p.adaptive = &config
+
+	limit := p.EffectiveLimit()
+	if limit <= 0 || limit > config.MaxLimit {
+		limit = config.MaxLimit
+	}
+	if limit < config.MinLimit {
+		limit = config.MinLimit
+	}
+	atomic.StoreInt32(&p.qpsLimit, limit)
+
+	now := time.Now()
+	p.lastIncrease = now
+	p.lastDecrease = time.Time{}
+}
+
+// IsAdaptive reports whether the monitor runs in AIMD mode.
+func (p *QPSMonitor) IsAdaptive() bool {
+	p.adaptiveLocker.Lock()
+	defer p.adaptiveLocker.Unlock()
+
+	return p.adaptive != nil
+}
+
 func (p *QPSMonitor) updateIndex() int32 {
+	p.windowLocker.Lock()
+	defer p.windowLocker.Unlock()
+
+	return p.updateIndexLocked()
+}
+
+func (p *QPSMonitor) updateIndexLocked() int32 {
 	index := int32(time.Now().Second()) % p.delaySecond
 
 	if p.latestIndex != index {
-		atomic.StoreInt32(&p.latestIndex, index)
-		atomic.StoreInt32(&p.totalQueries[p.latestIndex], 0)
+		p.latestIndex = index
+		p.totalQueries[index] = 0
 	}
 
 	return index
@@ -40,14 +143,57 @@ func (p *QPSMonitor) updateIndex() int32 {
 
 func (p *QPSMonitor) checkQPS() {
 	p.Pulse()
-	if p.qpsLimit > 0 {
-		for p.QPS() > p.qpsLimit {
+	if p.EffectiveLimit() > 0 {
+		for p.QPS() > p.EffectiveLimit() {
 			time.Sleep(time.Millisecond * 10)
 			p.updateIndex()
 		}
 	}
 }
 
+// feedback adjusts the effective limit by the result of a request, it is a
+// no-op unless adaptive mode is enabled.
+func (p *QPSMonitor) feedback(err error) {
+	p.adaptiveLocker.Lock()
+	defer p.adaptiveLocker.Unlock()
+
+	if p.adaptive == nil {
+		return
+	}
+
+	now := time.Now()
+	limit := p.EffectiveLimit()
+
+	if IsMNSError(err, ERR_MNS_QPS_LIMIT_EXCEEDED) {
+		if now.Sub(p.lastDecrease) < p.adaptive.DecreaseCooldown {
+			return
+		}
+		limit = int32(float64(limit) * p.adaptive.DecreaseFactor)
+		if limit < p.adaptive.MinLimit {
+			limit = p.adaptive.MinLimit
+		}
+		atomic.StoreInt32(&p.qpsLimit, limit)
+		p.lastDecrease = now
+		p.lastIncrease = now
+		return
+	}
+
+	if err != nil || limit >= p.adaptive.MaxLimit {
+		return
+	}
+
+	if now.Sub(p.lastIncrease) < p.adaptive.IncreaseInterval {
+		return
+	}
+
+	limit += p.adaptive.IncreaseStep
+	if limit > p.adaptive.MaxLimit {
+		limit = p.adaptive.MaxLimit
+	}
+	atomic.StoreInt32(&p.qpsLimit, limit)
+	p.lastIncrease = now
+}
+
 func NewQPSMonitor(delaySecond int32, qpsLimit int32) *QPSMonitor {
 	if delaySecond < 5 {
 		delaySecond = 5
@@ -59,3 +205,26 @@ func NewQPSMonitor(delaySecond int32, qpsLimit int32) *QPSMonitor {
 	}
 	return &monitor
 }
+
+// NewAdaptiveQPSMonitor creates a QPSMonitor in AIMD mode, starting at initLimit.
+func NewAdaptiveQPSMonitor(delaySecond int32, initLimit int32, config AdaptiveQPSConfig) *QPSMonitor {
+	monitor := NewQPSMonitor(delaySecond, initLimit)
+	monitor.EnableAdaptive(config)
+	return monitor
+}
+
+type adaptiveQPSConfigGetter interface {
+	getAdaptiveQPSConfig() *AdaptiveQPSConfig
+}
+
+// newClientQPSMonitor creates the monitor of a queue or topic, switching it to
+// adaptive mode when the client was created with the AdaptiveQPS option.
+func newClientQPSMonitor(client MNSClient, qpsLimit int32) *QPSMonitor {
+	monitor := NewQPSMonitor(5, qpsLimit)
+	if getter, ok := client.(adaptiveQPSConfigGetter); ok {
+		if config := getter.getAdaptiveQPSConfig(); config != nil {
+			monitor.EnableAdaptive(*config)
+		}
+	}
+	return monitor
+}
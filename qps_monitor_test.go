@@ -1,7 +1,13 @@
 package ali_mns
 
 import (
+	"io/ioutil"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/gogap/errors"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestCheckQPS(t *testing.T) {
@@ -15,3 +21,81 @@ func TestCheckQPS(t *testing.T) {
 
 	qm.checkQPS()
 }
+
+func TestCheckQPSConcurrent(t *testing.T) {
+	qm := NewQPSMonitor(5, 1000)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				qm.checkQPS()
+				qm.QPS()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.True(t, qm.QPS() >= 0)
+}
+
+func TestAdaptiveQPSMonitor(t *testing.T) {
+	qm := NewAdaptiveQPSMonitor(5, 100, AdaptiveQPSConfig{
+		MinLimit:         10,
+		MaxLimit:         100,
+		IncreaseStep:     5,
+		IncreaseInterval: time.Millisecond,
+		DecreaseCooldown: time.Hour,
+	})
+	assert.True(t, qm.IsAdaptive())
+	assert.Equal(t, int32(100), qm.EffectiveLimit())
+
+	throttled := ERR_MNS_QPS_LIMIT_EXCEEDED.New(errors.Params{"resp": ErrorResponse{Code: "QpsLimitExceeded"}})
+	qm.feedback(throttled)
+	assert.Equal(t, int32(50), qm.EffectiveLimit())
+
+	// in cooldown, further throttling does not cut the limit again
+	qm.feedback(ErrorResponse{Code: "QpsLimitExceeded"})
+	assert.Equal(t, int32(50), qm.EffectiveLimit())
+
+	// other errors neither increase nor decrease
+	time.Sleep(2 * time.Millisecond)
+	qm.feedback(ERR_MNS_INTERNAL_ERROR.New(errors.Params{"resp": ErrorResponse{}}))
+	assert.Equal(t, int32(50), qm.EffectiveLimit())
+
+	qm.feedback(nil)
+	assert.Equal(t, int32(55), qm.EffectiveLimit())
+
+	for i := 0; i < 20; i++ {
+		time.Sleep(2 * time.Millisecond)
+		qm.feedback(nil)
+	}
+	assert.Equal(t, int32(100), qm.EffectiveLimit())
+}
+
+func TestAdaptiveQPSClientOption(t *testing.T) {
+	cli := NewAliMNSClient("http://123.mns.cn-hangzhou.aliyuncs.com", "id", "secret",
+		AdaptiveQPS(AdaptiveQPSConfig{MaxLimit: 300}))
+
+	queue := NewMNSQueue("test-queue", cli)
+	assert.True(t, queue.QPSMonitor().IsAdaptive())
+	assert.Equal(t, int32(300), queue.QPSMonitor().EffectiveLimit())
+
+	topic := NewMNSTopic("test-topic", cli, 200).(*MNSTopic)
+	assert.True(t, topic.QPSMonitor().IsAdaptive())
+	assert.Equal(t, int32(200), topic.QPSMonitor().EffectiveLimit())
+
+	for _, wrapper := range []MNSClient{
+		NewCircuitBreakerClient(cli, NewCircuitBreaker(CircuitBreakerConfig{})),
+		NewFaultInjectionClient(cli, NewFaultInjector(1)),
+		NewRecordingClient(cli, ioutil.Discard),
+	} {
+		wrapped := NewMNSQueue("test-queue", wrapper)
+		assert.True(t, wrapped.QPSMonitor().IsAdaptive())
+	}
+
+	plain := NewMNSQueue("test-queue", NewAliMNSClient("http://123.mns.cn-hangzhou.aliyuncs.com", "id", "secret"))
+	assert.False(t, plain.QPSMonitor().IsAdaptive())
+}
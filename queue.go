@@ -3,6 +3,7 @@ package ali_mns
 import (
 	"fmt"
 	"net/url"
+	"time"
 )
 
 var (
@@ -46,7 +47,7 @@ func NewMNSQueueWithDecoders(name string, client MNSClient, decoder MNSDecoder,
 	if qps != nil && len(qps) == 1 && qps[0] > 0 {
 		qpsLimit = qps[0]
 	}
-	queue.qpsMonitor = NewQPSMonitor(5, qpsLimit)
+	queue.qpsMonitor = newClientQPSMonitor(client, qpsLimit)
 	return queue
 }
 
@@ -65,21 +66,94 @@ func (p *MNSQueue) Name() string {
 func (p *MNSQueue) SendMessage(message MessageSendRequest, opts ...Option) (resp MessageSendResponse, err error) {
 	p.qpsMonitor.checkQPS()
 	_, err = send(p.client, p.decoder, POST, nil, message, fmt.Sprintf("queues/%s/%s", p.name, "messages"), &resp, opts...)
+	p.qpsMonitor.feedback(err)
 	return
 }
 
+// BatchSendMessage sends messages in as many requests as the batch limits of
+// 16 messages and 64KB need, resp.Messages[i] is the outcome of messages[i].
+// Entries failed with InternalError or QpsLimitExceeded, alone or with their
+// whole request, are sent again up to twice, 100ms and then 200ms later, so
+// the call may block for 300ms more than its requests take. If any entry
+// still fails err is ERR_MNS_BATCH_OP_FAIL, if a whole request fails with
+// another error it is returned at once and the entries not sent yet are left
+// empty. resp.RequestID is the id of the last request, resp.Err carries the
+// id of the request each message was last sent in. resp.Err, Succeeded and
+// Failed map the outcomes back to messages.
 func (p *MNSQueue) BatchSendMessage(messages ...MessageSendRequest) (resp BatchMessageSendResponse, err error) {
 	if messages == nil || len(messages) == 0 {
 		return
 	}
 
+	resp.Messages = make([]BatchMessageSendEntry, len(messages))
+	resp.requestIDs = make([]string, len(messages))
+	pending := make([]int, len(messages))
+	for i := range pending {
+		pending[i] = i
+	}
+
+	for attempt := 0; len(pending) > 0; attempt++ {
+		if attempt > 0 {
+			time.Sleep(batchSendRetryInterval * time.Duration(attempt))
+		}
+
+		var retry []int
+		for _, chunk := range batchChunks(messages, pending) {
+			chunkResp, e := p.batchSend(messages, chunk)
+			if e != nil && !IsMNSError(e, ERR_MNS_BATCH_OP_FAIL) {
+				code := MNSErrorCode(e)
+				if !retryableBatchErrorCodes[code] {
+					resp.requestErr = e
+					err = e
+					return
+				}
+
+				for _, index := range chunk {
+					resp.Messages[index] = BatchMessageSendEntry{ErrorCode: code, ErrorMessage: e.Error()}
+					resp.requestIDs[index] = ""
+				}
+				retry = append(retry, chunk...)
+				continue
+			}
+
+			if chunkResp.RequestID != "" {
+				resp.RequestID = chunkResp.RequestID
+			}
+			for i, index := range chunk {
+				if i < len(chunkResp.Messages) {
+					resp.Messages[index] = chunkResp.Messages[i]
+				}
+				resp.requestIDs[index] = chunkResp.RequestID
+				if retryableBatchErrorCodes[resp.Messages[index].ErrorCode] {
+					retry = append(retry, index)
+				}
+			}
+		}
+
+		if attempt == batchSendRetries {
+			break
+		}
+		pending = retry
+	}
+
+	for _, entry := range resp.Messages {
+		if entry.ErrorCode != "" {
+			err = ERR_MNS_BATCH_OP_FAIL.New()
+			break
+		}
+	}
+	return
+}
+
+func (p *MNSQueue) batchSend(messages []MessageSendRequest, indexes []int) (resp BatchMessageSendResponse, err error) {
 	batchRequest := BatchMessageSendRequest{}
-	for _, message := range messages {
-		batchRequest.Messages = append(batchRequest.Messages, message)
+	for _, index := range indexes {
+		batchRequest.Messages = append(batchRequest.Messages, messages[index])
 	}
 
 	p.qpsMonitor.checkQPS()
 	_, err = send(p.client, p.newBatchOpDecoder(&resp), POST, nil, batchRequest, fmt.Sprintf("queues/%s/%s", p.name, "messages"), &resp)
+	p.qpsMonitor.feedback(err)
 	return
 }
 
@@ -94,6 +168,7 @@ func (p *MNSQueue) ReceiveMessage(respChan chan MessageReceiveResponse, errChan
 			p.qpsMonitor.checkQPS()
 			resp := MessageReceiveResponse{}
 			_, err := send(p.client, p.decoder, GET, nil, nil, resource, &resp)
+			p.qpsMonitor.feedback(err)
 			if err != nil {
 				// if no
 				errChan <- err
@@ -107,6 +182,7 @@ func (p *MNSQueue) ReceiveMessage(respChan chan MessageReceiveResponse, errChan
 		p.qpsMonitor.checkQPS()
 		resp := MessageReceiveResponse{}
 		_, err := send(p.client, p.decoder, GET, nil, nil, resource, &resp)
+		p.qpsMonitor.feedback(err)
 		if err != nil {
 			errChan <- err
 		} else {
@@ -132,6 +208,7 @@ func (p *MNSQueue) BatchReceiveMessage(respChan chan BatchMessageReceiveResponse
 			p.qpsMonitor.checkQPS()
 			resp := BatchMessageReceiveResponse{}
 			_, err := send(p.client, p.decoder, GET, nil, nil, resource, &resp)
+			p.qpsMonitor.feedback(err)
 			if err != nil {
 				errChan <- err
 			} else {
@@ -143,6 +220,7 @@ func (p *MNSQueue) BatchReceiveMessage(respChan chan BatchMessageReceiveResponse
 		p.qpsMonitor.checkQPS()
 		resp := BatchMessageReceiveResponse{}
 		_, err := send(p.client, p.decoder, GET, nil, nil, resource, &resp)
+		p.qpsMonitor.feedback(err)
 		if err != nil {
 			errChan <- err
 		} else {
@@ -156,6 +234,7 @@ func (p *MNSQueue) PeekMessage(respChan chan MessageReceiveResponse, errChan cha
 	p.qpsMonitor.checkQPS()
 	resp := MessageReceiveResponse{}
 	_, err := send(p.client, p.decoder, GET, nil, nil, fmt.Sprintf("queues/%s/%s?peekonly=true", p.name, "messages"), &resp)
+	p.qpsMonitor.feedback(err)
 	if err != nil {
 		errChan <- err
 	} else {
@@ -172,6 +251,7 @@ func (p *MNSQueue) BatchPeekMessage(respChan chan BatchMessageReceiveResponse, e
 	p.qpsMonitor.checkQPS()
 	resp := BatchMessageReceiveResponse{}
 	_, err := send(p.client, p.decoder, GET, nil, nil, fmt.Sprintf("queues/%s/%s?numOfMessages=%d&peekonly=true", p.name, "messages", numOfMessages), &resp)
+	p.qpsMonitor.feedback(err)
 	if err != nil {
 		errChan <- err
 	} else {
@@ -183,9 +263,12 @@ func (p *MNSQueue) BatchPeekMessage(respChan chan BatchMessageReceiveResponse, e
 func (p *MNSQueue) DeleteMessage(receiptHandle string) (err error) {
 	p.qpsMonitor.checkQPS()
 	_, err = send(p.client, p.decoder, DELETE, nil, nil, fmt.Sprintf("queues/%s/%s?ReceiptHandle=%s", p.name, "messages", url.QueryEscape(receiptHandle)), nil)
+	p.qpsMonitor.feedback(err)
 	return
 }
 
+// BatchDeleteMessage deletes receiptHandles in one request, resp.Err,
+// Succeeded and Failed tell the outcome of each handle.
 func (p *MNSQueue) BatchDeleteMessage(receiptHandles ...string) (resp BatchMessageDeleteErrorResponse, err error) {
 	if receiptHandles == nil || len(receiptHandles) == 0 {
 		return
@@ -199,6 +282,12 @@ func (p *MNSQueue) BatchDeleteMessage(receiptHandles ...string) (resp BatchMessa
 
 	p.qpsMonitor.checkQPS()
 	_, err = send(p.client, p.newBatchOpDecoder(&resp), DELETE, nil, handlers, fmt.Sprintf("queues/%s/%s", p.name, "messages"), nil)
+	p.qpsMonitor.feedback(err)
+
+	resp.ReceiptHandles = receiptHandles
+	if err != nil && !IsMNSError(err, ERR_MNS_BATCH_OP_FAIL) {
+		resp.requestErr = err
+	}
 
 	return
 }
@@ -206,5 +295,6 @@ func (p *MNSQueue) BatchDeleteMessage(receiptHandles ...string) (resp BatchMessa
 func (p *MNSQueue) ChangeMessageVisibility(receiptHandle string, visibilityTimeout int64) (resp MessageVisibilityChangeResponse, err error) {
 	p.qpsMonitor.checkQPS()
 	_, err = send(p.client, p.decoder, PUT, nil, nil, fmt.Sprintf("queues/%s/%s?ReceiptHandle=%s&VisibilityTimeout=%d", p.name, "messages", url.QueryEscape(receiptHandle), visibilityTimeout), &resp)
+	p.qpsMonitor.feedback(err)
 	return
 }
@@ -1,10 +1,12 @@
 package ali_mns
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/gogap/errors"
 )
@@ -16,8 +18,20 @@ type AliQueueManager interface {
 	GetQueueAttributes(queueName string) (attr QueueAttribute, err error)
 	DeleteQueue(queueName string) (err error)
 	ListQueue(nextMarker string, retNumber int32, prefix string) (queues Queues, err error)
+	PurgeQueue(ctx context.Context, queueName string, onProgress func(PurgeProgress), qps ...int32) (deleted int64, err error)
 }
 
+// PurgeProgress is reported by PurgeQueue after each batch delete, Failed
+// counts the handles the service refused, whose messages are purged once
+// they become visible again.
+type PurgeProgress struct {
+	Deleted int64
+	Failed  int64
+}
+
+// purgeWorkers is how many batch deletes PurgeQueue runs in parallel.
+const purgeWorkers = 4
+
 type MNSQueueManager struct {
 	cli     MNSClient
 	decoder MNSDecoder
@@ -32,7 +46,7 @@ func checkQueueName(queueName string) (err error) {
 }
 
 func checkDelaySeconds(seconds int32) (err error) {
-	if seconds > 60480 || seconds < 0 {
+	if seconds > MaxDelaySeconds || seconds < 0 {
 		err = ERR_MNS_DELAY_SECONDS_RANGE_ERROR.New()
 		return
 	}
@@ -217,3 +231,99 @@ func (p *MNSQueueManager) ListQueue(nextMarker string, retNumber int32, prefix s
 
 	return
 }
+
+// PurgeQueue deletes the messages of a queue without deleting the queue. It
+// receives and deletes batches until GetQueueAttributes reports no active
+// message, messages in flight to other consumers and delayed ones are left.
+// Requests are limited to qps per second, as for NewMNSQueue. onProgress may
+// be nil, its calls are serialized. deleted counts the messages deleted
+// before err, if any.
+func (p *MNSQueueManager) PurgeQueue(ctx context.Context, queueName string, onProgress func(PurgeProgress), qps ...int32) (deleted int64, err error) {
+	queueName = strings.TrimSpace(queueName)
+
+	if err = checkQueueName(queueName); err != nil {
+		return
+	}
+
+	queue := NewMNSQueue(queueName, p.cli, qps...)
+
+	var locker sync.Mutex
+	var progress PurgeProgress
+	var deleteErr error
+
+	batches := make(chan []string, purgeWorkers)
+	wg := sync.WaitGroup{}
+	for i := 0; i < purgeWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for handles := range batches {
+				resp, err := queue.BatchDeleteMessage(handles...)
+				requestFailed := err != nil && !IsMNSError(err, ERR_MNS_BATCH_OP_FAIL)
+				refused := int64(len(resp.FailedMessages))
+				if requestFailed {
+					refused = int64(len(handles))
+				}
+
+				locker.Lock()
+				progress.Deleted += int64(len(handles)) - refused
+				progress.Failed += refused
+				if requestFailed && deleteErr == nil {
+					deleteErr = err
+				}
+				if onProgress != nil {
+					onProgress(progress)
+				}
+				locker.Unlock()
+			}
+		}()
+	}
+
+	failed := func() error {
+		locker.Lock()
+		defer locker.Unlock()
+		return deleteErr
+	}
+
+receive:
+	for {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		if err = failed(); err != nil {
+			break
+		}
+
+		respChan := make(chan BatchMessageReceiveResponse, 1)
+		errChan := make(chan error, 1)
+		queue.BatchReceiveMessage(respChan, errChan, batchMaxMessages)
+
+		select {
+		case batch := <-respChan:
+			handles := make([]string, 0, len(batch.Messages))
+			for _, message := range batch.Messages {
+				handles = append(handles, message.ReceiptHandle)
+			}
+			select {
+			case batches <- handles:
+			case <-ctx.Done():
+			}
+		case err = <-errChan:
+			if !IsMNSError(err, ERR_MNS_MESSAGE_NOT_EXIST) {
+				break receive
+			}
+
+			var attr QueueAttribute
+			if attr, err = p.GetQueueAttributes(queueName); err != nil || attr.ActiveMessages == 0 {
+				break receive
+			}
+		}
+	}
+
+	close(batches)
+	wg.Wait()
+	if err == nil {
+		err = deleteErr
+	}
+	return progress.Deleted, err
+}
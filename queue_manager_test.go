@@ -0,0 +1,49 @@
+package ali_mns
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPurgeQueue(t *testing.T) {
+	emulator := NewMNSEmulator("", "")
+	manager := NewMNSQueueManager(emulator)
+	assert.Nil(t, manager.CreateSimpleQueue("test-queue"))
+	queue := NewMNSQueue("test-queue", emulator)
+
+	for i := 0; i < 50; i++ {
+		_, err := queue.SendMessage(MessageSendRequest{MessageBody: fmt.Sprintf("message-%d", i)})
+		assert.Nil(t, err)
+	}
+	_, err := queue.SendMessage(MessageSendRequest{MessageBody: "delayed", DelaySeconds: 60})
+	assert.Nil(t, err)
+
+	var last PurgeProgress
+	calls := 0
+	deleted, err := manager.PurgeQueue(context.Background(), "test-queue", func(progress PurgeProgress) {
+		calls++
+		assert.True(t, progress.Deleted >= last.Deleted)
+		last = progress
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(50), deleted)
+	assert.Equal(t, PurgeProgress{Deleted: 50}, last)
+	assert.True(t, calls >= 4)
+
+	attr, err := manager.GetQueueAttributes("test-queue")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), attr.ActiveMessages+attr.InactiveMessages)
+	assert.Equal(t, int64(1), attr.DelayMessages)
+
+	_, err = manager.PurgeQueue(context.Background(), "no-queue", nil, 100)
+	assert.True(t, IsMNSError(err, ERR_MNS_QUEUE_NOT_EXIST))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	deleted, err = manager.PurgeQueue(ctx, "test-queue", nil)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, int64(0), deleted)
+}
@@ -0,0 +1,174 @@
+package ali_mns
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gogap/errors"
+)
+
+// Message is a received message bound to its queue. It keeps the receipt
+// handle current across visibility changes and refuses to act on a handle
+// whose visibility timeout passed, or once the message was acked or nacked.
+// It is safe for concurrent use.
+type Message struct {
+	queue AliMNSQueue
+
+	locker  sync.Mutex
+	resp    MessageReceiveResponse
+	settled bool
+}
+
+func NewMessage(queue AliMNSQueue, resp MessageReceiveResponse) *Message {
+	return &Message{queue: queue, resp: resp}
+}
+
+// Receive receives one message of queue, see AliMNSQueue.ReceiveMessage.
+// Non-positive waitseconds are ignored, with none left it receives once
+// without waiting.
+func Receive(queue AliMNSQueue, waitseconds ...int64) (*Message, error) {
+	waitseconds = positiveWaitSeconds(waitseconds)
+	respChan := make(chan MessageReceiveResponse, 1)
+	errChan := make(chan error, len(waitseconds)+1)
+	queue.ReceiveMessage(respChan, errChan, waitseconds...)
+
+	select {
+	case resp := <-respChan:
+		return NewMessage(queue, resp), nil
+	default:
+		return nil, <-errChan
+	}
+}
+
+// ReceiveBatch receives up to numOfMessages messages of queue, see
+// AliMNSQueue.BatchReceiveMessage. waitseconds are handled as by Receive.
+func ReceiveBatch(queue AliMNSQueue, numOfMessages int32, waitseconds ...int64) ([]*Message, error) {
+	waitseconds = positiveWaitSeconds(waitseconds)
+	respChan := make(chan BatchMessageReceiveResponse, 1)
+	errChan := make(chan error, len(waitseconds)+1)
+	queue.BatchReceiveMessage(respChan, errChan, numOfMessages, waitseconds...)
+
+	select {
+	case batch := <-respChan:
+		messages := make([]*Message, 0, len(batch.Messages))
+		for _, resp := range batch.Messages {
+			messages = append(messages, NewMessage(queue, resp))
+		}
+		return messages, nil
+	default:
+		return nil, <-errChan
+	}
+}
+
+// positiveWaitSeconds drops the waitseconds a queue skips, a receive given
+// only those would answer nothing.
+func positiveWaitSeconds(waitseconds []int64) (positive []int64) {
+	for _, waitsecond := range waitseconds {
+		if waitsecond > 0 {
+			positive = append(positive, waitsecond)
+		}
+	}
+	return
+}
+
+// Response returns the message as received, with its current receipt handle
+// and NextVisibleTime.
+func (p *Message) Response() MessageReceiveResponse {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	return p.resp
+}
+
+func (p *Message) MessageId() string {
+	return p.resp.MessageId
+}
+
+func (p *Message) Body() string {
+	return p.resp.MessageBody
+}
+
+func (p *Message) ReceiptHandle() string {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	return p.resp.ReceiptHandle
+}
+
+// Deadline returns when the message becomes visible again and its receipt
+// handle expires, zero if the service did not tell.
+func (p *Message) Deadline() time.Time {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	return p.deadline()
+}
+
+// Ack deletes the message.
+func (p *Message) Ack() error {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if err := p.check(); err != nil {
+		return err
+	}
+	if err := p.queue.DeleteMessage(p.resp.ReceiptHandle); err != nil {
+		return err
+	}
+	p.settled = true
+	return nil
+}
+
+// Nack makes the message visible again after delay, at once if it is zero.
+func (p *Message) Nack(delay time.Duration) error {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if err := p.check(); err != nil {
+		return err
+	}
+	if _, err := p.queue.ChangeMessageVisibility(p.resp.ReceiptHandle, visibilitySeconds(delay)); err != nil {
+		return err
+	}
+	p.settled = true
+	return nil
+}
+
+// Extend keeps the message invisible for d from now, rounded up to seconds.
+func (p *Message) Extend(d time.Duration) error {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if err := p.check(); err != nil {
+		return err
+	}
+	resp, err := p.queue.ChangeMessageVisibility(p.resp.ReceiptHandle, visibilitySeconds(d))
+	if err != nil {
+		return err
+	}
+	p.resp.ReceiptHandle = resp.ReceiptHandle
+	p.resp.NextVisibleTime = resp.NextVisibleTime
+	return nil
+}
+
+func (p *Message) deadline() time.Time {
+	if p.resp.NextVisibleTime <= 0 {
+		return time.Time{}
+	}
+	return millisecondsTime(p.resp.NextVisibleTime)
+}
+
+// check returns why the handle can not be used, nil if it can.
+func (p *Message) check() error {
+	if p.settled {
+		return ERR_MNS_MESSAGE_SETTLED.New(errors.Params{"id": p.resp.MessageId})
+	}
+	if deadline := p.deadline(); !deadline.IsZero() && !time.Now().Before(deadline) {
+		return ERR_MNS_RECEIPT_HANDLE_EXPIRED.New(errors.Params{"handle": p.resp.ReceiptHandle, "time": deadline})
+	}
+	return nil
+}
+
+func visibilitySeconds(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64((d + time.Second - 1) / time.Second)
+}
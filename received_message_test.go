@@ -0,0 +1,95 @@
+package ali_mns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageExtendAndAck(t *testing.T) {
+	emulator := NewMNSEmulator("", "")
+	manager := NewMNSQueueManager(emulator)
+	assert.Nil(t, manager.CreateSimpleQueue("test-queue"))
+	queue := NewMNSQueue("test-queue", emulator)
+
+	_, err := queue.SendMessage(MessageSendRequest{MessageBody: "hello"})
+	assert.Nil(t, err)
+	message, err := Receive(queue)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", message.Body())
+
+	received := message.ReceiptHandle()
+	assert.Nil(t, message.Extend(time.Minute))
+	assert.NotEqual(t, received, message.ReceiptHandle())
+	assert.WithinDuration(t, time.Now().Add(time.Minute), message.Deadline(), 2*time.Second)
+
+	// the handle received is stale now
+	assert.True(t, IsMNSError(queue.DeleteMessage(received), ERR_MNS_RECEIPT_HANDLE_ERROR))
+
+	assert.Nil(t, message.Ack())
+	assert.True(t, IsMNSError(message.Ack(), ERR_MNS_MESSAGE_SETTLED))
+	assert.True(t, IsMNSError(message.Extend(time.Minute), ERR_MNS_MESSAGE_SETTLED))
+
+	attr, err := manager.GetQueueAttributes("test-queue")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), attr.ActiveMessages+attr.InactiveMessages)
+}
+
+func TestMessageNack(t *testing.T) {
+	emulator := NewMNSEmulator("", "")
+	assert.Nil(t, NewMNSQueueManager(emulator).CreateSimpleQueue("test-queue"))
+	queue := NewMNSQueue("test-queue", emulator)
+
+	_, err := queue.BatchSendMessage(MessageSendRequest{MessageBody: "a"}, MessageSendRequest{MessageBody: "b"})
+	assert.Nil(t, err)
+	messages, err := ReceiveBatch(queue, 16)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(messages))
+
+	assert.Nil(t, messages[0].Nack(0))
+	assert.True(t, IsMNSError(messages[0].Ack(), ERR_MNS_MESSAGE_SETTLED))
+
+	again, err := Receive(queue)
+	assert.Nil(t, err)
+	assert.Equal(t, messages[0].MessageId(), again.MessageId())
+	assert.Equal(t, int64(2), again.Response().DequeueCount)
+
+	_, err = Receive(queue)
+	assert.True(t, IsMNSError(err, ERR_MNS_MESSAGE_NOT_EXIST))
+}
+
+func TestMessageExpired(t *testing.T) {
+	emulator := NewMNSEmulator("", "")
+	assert.Nil(t, NewMNSQueueManager(emulator).CreateQueue("test-queue", 0, 65536, 345600, 1, 0, 2))
+	queue := NewMNSQueue("test-queue", emulator)
+
+	_, err := queue.SendMessage(MessageSendRequest{MessageBody: "hello"})
+	assert.Nil(t, err)
+	message, err := Receive(queue)
+	assert.Nil(t, err)
+
+	time.Sleep(time.Until(message.Deadline()) + 10*time.Millisecond)
+	assert.True(t, IsMNSError(message.Ack(), ERR_MNS_RECEIPT_HANDLE_EXPIRED))
+	assert.True(t, IsMNSError(message.Extend(time.Minute), ERR_MNS_RECEIPT_HANDLE_EXPIRED))
+}
+
+func TestReceiveNonPositiveWaitSeconds(t *testing.T) {
+	emulator := NewMNSEmulator("", "")
+	assert.Nil(t, NewMNSQueueManager(emulator).CreateSimpleQueue("test-queue"))
+	queue := NewMNSQueue("test-queue", emulator)
+
+	_, err := Receive(queue, 0)
+	assert.True(t, IsMNSError(err, ERR_MNS_MESSAGE_NOT_EXIST))
+	_, err = ReceiveBatch(queue, 16, 0, -1)
+	assert.True(t, IsMNSError(err, ERR_MNS_MESSAGE_NOT_EXIST))
+
+	_, err = queue.BatchSendMessage(MessageSendRequest{MessageBody: "first"}, MessageSendRequest{MessageBody: "second"})
+	assert.Nil(t, err)
+	message, err := Receive(queue, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "first", message.Body())
+	messages, err := ReceiveBatch(queue, 16, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(messages))
+}
@@ -0,0 +1,147 @@
+package ali_mns
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// MaxDelaySeconds is the longest DelaySeconds of a queue, and the longest
+// hop of a message sent by SendMessageAt.
+const MaxDelaySeconds = 60480
+
+// a message scheduled past MaxDelaySeconds is in an envelope with its target
+// time in unix milliseconds, e.g. "mns:at=1700000000000;..."
+const scheduleEnvelope = "at"
+
+// SendMessageAt sends message to be delivered at the given time, its
+// DelaySeconds is computed. Times further than MaxDelaySeconds away are
+// reached in several hops by a queue wrapped with NewScheduledQueue, the
+// message carries its target time and is sent again until it is due.
+func SendMessageAt(queue AliMNSQueue, message MessageSendRequest, at time.Time, opts ...Option) (resp MessageSendResponse, err error) {
+	delay := delaySecondsUntil(at)
+	if delay > MaxDelaySeconds {
+		message.MessageBody = sealEnvelope(scheduleEnvelope, strconv.FormatInt(at.UnixNano()/int64(time.Millisecond), 10),
+			message.MessageBody)
+		delay = MaxDelaySeconds
+	} else {
+		message.MessageBody = escapeEnvelope(message.MessageBody, scheduleEnvelope)
+	}
+
+	message.DelaySeconds = delay
+	return queue.SendMessage(message, opts...)
+}
+
+// delaySecondsUntil rounds up so a message is never delivered early.
+func delaySecondsUntil(at time.Time) int64 {
+	delay := time.Until(at)
+	if delay <= 0 {
+		return 0
+	}
+	return int64((delay + time.Second - 1) / time.Second)
+}
+
+// splitSchedule returns the target time and body of a scheduled message, the
+// time is zero for a body escaped by SendMessageAt.
+func splitSchedule(body string) (at time.Time, rest string, ok bool) {
+	value, payload, ok := openEnvelope(body, scheduleEnvelope)
+	if !ok || value == "" {
+		return at, payload, ok
+	}
+
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return at, "", false
+	}
+	return millisecondsTime(ms), payload, true
+}
+
+type scheduledQueue struct {
+	AliMNSQueue
+}
+
+// NewScheduledQueue wraps queue to take the next hop of the messages sent
+// by SendMessageAt that are received before they are due: they are sent
+// again with the remaining delay and deleted, and are not delivered. A
+// receive left with no due message fails with ERR_MNS_MESSAGE_NOT_EXIST,
+// like one of an empty queue. If the delete of a hop fails the message may be
+// delivered twice. Peeks show the messages as they are.
+func NewScheduledQueue(queue AliMNSQueue) AliMNSQueue {
+	return &scheduledQueue{AliMNSQueue: queue}
+}
+
+func (p *scheduledQueue) ReceiveMessage(respChan chan MessageReceiveResponse, errChan chan error, waitseconds ...int64) {
+	relayReceive(func(innerChan chan MessageReceiveResponse) {
+		p.AliMNSQueue.ReceiveMessage(innerChan, errChan, waitseconds...)
+	}, respChan, errChan, func(message *MessageReceiveResponse) error {
+		due, err := p.resolve(message)
+		if err == nil && !due {
+			err = p.noDueMessage()
+		}
+		return err
+	})
+}
+
+// BatchReceiveMessage drops the messages not due yet from the batch. A
+// message whose hop fails is dropped too and taken again once its visibility
+// timeout passes; the batch fails with the error of the first such hop only
+// if no message is left.
+func (p *scheduledQueue) BatchReceiveMessage(respChan chan BatchMessageReceiveResponse, errChan chan error, numOfMessages int32, waitseconds ...int64) {
+	relayBatchReceive(func(innerChan chan BatchMessageReceiveResponse) {
+		p.AliMNSQueue.BatchReceiveMessage(innerChan, errChan, numOfMessages, waitseconds...)
+	}, respChan, errChan, func(batch *BatchMessageReceiveResponse) error {
+		var messages []MessageReceiveResponse
+		var hopErr error
+		for _, message := range batch.Messages {
+			due, err := p.resolve(&message)
+			if err != nil {
+				if hopErr == nil {
+					hopErr = err
+				}
+				continue
+			}
+			if due {
+				messages = append(messages, message)
+			}
+		}
+
+		if len(messages) == 0 {
+			if hopErr != nil {
+				return hopErr
+			}
+			return p.noDueMessage()
+		}
+		batch.Messages = messages
+		return nil
+	})
+}
+
+// resolve strips the schedule of a due message, or sends the next hop of one
+// that is not.
+func (p *scheduledQueue) resolve(message *MessageReceiveResponse) (due bool, err error) {
+	at, body, ok := splitSchedule(message.MessageBody)
+	if !ok {
+		return true, nil
+	}
+
+	delay := delaySecondsUntil(at)
+	if delay == 0 {
+		message.MessageBody = body
+		return true, nil
+	}
+	if delay > MaxDelaySeconds {
+		delay = MaxDelaySeconds
+	}
+
+	hop := MessageSendRequest{MessageBody: message.MessageBody, DelaySeconds: delay, Priority: message.Priority}
+	if _, err = p.AliMNSQueue.SendMessage(hop); err != nil {
+		return
+	}
+	err = p.AliMNSQueue.DeleteMessage(message.ReceiptHandle)
+	return
+}
+
+func (p *scheduledQueue) noDueMessage() error {
+	return ParseError(ErrorResponse{Code: "MessageNotExist", Message: "no scheduled message is due."},
+		fmt.Sprintf("queues/%s/%s", p.Name(), "messages"))
+}
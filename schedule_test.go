@@ -0,0 +1,124 @@
+package ali_mns
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gogap/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendMessageAt(t *testing.T) {
+	emulator := NewMNSEmulator("", "")
+	manager := NewMNSQueueManager(emulator)
+	assert.Nil(t, manager.CreateSimpleQueue("test-queue"))
+	queue := NewMNSQueue("test-queue", emulator)
+
+	_, err := SendMessageAt(queue, MessageSendRequest{MessageBody: "past"}, time.Now().Add(-time.Hour))
+	assert.Nil(t, err)
+	message, err := receiveOne(queue)
+	assert.Nil(t, err)
+	assert.Equal(t, "past", message.MessageBody)
+
+	_, err = SendMessageAt(queue, MessageSendRequest{MessageBody: "soon"}, time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	_, err = SendMessageAt(queue, MessageSendRequest{MessageBody: "weeks"}, time.Now().Add(21*24*time.Hour))
+	assert.Nil(t, err)
+
+	attr, err := manager.GetQueueAttributes("test-queue")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), attr.DelayMessages)
+}
+
+func TestScheduledQueueHops(t *testing.T) {
+	emulator := NewMNSEmulator("", "")
+	manager := NewMNSQueueManager(emulator)
+	assert.Nil(t, manager.CreateSimpleQueue("test-queue"))
+	raw := NewMNSQueue("test-queue", emulator)
+	queue := NewScheduledQueue(raw)
+
+	// a hop arriving while its target is still weeks away
+	at := time.Now().Add(21 * 24 * time.Hour)
+	body := sealEnvelope(scheduleEnvelope, strconv.FormatInt(at.UnixNano()/int64(time.Millisecond), 10), "reminder")
+	_, err := raw.SendMessage(MessageSendRequest{MessageBody: body})
+	assert.Nil(t, err)
+
+	_, err = receiveOne(queue)
+	assert.True(t, IsMNSError(err, ERR_MNS_MESSAGE_NOT_EXIST))
+
+	attr, err := manager.GetQueueAttributes("test-queue")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), attr.ActiveMessages+attr.InactiveMessages)
+	assert.Equal(t, int64(1), attr.DelayMessages)
+
+	// the last hop is delivered without its schedule
+	due := sealEnvelope(scheduleEnvelope, strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10), "due")
+	_, err = raw.BatchSendMessage(MessageSendRequest{MessageBody: due}, MessageSendRequest{MessageBody: body}, MessageSendRequest{MessageBody: "plain"})
+	assert.Nil(t, err)
+
+	respChan := make(chan BatchMessageReceiveResponse, 1)
+	queue.BatchReceiveMessage(respChan, make(chan error, 1), 16)
+	var bodies []string
+	for _, message := range (<-respChan).Messages {
+		bodies = append(bodies, message.MessageBody)
+	}
+	assert.ElementsMatch(t, []string{"due", "plain"}, bodies)
+
+	// plain bodies are never taken for a hop
+	_, err = SendMessageAt(raw, MessageSendRequest{MessageBody: body}, time.Now())
+	assert.Nil(t, err)
+	_, err = raw.SendMessage(MessageSendRequest{MessageBody: "at=1;plain"})
+	assert.Nil(t, err)
+	queue.BatchReceiveMessage(respChan, make(chan error, 1), 16)
+	bodies = nil
+	for _, message := range (<-respChan).Messages {
+		bodies = append(bodies, message.MessageBody)
+	}
+	assert.ElementsMatch(t, []string{body, "at=1;plain"}, bodies)
+
+	attr, err = manager.GetQueueAttributes("test-queue")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), attr.DelayMessages)
+}
+
+// unsendableQueue fails every single send with err.
+type unsendableQueue struct {
+	AliMNSQueue
+	err error
+}
+
+func (p *unsendableQueue) SendMessage(message MessageSendRequest, opts ...Option) (MessageSendResponse, error) {
+	return MessageSendResponse{}, p.err
+}
+
+func TestScheduledQueueHopFailure(t *testing.T) {
+	emulator := NewMNSEmulator("", "")
+	manager := NewMNSQueueManager(emulator)
+	assert.Nil(t, manager.CreateSimpleQueue("test-queue"))
+	raw := NewMNSQueue("test-queue", emulator)
+	queue := NewScheduledQueue(&unsendableQueue{AliMNSQueue: raw, err: ERR_MNS_QPS_LIMIT_EXCEEDED.New(errors.Params{"resp": ErrorResponse{}, "resource": "queues/test-queue/messages"})})
+
+	at := time.Now().Add(21 * 24 * time.Hour)
+	hop := sealEnvelope(scheduleEnvelope, strconv.FormatInt(at.UnixNano()/int64(time.Millisecond), 10), "reminder")
+	_, err := raw.BatchSendMessage(MessageSendRequest{MessageBody: hop}, MessageSendRequest{MessageBody: "plain"})
+	assert.Nil(t, err)
+
+	// the due message is delivered, the failed hop is left in the queue
+	respChan := make(chan BatchMessageReceiveResponse, 1)
+	queue.BatchReceiveMessage(respChan, make(chan error, 1), 16)
+	messages := (<-respChan).Messages
+	assert.Equal(t, 1, len(messages))
+	assert.Equal(t, "plain", messages[0].MessageBody)
+
+	attr, err := manager.GetQueueAttributes("test-queue")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), attr.InactiveMessages)
+
+	// a batch of failed hops only fails with their error
+	_, err = raw.SendMessage(MessageSendRequest{MessageBody: hop})
+	assert.Nil(t, err)
+	errChan := make(chan error, 1)
+	queue.BatchReceiveMessage(respChan, errChan, 16)
+	assert.True(t, IsMNSError(<-errChan, ERR_MNS_QPS_LIMIT_EXCEEDED))
+}
@@ -0,0 +1,136 @@
+package ali_mns
+
+import (
+	"crypto/hmac"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultMaxDateSkew = 15 * time.Minute
+
+	authorizationPrefix = "MNS "
+)
+
+// SecretLookup returns the access key secret of accessKeyId.
+type SecretLookup func(accessKeyId string) (accessKeySecret string, exist bool)
+
+// SignatureVerifier checks the Authorization header of incoming MNS requests,
+// the inverse of AliMNSCredential.Signature. Failures are reported with the
+// same error codes the service uses, e.g. ERR_MNS_SIGNATURE_DOES_NOT_MATCH.
+type SignatureVerifier struct {
+	lookup      SecretLookup
+	maxDateSkew time.Duration
+}
+
+// NewSignatureVerifier creates a verifier, a maxDateSkew of zero means
+// DefaultMaxDateSkew and a negative one disables the Date check.
+func NewSignatureVerifier(lookup SecretLookup, maxDateSkew time.Duration) *SignatureVerifier {
+	if maxDateSkew == 0 {
+		maxDateSkew = DefaultMaxDateSkew
+	}
+	return &SignatureVerifier{lookup: lookup, maxDateSkew: maxDateSkew}
+}
+
+// NewStaticSignatureVerifier creates a verifier accepting a single key.
+func NewStaticSignatureVerifier(accessKeyId, accessKeySecret string, maxDateSkew time.Duration) *SignatureVerifier {
+	return NewSignatureVerifier(func(id string) (string, bool) {
+		return accessKeySecret, id == accessKeyId
+	}, maxDateSkew)
+}
+
+// ParseAuthorization splits an "MNS accessKeyId:signature" header.
+func ParseAuthorization(authorization string, resource string) (accessKeyId, signature string, err error) {
+	if authorization == "" {
+		err = verifyError("MissingAuthorizationHeader", "Authorization header is missing.", resource)
+		return
+	}
+
+	pieces := strings.SplitN(strings.TrimPrefix(authorization, authorizationPrefix), ":", 2)
+	if !strings.HasPrefix(authorization, authorizationPrefix) || len(pieces) != 2 ||
+		pieces[0] == "" || pieces[1] == "" {
+		err = verifyError("InvalidAuthorizationHeader", "Authorization header is not valid.", resource)
+		return
+	}
+
+	return pieces[0], pieces[1], nil
+}
+
+// Verify checks a request, headers holds Content-MD5, Content-Type, Date and
+// the lower cased x-mns-* headers, resource is the request uri including the
+// leading slash and the query string.
+func (p *SignatureVerifier) Verify(method Method, authorization string, headers map[string]string, resource string) (accessKeyId string, err error) {
+	accessKeyId, signature, err := ParseAuthorization(authorization, resource)
+	if err != nil {
+		return
+	}
+
+	date, exist := headers[DATE]
+	if !exist || date == "" {
+		err = verifyError("MissingDateHeader", "Date header is missing.", resource)
+		return
+	}
+
+	t, e := http.ParseTime(date)
+	if e != nil {
+		err = verifyError("InvalidDateHeader", "Date header is not valid: "+e.Error(), resource)
+		return
+	}
+
+	if p.maxDateSkew > 0 {
+		skew := time.Since(t)
+		if skew < 0 {
+			skew = -skew
+		}
+		if skew > p.maxDateSkew {
+			err = verifyError("TimeExpired", "The request is expired, date: "+date, resource)
+			return
+		}
+	}
+
+	accessKeySecret, exist := p.lookup(accessKeyId)
+	if !exist {
+		err = verifyError("InvalidAccessKeyId", "The access key id you provided does not exist.", resource)
+		return
+	}
+
+	expected, err := signString(accessKeySecret, stringToSign(method, headers, resource, date))
+	if err != nil {
+		return
+	}
+
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		err = verifyError("SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided.", resource)
+		return
+	}
+
+	return
+}
+
+// VerifyRequest checks an incoming http request.
+func (p *SignatureVerifier) VerifyRequest(r *http.Request) (accessKeyId string, err error) {
+	return p.Verify(Method(r.Method), r.Header.Get(AUTHORIZATION), SignHeaders(r.Header), r.RequestURI)
+}
+
+// SignHeaders picks the headers covered by the signature out of h, with the
+// x-mns-* names lower cased the way clients sign them.
+func SignHeaders(h http.Header) map[string]string {
+	headers := map[string]string{}
+	for _, key := range []string{CONTENT_MD5, CONTENT_TYPE, DATE} {
+		if v := h.Get(key); v != "" {
+			headers[key] = v
+		}
+	}
+	for key, values := range h {
+		key = strings.ToLower(key)
+		if strings.HasPrefix(key, "x-mns-") && len(values) > 0 {
+			headers[key] = values[0]
+		}
+	}
+	return headers
+}
+
+func verifyError(code, message, resource string) error {
+	return ParseError(ErrorResponse{Code: code, Message: message}, resource)
+}
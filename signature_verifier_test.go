@@ -0,0 +1,78 @@
+package ali_mns
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func signedHeaders(t *testing.T, secret string, date time.Time, resource string) (string, map[string]string) {
+	headers := map[string]string{
+		CONTENT_MD5:        "ZDQxZDhjZDk4ZjAwYjIwNGU5ODAwOTk4ZWNmODQyN2U=",
+		CONTENT_TYPE:       "application/xml",
+		DATE:               date.UTC().Format(http.TimeFormat),
+		MQ_VERSION:         version,
+		"x-mns-ret-number": "10",
+	}
+	signature, err := NewAliMNSCredential(secret).Signature(GET, headers, resource)
+	assert.Nil(t, err)
+	return "MNS test-id:" + signature, headers
+}
+
+func TestSignatureVerifier(t *testing.T) {
+	verifier := NewStaticSignatureVerifier("test-id", "test-secret", time.Minute)
+
+	authorization, headers := signedHeaders(t, "test-secret", time.Now(), "/queues?x=1")
+	accessKeyId, err := verifier.Verify(GET, authorization, headers, "/queues?x=1")
+	assert.Nil(t, err)
+	assert.Equal(t, "test-id", accessKeyId)
+
+	_, err = verifier.Verify(GET, authorization, headers, "/queues?x=2")
+	assert.True(t, IsMNSError(err, ERR_MNS_SIGNATURE_DOES_NOT_MATCH))
+
+	headers["x-mns-ret-number"] = "11"
+	_, err = verifier.Verify(GET, authorization, headers, "/queues?x=1")
+	assert.True(t, IsMNSError(err, ERR_MNS_SIGNATURE_DOES_NOT_MATCH))
+	assert.Equal(t, "SignatureDoesNotMatch", MNSErrorCode(err))
+
+	authorization, headers = signedHeaders(t, "wrong-secret", time.Now(), "/queues")
+	_, err = verifier.Verify(GET, authorization, headers, "/queues")
+	assert.True(t, IsMNSError(err, ERR_MNS_SIGNATURE_DOES_NOT_MATCH))
+
+	authorization, headers = signedHeaders(t, "test-secret", time.Now().Add(-time.Hour), "/queues")
+	_, err = verifier.Verify(GET, authorization, headers, "/queues")
+	assert.True(t, IsMNSError(err, ERR_MNS_TIME_EXPIRED))
+
+	_, err = NewStaticSignatureVerifier("test-id", "test-secret", -1).Verify(GET, authorization, headers, "/queues")
+	assert.Nil(t, err)
+
+	authorization, headers = signedHeaders(t, "test-secret", time.Now(), "/queues")
+	_, err = NewStaticSignatureVerifier("other-id", "test-secret", 0).Verify(GET, authorization, headers, "/queues")
+	assert.True(t, IsMNSError(err, ERR_MNS_INVALID_ACCESS_KEY_ID))
+
+	_, err = verifier.Verify(GET, "", headers, "/queues")
+	assert.True(t, IsMNSError(err, ERR_MNS_MISSING_AUTHORIZATION_HEADER))
+
+	_, err = verifier.Verify(GET, "Basic abc", headers, "/queues")
+	assert.True(t, IsMNSError(err, ERR_MNS_INVALID_AUTHORIZATION_HEADER))
+
+	delete(headers, DATE)
+	_, err = verifier.Verify(GET, authorization, headers, "/queues")
+	assert.True(t, IsMNSError(err, ERR_MNS_MISSING_DATE_HEADER))
+}
+
+func TestSignHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set(CONTENT_MD5, "md5")
+	h.Set(DATE, "date")
+	h.Set("X-Mns-Version", version)
+	h.Set("Host", "localhost")
+
+	assert.Equal(t, map[string]string{
+		CONTENT_MD5:     "md5",
+		DATE:            "date",
+		"x-mns-version": version,
+	}, SignHeaders(h))
+}
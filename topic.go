@@ -46,7 +46,7 @@ func NewMNSTopic(name string, client MNSClient, qps ...int32) AliMNSTopic {
 	if qps != nil && len(qps) == 1 && qps[0] > 0 {
 		qpsLimit = qps[0]
 	}
-	topic.qpsMonitor = NewQPSMonitor(5, qpsLimit)
+	topic.qpsMonitor = newClientQPSMonitor(client, qpsLimit)
 	return topic
 }
 
@@ -64,10 +64,14 @@ func NewMNSTopicWithDecoders(name string, client MNSClient, decoder MNSDecoder,
 	if qps != nil && len(qps) == 1 && qps[0] > 0 {
 		qpsLimit = qps[0]
 	}
-	topic.qpsMonitor = NewQPSMonitor(5, qpsLimit)
+	topic.qpsMonitor = newClientQPSMonitor(client, qpsLimit)
 	return topic
 }
 
+func (p *MNSTopic) QPSMonitor() *QPSMonitor {
+	return p.qpsMonitor
+}
+
 func (p *MNSTopic) Name() string {
 	return p.name
 }
@@ -90,6 +94,7 @@ func (p *MNSTopic) GenerateExtendEndpoint(serviceName string, endpointUrl string
 func (p *MNSTopic) PublishMessage(message MessagePublishRequest) (resp MessageSendResponse, err error) {
 	p.qpsMonitor.checkQPS()
 	_, err = send(p.client, p.decoder, POST, nil, message, fmt.Sprintf("topics/%s/%s", p.name, "messages"), &resp)
+	p.qpsMonitor.feedback(err)
 	return
 }
 
@@ -104,6 +109,7 @@ func (p *MNSTopic) Subscribe(subscriptionName string, message MessageSubsribeReq
 
 	var code int
 	code, err = send(p.client, p.decoder, PUT, nil, message, fmt.Sprintf("topics/%s/subscriptions/%s", p.name, subscriptionName), nil)
+	p.qpsMonitor.feedback(err)
 
 	if code == http.StatusNoContent {
 		err = ERR_MNS_SUBSCRIPTION_ALREADY_EXIST_AND_HAVE_SAME_ATTR.New(errors.Params{"name": subscriptionName})
@@ -125,6 +131,7 @@ func (p *MNSTopic) SetSubscriptionAttributes(subscriptionName string, notifyStra
 
 	p.qpsMonitor.checkQPS()
 	_, err = send(p.client, p.decoder, PUT, nil, message, fmt.Sprintf("topics/%s/subscriptions/%s?metaoverride=true", p.name, subscriptionName), nil)
+	p.qpsMonitor.feedback(err)
 	return
 }
 
@@ -57,3 +57,53 @@ func send(client MNSClient, decoder MNSDecoder, method Method,
 
 	return
 }
+
+// relayReceive runs receive with a channel of its own and forwards every
+// response to respChan once resolve succeeded, or the error of resolve to
+// errChan. It returns when receive does, like the receive methods wrapped.
+func relayReceive(receive func(chan MessageReceiveResponse), respChan chan MessageReceiveResponse,
+	errChan chan error, resolve func(*MessageReceiveResponse) error) {
+	innerChan := make(chan MessageReceiveResponse)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		receive(innerChan)
+	}()
+
+	for {
+		select {
+		case resp := <-innerChan:
+			if err := resolve(&resp); err != nil {
+				errChan <- err
+			} else {
+				respChan <- resp
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// relayBatchReceive is relayReceive for batch receives.
+func relayBatchReceive(receive func(chan BatchMessageReceiveResponse), respChan chan BatchMessageReceiveResponse,
+	errChan chan error, resolve func(*BatchMessageReceiveResponse) error) {
+	innerChan := make(chan BatchMessageReceiveResponse)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		receive(innerChan)
+	}()
+
+	for {
+		select {
+		case resp := <-innerChan:
+			if err := resolve(&resp); err != nil {
+				errChan <- err
+			} else {
+				respChan <- resp
+			}
+		case <-done:
+			return
+		}
+	}
+}
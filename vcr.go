@@ -0,0 +1,267 @@
+package ali_mns
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/gogap/errors"
+	"github.com/valyala/fasthttp"
+)
+
+const redacted = "REDACTED"
+
+// CassetteEntry is one recorded request and its response, a cassette holds
+// one entry per line as json.
+type CassetteEntry struct {
+	Operation       string            `json:"operation"`
+	Method          Method            `json:"method"`
+	Resource        string            `json:"resource"`
+	RequestHeaders  map[string]string `json:"request_headers,omitempty"`
+	RequestBody     string            `json:"request_body,omitempty"`
+	StatusCode      int               `json:"status_code,omitempty"`
+	ResponseHeaders map[string]string `json:"response_headers,omitempty"`
+	ResponseBody    string            `json:"response_body,omitempty"`
+	// Error is the transport error of the request, if any.
+	Error string `json:"error,omitempty"`
+
+	AccountId string `json:"account_id,omitempty"`
+	Region    string `json:"region,omitempty"`
+}
+
+// ReadCassette reads the entries of a cassette written by a recording client.
+func ReadCassette(r io.Reader) (entries []CassetteEntry, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		var entry CassetteEntry
+		if e := json.Unmarshal(scanner.Bytes(), &entry); e != nil {
+			err = ERR_MNS_VCR_INVALID_CASSETTE.New(errors.Params{"line": line, "err": e})
+			return
+		}
+		entries = append(entries, entry)
+	}
+
+	if e := scanner.Err(); e != nil {
+		err = ERR_MNS_VCR_INVALID_CASSETTE.New(errors.Params{"line": line, "err": e})
+	}
+	return
+}
+
+type recordingClient struct {
+	MNSClient
+
+	locker  sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewRecordingClient sends requests through client and writes each of them
+// with its response to w as a cassette entry. The Authorization and
+// security-token headers are redacted.
+func NewRecordingClient(client MNSClient, w io.Writer) MNSClient {
+	return &recordingClient{MNSClient: client, encoder: json.NewEncoder(w)}
+}
+
+func (p *recordingClient) Send(method Method, headers map[string]string, message interface{}, resource string, opts ...Option) (*fasthttp.Response, error) {
+	// the client adds the signed headers to the map it is given
+	if headers == nil {
+		headers = make(map[string]string)
+	}
+
+	body, _ := marshalMessage(message)
+	resp, err := p.MNSClient.Send(method, headers, message, resource, opts...)
+
+	entry := CassetteEntry{
+		Operation:      operationName(method, resource, message),
+		Method:         method,
+		Resource:       resource,
+		RequestHeaders: redactHeaders(headers),
+		RequestBody:    string(body),
+		AccountId:      p.MNSClient.getAccountID(),
+		Region:         p.MNSClient.getRegion(),
+	}
+
+	if err != nil {
+		entry.Error = err.Error()
+	} else if resp != nil {
+		entry.StatusCode = resp.Header.StatusCode()
+		entry.ResponseBody = string(resp.Body())
+		entry.ResponseHeaders = map[string]string{}
+		resp.Header.VisitAll(func(key, value []byte) {
+			switch string(key) {
+			case fasthttp.HeaderContentLength, fasthttp.HeaderConnection:
+				return
+			}
+			entry.ResponseHeaders[string(key)] = string(value)
+		})
+	}
+
+	p.locker.Lock()
+	e := p.encoder.Encode(entry)
+	p.locker.Unlock()
+
+	if e != nil {
+		return resp, ERR_MNS_VCR_RECORD_FAILED.New(errors.Params{"err": e})
+	}
+	return resp, err
+}
+
+func (p *recordingClient) getAdaptiveQPSConfig() *AdaptiveQPSConfig {
+	if getter, ok := p.MNSClient.(adaptiveQPSConfigGetter); ok {
+		return getter.getAdaptiveQPSConfig()
+	}
+	return nil
+}
+
+func redactHeaders(headers map[string]string) map[string]string {
+	sanitized := make(map[string]string, len(headers))
+	for key, value := range headers {
+		switch strings.ToLower(key) {
+		case strings.ToLower(AUTHORIZATION), strings.ToLower(SECURITY_TOKEN):
+			value = redacted
+		}
+		sanitized[key] = value
+	}
+	return sanitized
+}
+
+type ReplayMode int
+
+const (
+	// ReplayInOrder serves the entries one after another, each request must
+	// have the method and resource of the next entry.
+	ReplayInOrder ReplayMode = iota
+	// ReplayMatching serves the first unused entry with the method, resource
+	// and body of the request.
+	ReplayMatching
+)
+
+// ReplayClient is an MNSClient answering from a cassette without any network
+// access. Requests without a recorded response fail with
+// ERR_MNS_VCR_UNMATCHED_REQUEST and are kept for Unmatched.
+type ReplayClient struct {
+	mode ReplayMode
+
+	locker    sync.Mutex
+	entries   []CassetteEntry
+	used      []bool
+	next      int
+	unmatched []string
+}
+
+func NewReplayClient(r io.Reader, mode ReplayMode) (*ReplayClient, error) {
+	entries, err := ReadCassette(r)
+	if err != nil {
+		return nil, err
+	}
+	return NewReplayClientFromEntries(entries, mode), nil
+}
+
+func NewReplayClientFromEntries(entries []CassetteEntry, mode ReplayMode) *ReplayClient {
+	return &ReplayClient{
+		mode:    mode,
+		entries: entries,
+		used:    make([]bool, len(entries)),
+	}
+}
+
+func (p *ReplayClient) Send(method Method, headers map[string]string, message interface{}, resource string, opts ...Option) (*fasthttp.Response, error) {
+	body, err := marshalMessage(message)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, exist := p.take(method, resource, string(body))
+	if !exist {
+		return nil, ERR_MNS_VCR_UNMATCHED_REQUEST.New(errors.Params{"method": method, "resource": resource})
+	}
+
+	if entry.Error != "" {
+		return nil, ERR_SEND_REQUEST_FAILED.New(errors.Params{"err": entry.Error})
+	}
+
+	resp := fasthttp.AcquireResponse()
+	resp.SetStatusCode(entry.StatusCode)
+	for key, value := range entry.ResponseHeaders {
+		resp.Header.Set(key, value)
+	}
+	resp.SetBody([]byte(entry.ResponseBody))
+	return resp, nil
+}
+
+func (p *ReplayClient) take(method Method, resource, body string) (entry CassetteEntry, exist bool) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	index := -1
+	switch p.mode {
+	case ReplayInOrder:
+		if p.next < len(p.entries) && p.entries[p.next].Method == method && p.entries[p.next].Resource == resource {
+			index = p.next
+			p.next++
+		}
+	case ReplayMatching:
+		for i, e := range p.entries {
+			if !p.used[i] && e.Method == method && e.Resource == resource && e.RequestBody == body {
+				index = i
+				break
+			}
+		}
+	}
+
+	if index < 0 {
+		p.unmatched = append(p.unmatched, fmt.Sprintf("%s %s", method, resource))
+		return
+	}
+
+	p.used[index] = true
+	return p.entries[index], true
+}
+
+// Unmatched returns the requests that had no recorded response.
+func (p *ReplayClient) Unmatched() []string {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	return append([]string(nil), p.unmatched...)
+}
+
+// Remaining returns how many entries were not served yet.
+func (p *ReplayClient) Remaining() (count int) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	for _, used := range p.used {
+		if !used {
+			count++
+		}
+	}
+	return
+}
+
+func (p *ReplayClient) SetProxy(url string) {
+}
+
+func (p *ReplayClient) getAccountID() string {
+	if len(p.entries) > 0 {
+		return p.entries[0].AccountId
+	}
+	return ""
+}
+
+func (p *ReplayClient) getRegion() string {
+	if len(p.entries) > 0 {
+		return p.entries[0].Region
+	}
+	return ""
+}
@@ -0,0 +1,98 @@
+package ali_mns
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newEmulatorServerClient(opts ...Option) (MNSClient, func()) {
+	emulator := NewMNSEmulator("", "")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		statusCode, headers, respBody := emulator.Handle(Method(r.Method), strings.TrimPrefix(r.RequestURI, "/"), SignHeaders(r.Header), body)
+		for key, value := range headers {
+			w.Header().Set(key, value)
+		}
+		w.WriteHeader(statusCode)
+		w.Write(respBody)
+	}))
+
+	addr := server.Listener.Addr().String()
+	opts = append(opts, Dial(func(string) (net.Conn, error) {
+		return net.Dial("tcp", addr)
+	}))
+	return NewAliMNSClient(emulator.Endpoint(), "test-id", "test-secret", opts...), server.Close
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	client, closeServer := newEmulatorServerClient(SecurityToken("secret-token"))
+	defer closeServer()
+
+	cassette := new(bytes.Buffer)
+	recording := NewRecordingClient(client, cassette)
+	assert.Nil(t, NewMNSQueueManager(recording).CreateSimpleQueue("vcr"))
+	queue := NewMNSQueue("vcr", recording)
+	sendResp, err := queue.SendMessage(MessageSendRequest{MessageBody: "recorded"})
+	assert.Nil(t, err)
+	_, err = NewMNSQueueManager(recording).GetQueueAttributes("missing")
+	assert.True(t, IsMNSError(err, ERR_MNS_QUEUE_NOT_EXIST))
+
+	assert.False(t, strings.Contains(cassette.String(), "secret-token"))
+	assert.False(t, strings.Contains(cassette.String(), "MNS test-id:"))
+
+	entries, err := ReadCassette(bytes.NewReader(cassette.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(entries))
+	assert.Equal(t, "SendMessage", entries[1].Operation)
+	assert.Equal(t, redacted, entries[1].RequestHeaders[AUTHORIZATION])
+	assert.Equal(t, redacted, entries[1].RequestHeaders[SECURITY_TOKEN])
+
+	replay := NewReplayClientFromEntries(entries, ReplayInOrder)
+	assert.Nil(t, NewMNSQueueManager(replay).CreateSimpleQueue("vcr"))
+	replayed, err := NewMNSQueue("vcr", replay).SendMessage(MessageSendRequest{MessageBody: "recorded"})
+	assert.Nil(t, err)
+	assert.Equal(t, sendResp.MessageId, replayed.MessageId)
+	assert.Equal(t, sendResp.RequestID, replayed.RequestID)
+	_, err = NewMNSQueueManager(replay).GetQueueAttributes("missing")
+	assert.True(t, IsMNSError(err, ERR_MNS_QUEUE_NOT_EXIST))
+	assert.Equal(t, 0, replay.Remaining())
+
+	_, err = NewMNSQueue("vcr", replay).SendMessage(MessageSendRequest{MessageBody: "recorded"})
+	assert.True(t, IsMNSError(err, ERR_MNS_VCR_UNMATCHED_REQUEST))
+	assert.Equal(t, []string{"POST queues/vcr/messages"}, replay.Unmatched())
+}
+
+func TestReplayMatching(t *testing.T) {
+	emulator := NewMNSEmulator("", "")
+	assert.Nil(t, NewMNSQueueManager(emulator).CreateSimpleQueue("vcr"))
+
+	cassette := new(bytes.Buffer)
+	queue := NewMNSQueue("vcr", NewRecordingClient(emulator, cassette))
+	first, _ := queue.SendMessage(MessageSendRequest{MessageBody: "first"})
+	second, _ := queue.SendMessage(MessageSendRequest{MessageBody: "second"})
+
+	replay, err := NewReplayClient(bytes.NewReader(cassette.Bytes()), ReplayMatching)
+	assert.Nil(t, err)
+	assert.Equal(t, emulator.getRegion(), replay.getRegion())
+
+	replayQueue := NewMNSQueue("vcr", replay)
+	resp, err := replayQueue.SendMessage(MessageSendRequest{MessageBody: "second"})
+	assert.Nil(t, err)
+	assert.Equal(t, second.MessageId, resp.MessageId)
+	resp, err = replayQueue.SendMessage(MessageSendRequest{MessageBody: "first"})
+	assert.Nil(t, err)
+	assert.Equal(t, first.MessageId, resp.MessageId)
+
+	_, err = replayQueue.SendMessage(MessageSendRequest{MessageBody: "third"})
+	assert.True(t, IsMNSError(err, ERR_MNS_VCR_UNMATCHED_REQUEST))
+
+	_, err = NewReplayClient(strings.NewReader("{not json}\n"), ReplayInOrder)
+	assert.True(t, IsMNSError(err, ERR_MNS_VCR_INVALID_CASSETTE))
+}